@@ -0,0 +1,222 @@
+package main
+
+// config applies the configuration file to the command line flags and reloads it while the exporter is running
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+)
+
+const configPollInterval = 10 * time.Second
+
+// reloadableCollector is registered with prometheus once and serves the collectors built from the current configuration.
+// Replacing the collectors waits for in-flight scrapes, so a scrape never sees a partially applied configuration.
+// The flags, paths and logger are only changed while holding its lock, so everything reading them while the exporter
+// runs holds the read lock.
+type reloadableCollector struct {
+	mu         sync.RWMutex
+	collectors collectors.SriovCollector
+	// sampler is the pod traffic sampler of the custom metrics API, nil if the API is not served
+	sampler *collectors.PodTrafficSampler
+}
+
+func newReloadableCollector(c collectors.SriovCollector) *reloadableCollector {
+	return &reloadableCollector{collectors: c}
+}
+
+// Collect metrics from the current collectors
+func (c *reloadableCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.collectors.Collect(ch)
+}
+
+// Describe the current collectors
+func (c *reloadableCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.collectors.Describe(ch)
+}
+
+// setSampler sets the pod traffic sampler rebuilt with the collectors
+func (c *reloadableCollector) setSampler(sampler *collectors.PodTrafficSampler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sampler = sampler
+}
+
+// sample reads the pod traffic with the current configuration
+func (c *reloadableCollector) sample() ([]collectors.PodTraffic, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sampler.Sample()
+}
+
+// configLoader applies a configuration file to the command line flags. Flags set on the command line take precedence.
+type configLoader struct {
+	path        string
+	commandLine map[string]bool
+	applied     map[string]string
+	modTime     time.Time
+	size        int64
+}
+
+// newConfigLoader returns a loader for the configuration file that keeps the values of the given command line flags
+func newConfigLoader(path string, commandLine map[string]bool) *configLoader {
+	return &configLoader{
+		path:        path,
+		commandLine: commandLine,
+	}
+}
+
+// load reads the configuration file and applies it to the flags
+func (l *configLoader) load() error {
+	if info, err := os.Stat(l.path); err == nil {
+		l.modTime, l.size = info.ModTime(), info.Size()
+	}
+
+	values, err := config.Load(l.path)
+	if err != nil {
+		return err
+	}
+
+	applied, err := config.Apply(flag.CommandLine, values, l.commandLine, l.applied)
+	if err != nil {
+		return err
+	}
+
+	l.applied = applied
+	return nil
+}
+
+// changed checks if the configuration file was modified since it was last loaded
+func (l *configLoader) changed() bool {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false
+	}
+
+	return !info.ModTime().Equal(l.modTime) || info.Size() != l.size
+}
+
+// reload applies the configuration file again and replaces the collectors whose flags changed and the request limits.
+// The configuration is validated before it is applied, if it is invalid the flags are restored and the running
+// configuration is kept.
+func (l *configLoader) reload(collector *reloadableCollector, limiter *rate.Limiter) error {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	snapshot := config.Snapshot(flag.CommandLine)
+	applied := l.applied
+
+	err := l.load()
+	if err == nil {
+		err = validateFlags()
+	}
+
+	if err != nil {
+		config.Restore(flag.CommandLine, snapshot)
+		l.applied = applied
+		return err
+	}
+
+	if listenAddress := snapshot["web.listen-address"]; *addr != listenAddress {
+		slog.Warn("web.listen-address can not be changed while running", "address", listenAddress)
+		*addr = listenAddress
+	}
+
+	for name, value := range snapshot {
+		if requiresRestart(name) && flag.Lookup(name).Value.String() != value {
+			slog.Warn(name+" can not be changed while running", "value", value)
+			_ = flag.Set(name, value)
+		}
+	}
+
+	if err := resolveFilepaths(); err != nil {
+		config.Restore(flag.CommandLine, snapshot)
+		l.applied = applied
+		if restoreErr := resolveFilepaths(); restoreErr != nil {
+			slog.Error("failed to restore running paths", "err", restoreErr)
+		}
+		return err
+	}
+
+	changed := changedFlags(snapshot)
+	if changed["log.level"] || changed["log.format"] || changed["log.dedup-interval"] {
+		if err := configureLogging(); err != nil {
+			slog.Error("failed to configure logging", "err", err)
+		}
+	}
+
+	collector.collectors = collectors.Rebuild(collector.collectors, changed)
+	if collector.sampler != nil {
+		collector.sampler.Rebuild(changed)
+	}
+	limiter.SetLimit(rate.Limit(*rateLimit))
+	limiter.SetBurst(*rateBurst)
+
+	return nil
+}
+
+// changedFlags returns the names of the flags whose value differs from the snapshot
+func changedFlags(snapshot map[string]string) map[string]bool {
+	changed := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) {
+		if value, ok := snapshot[f.Name]; !ok || value != f.Value.String() {
+			changed[f.Name] = true
+		}
+	})
+
+	return changed
+}
+
+// requiresRestart returns true for the flags that are only read on start, such as the otlp and push modes
+func requiresRestart(name string) bool {
+	return strings.HasPrefix(name, "otlp.") || strings.HasPrefix(name, "push.") || strings.HasPrefix(name, "kube.") ||
+		strings.HasPrefix(name, "custommetrics.") ||
+		name == "web.enable-openmetrics" || name == "web.openmetrics-created-samples"
+}
+
+// watch reloads the configuration when the exporter receives SIGHUP or the configuration file changes, until the context is done
+func (l *configLoader) watch(ctx context.Context, collector *reloadableCollector, limiter *rate.Limiter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("received SIGHUP, reloading config file", "path", l.path)
+		case <-ticker.C:
+			if !l.changed() {
+				continue
+			}
+			slog.Info("config file changed, reloading", "path", l.path)
+		}
+
+		if err := l.reload(collector, limiter); err != nil {
+			slog.Error("failed to reload config file, keeping running configuration", "path", l.path, "err", err)
+			continue
+		}
+
+		slog.Info("config file reloaded", "path", l.path)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+)
+
+var _ = Describe("test config file reload", func() { // configLoader.reload
+	var (
+		configPath string
+		paths      string
+		collector  *reloadableCollector
+		limiter    *rate.Limiter
+	)
+
+	writeConfig := func(data string) {
+		Expect(os.WriteFile(configPath, []byte(paths+data), 0o600)).To(Succeed())
+		// Ensure the modification time moves forward on filesystems with a coarse timestamp resolution.
+		future := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(configPath, future, future)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(func() {
+			config.Restore(flag.CommandLine, snapshot)
+		})
+
+		dir := GinkgoT().TempDir()
+		for _, d := range []string{"pci", "net", "cgroup", "node"} {
+			Expect(os.Mkdir(filepath.Join(dir, d), 0o700)).To(Succeed())
+		}
+		for _, f := range []string{"cpu_manager_state", "kubelet.sock"} {
+			Expect(os.WriteFile(filepath.Join(dir, f), nil, 0o600)).To(Succeed())
+		}
+
+		paths = fmt.Sprintf(`paths:
+  sysbuspci: %[1]s/pci
+  sysclassnet: %[1]s/net
+  kubecgroup: %[1]s/cgroup
+  nodecpuinfo: %[1]s/node
+  cpucheckpoint: %[1]s/cpu_manager_state
+  kubeletsocket: %[1]s/kubelet.sock
+`, dir)
+		configPath = filepath.Join(dir, "config.yaml")
+
+		collector = newReloadableCollector(collectors.SriovCollector{})
+		limiter = rate.NewLimiter(1, 10)
+	})
+
+	It("applies a valid configuration", func() {
+		writeConfig("web: {rate-limit: 5, rate-burst: 20}\ncollectors: {vfstats: true}")
+		loader := newConfigLoader(configPath, nil)
+
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+		Expect(limiter.Limit()).To(Equal(rate.Limit(5)))
+		Expect(limiter.Burst()).To(Equal(20))
+		Expect(collector.collectors).To(HaveLen(1))
+		Expect(loader.changed()).To(BeFalse())
+	})
+
+	It("rebuilds the pod traffic sampler", func() {
+		writeConfig("")
+		loader := newConfigLoader(configPath, nil)
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+
+		sampler := collectors.NewPodTrafficSampler()
+		collector.setSampler(sampler)
+		writeConfig("collectors: {vfstatspriority: [netlink]}")
+
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+		Expect(collector.sampler).To(BeIdenticalTo(sampler))
+	})
+
+	It("keeps the running configuration when the new one is invalid", func() {
+		writeConfig("web: {rate-limit: 5}")
+		loader := newConfigLoader(configPath, nil)
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+
+		writeConfig("web: {rate-limit: 7}\ncollectors: {vfstatspriority: [ethtool]}")
+		Expect(loader.changed()).To(BeTrue())
+		Expect(loader.reload(collector, limiter)).To(MatchError(ContainSubstring("unknown stats reader 'ethtool'")))
+		Expect(*rateLimit).To(Equal(5))
+		Expect(limiter.Limit()).To(Equal(rate.Limit(5)))
+	})
+
+	It("rejects an invalid log level", func() {
+		writeConfig("log: {level: verbose}")
+		loader := newConfigLoader(configPath, nil)
+
+		Expect(loader.reload(collector, limiter)).To(MatchError(ContainSubstring("invalid log level 'verbose'")))
+		Expect(*logLevel).To(Equal("info"))
+	})
+
+	It("does not change the listen address while running", func() {
+		listenAddress := *addr
+		writeConfig("web: {listen-address: ':1'}")
+		loader := newConfigLoader(configPath, nil)
+
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+		Expect(*addr).To(Equal(listenAddress))
+	})
+
+	It("does not change the otlp and push modes while running", func() {
+		writeConfig("otlp: {endpoint: 'collector:4317', interval: 1m}\npush: {mode: pushgateway, url: 'http://pushgateway:9091'}")
+		loader := newConfigLoader(configPath, nil)
+
+		Expect(loader.reload(collector, limiter)).To(Succeed())
+		Expect(*otlpEndpoint).To(BeEmpty())
+		Expect(*otlpInterval).To(Equal(defaultOTLPInterval))
+		Expect(*pushMode).To(BeEmpty())
+	})
+
+	It("rejects an invalid otlp protocol", func() {
+		writeConfig("otlp: {endpoint: 'collector:4317', protocol: udp}")
+		loader := newConfigLoader(configPath, nil)
+
+		Expect(loader.reload(collector, limiter)).To(MatchError(ContainSubstring("unknown protocol 'udp'")))
+	})
+
+	It("stops watching when the context is done", func() {
+		writeConfig("")
+		loader := newConfigLoader(configPath, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		go func() {
+			loader.watch(ctx, collector, limiter)
+			close(done)
+		}()
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
+
+var _ = Describe("test reloadable collector", func() { // reloadableCollector
+	It("collects from the current collectors", func() {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge"})
+		collector := newReloadableCollector(collectors.SriovCollector{})
+
+		ch := make(chan prometheus.Metric, 1)
+		collector.Collect(ch)
+		Expect(ch).To(BeEmpty())
+
+		collector.collectors = collectors.SriovCollector{gauge}
+		collector.Collect(ch)
+		Expect(ch).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,222 @@
+package main
+
+// custommetrics serves the vf traffic rates of the pods through the Kubernetes custom metrics API, so the Horizontal
+// Pod Autoscaler can scale on them without Prometheus and prometheus-adapter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/custommetrics"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+const (
+	defaultCustomMetricsInterval = 15 * time.Second
+	customMetricsPeerTimeout     = 5 * time.Second
+)
+
+var (
+	customMetricsAddress = flag.String("custommetrics.listen-address", "",
+		"Address to serve the custom metrics API on over TLS, e.g. :6443. The API is not served if not set.")
+	customMetricsCertFile = flag.String("custommetrics.tls-cert-file", "",
+		"Path to the TLS certificate of the custom metrics API, a self-signed certificate is generated if not set.")
+	customMetricsKeyFile = flag.String("custommetrics.tls-key-file", "",
+		"Path to the TLS key of the custom metrics API.")
+	customMetricsInterval = flag.Duration("custommetrics.interval", defaultCustomMetricsInterval,
+		"Interval in which the vf stats are read to compute the rates served by the custom metrics API.")
+	customMetricsPeerNamespace = flag.String("custommetrics.peer-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the exporters on the other nodes, asked for the rates of their pods. Only the pods of the node are served if not set.")
+	customMetricsPeerSelector = flag.String("custommetrics.peer-selector", "app.kubernetes.io/name=sriov-metrics-exporter",
+		"Label selector of the exporters on the other nodes.")
+	customMetricsPeerCAFile = flag.String("custommetrics.peer-ca-file", "",
+		"Path to the CA of the custom metrics API certificates of the exporters, verifying the exporters on the other nodes. They are not asked if not set.")
+	customMetricsPeerServerName = flag.String("custommetrics.peer-server-name", "sriov-metrics-exporter-custom-metrics.monitoring.svc",
+		"Name the custom metrics API certificates of the exporters on the other nodes are verified for.")
+)
+
+// customMetricsEnabled returns true if the custom metrics API is served
+func customMetricsEnabled() bool {
+	return *customMetricsAddress != ""
+}
+
+func verifyCustomMetricsFlags() error {
+	if !customMetricsEnabled() {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(*customMetricsAddress); err != nil {
+		return fmt.Errorf("invalid custommetrics.listen-address\n%v", err)
+	}
+	if (*customMetricsCertFile == "") != (*customMetricsKeyFile == "") {
+		return fmt.Errorf("custommetrics.tls-cert-file and custommetrics.tls-key-file must be set together")
+	}
+	if *customMetricsInterval <= 0 {
+		return fmt.Errorf("custommetrics.interval must be positive")
+	}
+	if _, err := labels.Parse(*customMetricsPeerSelector); err != nil {
+		return fmt.Errorf("invalid custommetrics.peer-selector\n%v", err)
+	}
+	if *customMetricsPeerCAFile != "" && *customMetricsCertFile == "" {
+		return fmt.Errorf("custommetrics.peer-ca-file requires custommetrics.tls-cert-file, the peers can not verify a self-signed certificate")
+	}
+
+	return nil
+}
+
+// startCustomMetrics samples the pod traffic and serves the custom metrics API until the context is done, if enabled.
+// It must be called after startKube, which watches the pods served. The pod traffic is sampled with the configuration
+// of the collector, which rebuilds the sampler on reload.
+func startCustomMetrics(ctx context.Context, workers *sync.WaitGroup, collector *reloadableCollector) error {
+	if !customMetricsEnabled() {
+		return nil
+	}
+
+	cert, err := customMetricsCertificate()
+	if err != nil {
+		return fmt.Errorf("could not load custom metrics certificate\n%v", err)
+	}
+
+	client, _, err := newKubeClients()
+	if err != nil {
+		return fmt.Errorf("could not create kubernetes client\n%v", err)
+	}
+
+	auth := custommetrics.Authenticator{}
+	auth.FrontProxyCAs, auth.FrontProxyNames, err = custommetrics.LoadFrontProxyAuthentication(ctx, client)
+	if err != nil {
+		return fmt.Errorf("could not read the client ca of the kubernetes api server\n%v", err)
+	}
+
+	var (
+		peers      custommetrics.Peers
+		peerClient *http.Client
+	)
+	switch {
+	case *customMetricsPeerNamespace == "":
+	case *customMetricsPeerCAFile == "":
+		slog.Info("custommetrics.peer-ca-file not set, only the pods of the node are served")
+	default:
+		auth.PeerCAs, err = customMetricsPeerCAs()
+		if err != nil {
+			return fmt.Errorf("could not load custom metrics peer ca\n%v", err)
+		}
+		exporters, err := kube.WatchSelectedPods(ctx, client, *customMetricsPeerNamespace, *customMetricsPeerSelector, *kubeSyncTimeout)
+		if err != nil {
+			return fmt.Errorf("could not watch the exporters on the other nodes\n%v", err)
+		}
+		peers = customMetricsPeers(exporters)
+		peerClient = &http.Client{
+			Timeout: customMetricsPeerTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      auth.PeerCAs,
+				ServerName:   *customMetricsPeerServerName,
+				MinVersion:   tls.VersionTLS12,
+			}},
+		}
+	}
+
+	rates := custommetrics.NewRates()
+	server := &http.Server{
+		Addr:              *customMetricsAddress,
+		Handler:           auth.Handler(custommetrics.NewHandler(rates, watchedPods, peers, peerClient)),
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		// The client certificates are verified by the authenticator, against the CA of the aggregator or of the peers
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12},
+	}
+
+	collector.setSampler(collectors.NewPodTrafficSampler())
+	workers.Go(func() {
+		sampleTraffic(ctx, collector, rates, *customMetricsInterval)
+	})
+	workers.Go(func() {
+		slog.Info("serving custom metrics api", "address", *customMetricsAddress)
+		if err := serve(ctx, server, *shutdownTimeout); err != nil {
+			slog.Error("custom metrics server error", "err", err)
+		}
+	})
+
+	return nil
+}
+
+func customMetricsCertificate() (tls.Certificate, error) {
+	if *customMetricsCertFile == "" {
+		return custommetrics.SelfSignedCertificate()
+	}
+
+	return tls.LoadX509KeyPair(*customMetricsCertFile, *customMetricsKeyFile)
+}
+
+func customMetricsPeerCAs() (*x509.CertPool, error) {
+	data, err := os.ReadFile(*customMetricsPeerCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in '%s'", *customMetricsPeerCAFile)
+	}
+
+	return pool, nil
+}
+
+// sampleTraffic updates the rates from the pod traffic in each interval until the context is done. The rates of a
+// failed sample are kept until the next one succeeds.
+func sampleTraffic(ctx context.Context, collector *reloadableCollector, rates *custommetrics.Rates, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		traffic, err := collector.sample()
+		if err != nil {
+			slog.Warn("pod traffic could not be sampled", "err", err)
+		} else {
+			counters := make(map[custommetrics.Pod]map[string]int64, len(traffic))
+			for _, pod := range traffic {
+				counters[custommetrics.Pod{Namespace: pod.Namespace, Name: pod.Pod}] = pod.Stats
+			}
+			rates.Update(counters, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// customMetricsPeers returns the custom metrics API addresses of the running exporters on the other nodes, from the
+// watched exporters
+func customMetricsPeers(exporters *kube.Pods) custommetrics.Peers {
+	_, port, _ := net.SplitHostPort(*customMetricsAddress)
+
+	return func(context.Context) ([]string, error) {
+		pods := exporters.List(*customMetricsPeerNamespace, labels.Everything())
+
+		peers := make([]string, 0, len(pods))
+		for _, pod := range pods {
+			if pod.Spec.NodeName == *kubeNodeName || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+				continue
+			}
+			peers = append(peers, "https://"+net.JoinHostPort(pod.Status.PodIP, port))
+		}
+
+		return peers, nil
+	}
+}
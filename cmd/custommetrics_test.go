@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+var _ = DescribeTable("test verifying custom metrics flags", // verifyCustomMetricsFlags
+	func(flags map[string]string, expectedErr string) {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+		for name, value := range flags {
+			Expect(flag.Set(name, value)).To(Succeed())
+		}
+
+		err := verifyCustomMetricsFlags()
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("disabled", map[string]string{}, ""),
+	Entry("self-signed certificate", map[string]string{"custommetrics.listen-address": ":6443"}, ""),
+	Entry("invalid address", map[string]string{"custommetrics.listen-address": "6443"}, "invalid custommetrics.listen-address"),
+	Entry("certificate without key", map[string]string{"custommetrics.listen-address": ":6443", "custommetrics.tls-cert-file": "/tls/tls.crt"},
+		"must be set together"),
+	Entry("zero interval", map[string]string{"custommetrics.listen-address": ":6443", "custommetrics.interval": "0s"}, "must be positive"),
+	Entry("invalid peer selector", map[string]string{"custommetrics.listen-address": ":6443", "custommetrics.peer-selector": "app==="},
+		"invalid custommetrics.peer-selector"),
+	Entry("peer ca with a self-signed certificate", map[string]string{"custommetrics.listen-address": ":6443", "custommetrics.peer-ca-file": "/tls/ca.crt"},
+		"custommetrics.peer-ca-file requires custommetrics.tls-cert-file"),
+)
+
+var _ = It("lists the exporters on the other nodes as peers", func() { // customMetricsPeers
+	snapshot := config.Snapshot(flag.CommandLine)
+	DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+	Expect(flag.Set("custommetrics.listen-address", ":6443")).To(Succeed())
+	Expect(flag.Set("custommetrics.peer-namespace", "monitoring")).To(Succeed())
+	Expect(flag.Set("kube.node-name", "node1")).To(Succeed())
+
+	exporter := func(name, node, ip string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "monitoring", Labels: map[string]string{"app.kubernetes.io/name": "sriov-metrics-exporter"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: phase, PodIP: ip},
+		}
+	}
+	client := fake.NewClientset(
+		exporter("exporter-1", "node1", "10.0.0.1", corev1.PodRunning),
+		exporter("exporter-2", "node2", "10.0.0.2", corev1.PodRunning),
+		exporter("exporter-3", "node3", "", corev1.PodPending),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "monitoring"}, Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.4"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	DeferCleanup(cancel)
+	exporters, err := kube.WatchSelectedPods(ctx, client, "monitoring", *customMetricsPeerSelector, 10*time.Second)
+	Expect(err).ToNot(HaveOccurred())
+
+	peers, err := customMetricsPeers(exporters)(ctx)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(peers).To(Equal([]string{"https://10.0.0.2:6443"}))
+})
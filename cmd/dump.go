@@ -0,0 +1,219 @@
+package main
+
+// dump runs the enabled collectors once and prints the stats of each vf with its reader, NUMA node and pod
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+)
+
+const (
+	dumpCommand     = "dump"
+	dumpFormatTable = "table"
+	dumpFormatJSON  = "json"
+
+	vfStatsPrefix    = "sriov_vf_"
+	vfDriverStatName = "sriov_vf_driver_stat"
+	readerInfoName   = "sriov_pf_stats_reader_info"
+	podDeviceName    = "sriov_kubepoddevice"
+)
+
+// vfDump is the state of a single vf as printed by the dump command
+type vfDump struct {
+	PF        string             `json:"pf"`
+	VF        string             `json:"vf"`
+	PCIAddr   string             `json:"pciAddr"`
+	NumaNode  string             `json:"numaNode"`
+	Reader    string             `json:"reader"`
+	Pod       string             `json:"pod,omitempty"`
+	Namespace string             `json:"namespace,omitempty"`
+	Stats     map[string]float64 `json:"stats"`
+}
+
+// runDump parses the dump command flags, collects once and writes the result to w. It returns the exit code.
+func runDump(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet(dumpCommand, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	format := fs.String("format", dumpFormatTable, "Output format: table or json.")
+	_ = fs.Parse(args) // exits on error
+
+	if *format != dumpFormatTable && *format != dumpFormatJSON {
+		fmt.Fprintf(os.Stderr, "invalid format '%s', must be '%s' or '%s'\n", *format, dumpFormatTable, dumpFormatJSON)
+		return 2 //nolint:mnd
+	}
+
+	loadAndVerifyFlags(config.CommandLine(fs))
+	defer removeReplay()
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collectors.Enabled()); err != nil {
+		slog.Error("collector could not be registered", "err", err)
+		return 1
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		slog.Warn("some metrics could not be gathered", "err", err)
+	}
+
+	if err := writeDump(w, buildDump(families), *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// buildDump joins the gathered metrics into one entry per vf, sorted by pf and vf id.
+// Readers are matched by pf and pods by the PCI address of the vf.
+func buildDump(families []*dto.MetricFamily) []vfDump {
+	vfs := make(map[string]*vfDump)
+	readers := make(map[string]string)
+	pods := make(map[string][2]string)
+
+	// Other sriov_vf_ families, such as the allocations, are not stats and lack the NUMA node of the vf
+	stats := make(map[string]string)
+	for _, stat := range collectors.CanonicalStats() {
+		stats[vfStatsPrefix+stat] = stat
+	}
+
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := metricLabels(m)
+
+			switch {
+			case name == readerInfoName:
+				readers[labels["pf"]] = labels["reader"]
+			case name == podDeviceName:
+				pods[labels["pciAddr"]] = [2]string{labels["pod"], labels["namespace"]}
+			case stats[name] != "" || name == vfDriverStatName:
+				key := labels["pf"] + "/" + labels["vf"]
+				vf, ok := vfs[key]
+				if !ok {
+					vf = &vfDump{
+						PF:       labels["pf"],
+						VF:       labels["vf"],
+						PCIAddr:  labels["pciAddr"],
+						NumaNode: labels["numa_node"],
+						Stats:    make(map[string]float64),
+					}
+					vfs[key] = vf
+				}
+
+				stat := stats[name]
+				if name == vfDriverStatName {
+					stat = labels["stat"]
+				}
+				vf.Stats[stat] = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	dump := make([]vfDump, 0, len(vfs))
+	for _, vf := range vfs {
+		vf.Reader = readers[vf.PF]
+		if pod, ok := pods[vf.PCIAddr]; ok {
+			vf.Pod, vf.Namespace = pod[0], pod[1]
+		}
+		dump = append(dump, *vf)
+	}
+
+	sort.Slice(dump, func(i, j int) bool {
+		if dump[i].PF != dump[j].PF {
+			return dump[i].PF < dump[j].PF
+		}
+		a, errA := strconv.Atoi(dump[i].VF)
+		b, errB := strconv.Atoi(dump[j].VF)
+		if errA != nil || errB != nil {
+			return dump[i].VF < dump[j].VF
+		}
+		return a < b
+	})
+
+	return dump
+}
+
+// metricLabels returns the labels of a metric keyed by name
+func metricLabels(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+// writeDump writes the vfs as a table with one column per stat, or as a JSON array
+func writeDump(w io.Writer, dump []vfDump, format string) error {
+	switch format {
+	case dumpFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(dump)
+	case dumpFormatTable:
+		return writeDumpTable(w, dump)
+	default:
+		return fmt.Errorf("invalid format '%s', must be '%s' or '%s'", format, dumpFormatTable, dumpFormatJSON)
+	}
+}
+
+func writeDumpTable(w io.Writer, dump []vfDump) error {
+	statSet := make(map[string]bool)
+	for _, vf := range dump {
+		for stat := range vf.Stats {
+			statSet[stat] = true
+		}
+	}
+	stats := make([]string, 0, len(statSet))
+	for stat := range statSet {
+		stats = append(stats, stat)
+	}
+	sort.Strings(stats)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd
+	header := append([]string{"PF", "VF", "PCI ADDRESS", "NUMA", "READER", "POD"}, stats...)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+
+	for _, vf := range dump {
+		pod := "-"
+		if vf.Pod != "" {
+			pod = vf.Namespace + "/" + vf.Pod
+		}
+
+		row := []string{vf.PF, vf.VF, vf.PCIAddr, dashIfEmpty(vf.NumaNode), dashIfEmpty(vf.Reader), pod}
+		for _, stat := range stats {
+			value, ok := vf.Stats[stat]
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+)
+
+// dumpTestCollector publishes the metrics of two vfs on one pf, one of them assigned to a pod and allocated
+type dumpTestCollector struct{}
+
+func (c dumpTestCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c dumpTestCollector) Collect(ch chan<- prometheus.Metric) {
+	vfLabels := []string{"pf", "vf", "pciAddr", "numa_node"}
+	rxBytes := prometheus.NewDesc("sriov_vf_rx_bytes", "", vfLabels, nil)
+	txBytes := prometheus.NewDesc("sriov_vf_tx_bytes", "", vfLabels, nil)
+	driverStat := prometheus.NewDesc("sriov_vf_driver_stat", "", append(vfLabels, "stat"), nil)
+	reader := prometheus.NewDesc("sriov_pf_stats_reader_info", "", []string{"pf", "reader"}, nil)
+	pod := prometheus.NewDesc("sriov_kubepoddevice", "", []string{"pciAddr", "dev_type", "pod", "namespace", "container"}, nil)
+	allocations := prometheus.NewDesc("sriov_vf_allocations_total", "", []string{"pciAddr", "pf", "vf"}, nil)
+
+	ch <- prometheus.MustNewConstMetric(rxBytes, prometheus.CounterValue, 10, "ens785f0", "10", "0000:3b:02.2", "0")
+	ch <- prometheus.MustNewConstMetric(txBytes, prometheus.CounterValue, 20, "ens785f0", "10", "0000:3b:02.2", "0")
+	ch <- prometheus.MustNewConstMetric(rxBytes, prometheus.CounterValue, 1, "ens785f0", "2", "0000:3b:02.0", "0")
+	ch <- prometheus.MustNewConstMetric(driverStat, prometheus.CounterValue, 5, "ens785f0", "2", "0000:3b:02.0", "0", "rx_vport_packets")
+	ch <- prometheus.MustNewConstMetric(reader, prometheus.GaugeValue, 1, "ens785f0", "sysfs")
+	ch <- prometheus.MustNewConstMetric(pod, prometheus.CounterValue, 1, "0000:3b:02.0", "intel.com/sriov", "pod1", "default", "app")
+	ch <- prometheus.MustNewConstMetric(allocations, prometheus.CounterValue, 1, "0000:3b:02.0", "ens785f0", "2")
+}
+
+func gatherDumpTestMetrics() []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	Expect(registry.Register(collectors.SriovCollector{dumpTestCollector{}})).To(Succeed())
+
+	families, err := registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+
+	return families
+}
+
+var _ = Describe("test building the dump", func() { // buildDump
+	It("joins stats, readers and pods per vf", func() {
+		Expect(buildDump(gatherDumpTestMetrics())).To(Equal([]vfDump{
+			{
+				PF: "ens785f0", VF: "2", PCIAddr: "0000:3b:02.0", NumaNode: "0", Reader: "sysfs",
+				Pod: "pod1", Namespace: "default",
+				Stats: map[string]float64{"rx_bytes": 1, "rx_vport_packets": 5},
+			},
+			{
+				PF: "ens785f0", VF: "10", PCIAddr: "0000:3b:02.2", NumaNode: "0", Reader: "sysfs",
+				Stats: map[string]float64{"rx_bytes": 10, "tx_bytes": 20},
+			},
+		}))
+	})
+
+	It("returns no vfs without metrics", func() {
+		Expect(buildDump(nil)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test writing the dump", func() { // writeDump
+	It("writes a table with a column per stat", func() {
+		out := &bytes.Buffer{}
+		Expect(writeDump(out, buildDump(gatherDumpTestMetrics()), dumpFormatTable)).To(Succeed())
+
+		Expect(out.String()).To(Equal(
+			"PF        VF  PCI ADDRESS   NUMA  READER  POD           RX_BYTES  RX_VPORT_PACKETS  TX_BYTES\n" +
+				"ens785f0  2   0000:3b:02.0  0     sysfs   default/pod1  1         5                 -\n" +
+				"ens785f0  10  0000:3b:02.2  0     sysfs   -             10        -                 20\n"))
+	})
+
+	It("writes json", func() {
+		out := &bytes.Buffer{}
+		Expect(writeDump(out, buildDump(gatherDumpTestMetrics()), dumpFormatJSON)).To(Succeed())
+
+		var dump []vfDump
+		Expect(json.Unmarshal(out.Bytes(), &dump)).To(Succeed())
+		Expect(dump).To(HaveLen(2))
+		Expect(dump[0].Pod).To(Equal("pod1"))
+		Expect(dump[1].Stats).To(HaveKeyWithValue("tx_bytes", 20.0))
+	})
+
+	It("rejects an unknown format", func() {
+		Expect(writeDump(&bytes.Buffer{}, nil, "yaml")).To(MatchError("invalid format 'yaml', must be 'table' or 'json'"))
+	})
+})
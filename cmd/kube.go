@@ -0,0 +1,148 @@
+package main
+
+// kube watches the pods and the SriovNetworkNodeState of the node through the Kubernetes API, for the pod metadata
+// added to the pod metrics and the sriovnodestate collector
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+const (
+	defaultKubeSyncTimeout   = 30 * time.Second
+	defaultOperatorNamespace = "sriov-network-operator"
+)
+
+var (
+	kubeNetworkStatus = flag.Bool("kube.networkstatus", false,
+		"Adds the interface and network of each pod device, read from the Multus network-status annotation of its pod through the Kubernetes API.")
+	kubeConfig = flag.String("kube.kubeconfig", "",
+		"Path to a kubeconfig file to access the Kubernetes API, the in-cluster configuration is used if not set.")
+	kubeNodeName = flag.String("kube.node-name", os.Getenv("NODE_NAME"),
+		"Name of the node whose pods and node state are watched, defaults to the NODE_NAME environment variable.")
+	kubeSyncTimeout = flag.Duration("kube.sync-timeout", defaultKubeSyncTimeout,
+		"Maximum time to wait for the pods and node state of the node to be listed on start.")
+	kubeOperatorNamespace = flag.String("kube.operator-namespace", defaultOperatorNamespace,
+		"Namespace of the SR-IOV Network Operator, holding the SriovNetworkNodeState read by the sriovnodestate collector.")
+	kubePodOwner = flag.Bool("kube.pod-owner", false,
+		"Adds the kind and name of the workload owning the pod, e.g. its Deployment or StatefulSet, to the pod metrics.")
+	kubePodLabels      = utils.StringListFlag{}
+	kubePodAnnotations = utils.StringListFlag{}
+
+	// watchedPods are the pods of the node, nil unless watched by startKube
+	watchedPods *kube.Pods
+
+	// newKubeClients returns a typed and a dynamic client of the Kubernetes API, replaced in tests
+	newKubeClients = func() (kubernetes.Interface, dynamic.Interface, error) {
+		config, err := kubeRESTConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return client, dynamicClient, nil
+	}
+)
+
+func init() {
+	flag.Var(&kubePodLabels, "kube.pod-labels",
+		"Comma-separated pod labels added to the pod metrics as label_<name>, only the listed labels are added.")
+	flag.Var(&kubePodAnnotations, "kube.pod-annotations",
+		"Comma-separated pod annotations added to the pod metrics as annotation_<name>, only the listed annotations are added.")
+}
+
+// kubeEnabled returns true if a feature reading from the Kubernetes API is enabled
+func kubeEnabled() bool {
+	return watchPods() || collectors.NodeStateEnabled()
+}
+
+// watchPods returns true if pod metadata is added to the pod metrics or the custom metrics API is served
+func watchPods() bool {
+	return *kubeNetworkStatus || podMetadata().Enabled() || customMetricsEnabled()
+}
+
+// podMetadata returns the pod metadata selected by the kube flags
+func podMetadata() kube.PodMetadata {
+	return kube.PodMetadata{Labels: kubePodLabels, Annotations: kubePodAnnotations, Owner: *kubePodOwner}
+}
+
+func verifyKubeFlags() error {
+	if !kubeEnabled() {
+		return nil
+	}
+
+	if *kubeNodeName == "" {
+		return fmt.Errorf("kube.node-name must be set to watch the pods and node state of the node")
+	}
+	if *kubeSyncTimeout <= 0 {
+		return fmt.Errorf("kube.sync-timeout must be positive")
+	}
+
+	return podMetadata().Verify()
+}
+
+func kubeRESTConfig() (*rest.Config, error) {
+	if *kubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+
+	return clientcmd.BuildConfigFromFlags("", *kubeConfig)
+}
+
+// startKube watches the pods and the SriovNetworkNodeState of the node and hands them to the collectors if a
+// feature reading them is enabled. It must be called before the collectors are created.
+func startKube(ctx context.Context) error {
+	if !kubeEnabled() {
+		return nil
+	}
+
+	client, dynamicClient, err := newKubeClients()
+	if err != nil {
+		return fmt.Errorf("could not create kubernetes client\n%v", err)
+	}
+
+	if watchPods() {
+		pods, err := kube.WatchPods(ctx, client, *kubeNodeName, *kubeSyncTimeout)
+		if err != nil {
+			return err
+		}
+
+		watchedPods = pods
+		collectors.SetPodEnrichment(collectors.PodEnrichment{Pods: pods, Networks: *kubeNetworkStatus, Metadata: podMetadata()})
+		slog.Info("watching pods", "node", *kubeNodeName)
+	}
+
+	if collectors.NodeStateEnabled() {
+		states, err := kube.WatchNodeState(ctx, dynamicClient, *kubeOperatorNamespace, *kubeNodeName, *kubeSyncTimeout)
+		if err != nil {
+			return err
+		}
+
+		collectors.SetNodeStates(states)
+		slog.Info("watching sriov network node state", "namespace", *kubeOperatorNamespace, "node", *kubeNodeName)
+	}
+
+	return nil
+}
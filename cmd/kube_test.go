@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+// fakeKubeClients returns clients of an empty fake API server
+func fakeKubeClients() (kubernetes.Interface, dynamic.Interface, error) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{kube.NodeStateResource: "SriovNetworkNodeStateList"})
+
+	return fake.NewClientset(), dynamicClient, nil
+}
+
+var _ = DescribeTable("test verifying kube flags", // verifyKubeFlags
+	func(flags map[string]string, expectedErr string) {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+		for name, value := range flags {
+			Expect(flag.Set(name, value)).To(Succeed())
+		}
+
+		err := verifyKubeFlags()
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("disabled", map[string]string{"kube.node-name": ""}, ""),
+	Entry("network status", map[string]string{"kube.networkstatus": "true", "kube.node-name": "node1"}, ""),
+	Entry("missing node name", map[string]string{"kube.networkstatus": "true", "kube.node-name": ""}, "kube.node-name must be set"),
+	Entry("pod labels", map[string]string{"kube.pod-labels": "app,team", "kube.node-name": "node1"}, ""),
+	Entry("pod labels without node name", map[string]string{"kube.pod-labels": "app", "kube.node-name": ""}, "kube.node-name must be set"),
+	Entry("conflicting pod labels", map[string]string{"kube.pod-labels": "app.name,app_name", "kube.node-name": "node1"},
+		"maps to the metric label 'label_app_name'"),
+	Entry("node state without node name", map[string]string{"collector.sriovnodestate": "true", "kube.node-name": ""},
+		"kube.node-name must be set"),
+	Entry("zero sync timeout", map[string]string{"kube.networkstatus": "true", "kube.node-name": "node1", "kube.sync-timeout": "0s"},
+		"must be positive"),
+)
+
+var _ = Describe("test starting the pod watch", func() { // startKube
+	BeforeEach(func() {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+		newKubeClientsOrig := newKubeClients
+		DeferCleanup(func() {
+			newKubeClients = newKubeClientsOrig
+			collectors.SetPodEnrichment(collectors.PodEnrichment{})
+			collectors.SetNodeStates(nil)
+			watchedPods = nil
+		})
+	})
+
+	It("does nothing when disabled", func() {
+		newKubeClients = func() (kubernetes.Interface, dynamic.Interface, error) {
+			return nil, nil, errors.New("unexpected client")
+		}
+
+		Expect(startKube(context.Background())).To(Succeed())
+	})
+
+	It("watches the pods of the node", func() {
+		Expect(flag.Set("kube.networkstatus", "true")).To(Succeed())
+		Expect(flag.Set("kube.node-name", "node1")).To(Succeed())
+		newKubeClients = fakeKubeClients
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		Expect(startKube(ctx)).To(Succeed())
+	})
+
+	It("watches the sriov network node state of the node", func() {
+		Expect(flag.Set("collector.sriovnodestate", "true")).To(Succeed())
+		Expect(flag.Set("kube.node-name", "node1")).To(Succeed())
+		newKubeClients = fakeKubeClients
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		Expect(startKube(ctx)).To(Succeed())
+		Expect(kubeEnabled()).To(BeTrue())
+	})
+
+	It("fails without a client", func() {
+		Expect(flag.Set("kube.networkstatus", "true")).To(Succeed())
+		newKubeClients = func() (kubernetes.Interface, dynamic.Interface, error) { return nil, nil, errors.New("no cluster") }
+
+		Expect(startKube(context.Background())).To(MatchError("could not create kubernetes client\nno cluster"))
+	})
+})
@@ -0,0 +1,97 @@
+package main
+
+// otlp pushes the collected metrics to an OTLP receiver in parallel to serving them on the metrics endpoint
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/otlp"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+const (
+	defaultOTLPInterval = 30 * time.Second
+	defaultOTLPTimeout  = 10 * time.Second
+)
+
+var (
+	otlpEndpoint = flag.String("otlp.endpoint", "",
+		"host:port of an OTLP receiver to push metrics to, in addition to serving them. Pushing is disabled if not set.")
+	otlpProtocol = flag.String("otlp.protocol", otlp.ProtocolGRPC, "Protocol used to push metrics: grpc or http.")
+	otlpInsecure = flag.Bool("otlp.insecure", false, "Push metrics without TLS.")
+	otlpInterval = flag.Duration("otlp.interval", defaultOTLPInterval, "Interval in which metrics are pushed.")
+	otlpTimeout  = flag.Duration("otlp.timeout", defaultOTLPTimeout, "Maximum time to wait for a push to complete.")
+	otlpNodeName = flag.String("otlp.node-name", os.Getenv("NODE_NAME"),
+		"Value of the k8s.node.name resource attribute, defaults to the NODE_NAME environment variable.")
+	otlpHeaders = utils.StringMapFlag{}
+)
+
+func init() {
+	flag.Var(&otlpHeaders, "otlp.headers", "Comma-separated key=value headers sent with each push, e.g. for authentication.")
+}
+
+// otlpConfig returns the exporter configuration of the otlp flags
+func otlpConfig() otlp.Config {
+	hostName, err := os.Hostname()
+	if err != nil {
+		slog.Warn("could not get host name for otlp resource", "err", err)
+	}
+
+	return otlp.Config{
+		Endpoint: *otlpEndpoint,
+		Protocol: *otlpProtocol,
+		Insecure: *otlpInsecure,
+		Headers:  otlpHeaders,
+		Interval: *otlpInterval,
+		Timeout:  *otlpTimeout,
+		NodeName: *otlpNodeName,
+		HostName: hostName,
+	}
+}
+
+// startOTLP pushes the metrics of the collector if an endpoint is set, otherwise it returns nil
+func startOTLP(ctx context.Context, collector prometheus.Collector) (*sdkmetric.MeterProvider, error) {
+	if *otlpEndpoint == "" {
+		return nil, nil
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("otlp push failed", "err", err)
+	}))
+
+	// A dedicated registry keeps the go and process metrics of the default registry out of the push
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return nil, err
+	}
+
+	provider, err := otlp.Start(ctx, otlpConfig(), registry)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("pushing metrics", "endpoint", *otlpEndpoint, "protocol", *otlpProtocol, "interval", *otlpInterval)
+	return provider, nil
+}
+
+// stopOTLP pushes the last metrics and stops pushing, waiting up to the timeout
+func stopOTLP(provider *sdkmetric.MeterProvider, timeout time.Duration) {
+	if provider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := provider.Shutdown(ctx); err != nil {
+		slog.Warn("otlp shutdown failed", "err", err)
+	}
+}
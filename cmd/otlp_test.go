@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+)
+
+var _ = Describe("test starting the otlp push", func() { // startOTLP, otlpConfig
+	BeforeEach(func() {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+	})
+
+	It("does not push without an endpoint", func() {
+		provider, err := startOTLP(context.Background(), newReloadableCollector(collectors.SriovCollector{}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(provider).To(BeNil())
+	})
+
+	It("pushes the collector until stopped", func() {
+		Expect(flag.Set("otlp.endpoint", "127.0.0.1:4317")).To(Succeed())
+		Expect(flag.Set("otlp.headers", "tenant=node-metrics")).To(Succeed())
+
+		provider, err := startOTLP(context.Background(), newReloadableCollector(collectors.SriovCollector{}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(provider).ToNot(BeNil())
+		stopOTLP(provider, 0)
+
+		Expect(otlpConfig().Headers).To(HaveKeyWithValue("tenant", "node-metrics"))
+	})
+})
@@ -0,0 +1,102 @@
+package main
+
+// push sends the collected metrics to a remote-write endpoint or a Pushgateway instead of serving them, for nodes
+// that can not be scraped
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/push"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+const (
+	defaultPushInterval   = 30 * time.Second
+	defaultPushTimeout    = 10 * time.Second
+	defaultPushBufferSize = 20
+	defaultPushJob        = "sriov-network-metrics-exporter"
+)
+
+var (
+	pushMode = flag.String("push.mode", "",
+		"Push metrics instead of serving them: remote-write or pushgateway. Metrics are served if not set.")
+	pushURL      = flag.String("push.url", "", "URL of the remote-write endpoint or Pushgateway to push metrics to.")
+	pushInterval = flag.Duration("push.interval", defaultPushInterval, "Interval in which metrics are pushed.")
+	pushTimeout  = flag.Duration("push.timeout", defaultPushTimeout,
+		"Maximum time to push metrics, including retries, before a remote-write push is buffered.")
+	pushBufferSize = flag.Int("push.buffer-size", defaultPushBufferSize,
+		"Number of failed remote-write pushes kept in memory and resent once the endpoint is reachable.")
+	pushJob      = flag.String("push.job", defaultPushJob, "Value of the job label of pushed metrics.")
+	pushNodeName = flag.String("push.node-name", os.Getenv("NODE_NAME"),
+		"Value of the node label of pushed metrics, defaults to the NODE_NAME environment variable or the host name.")
+	pushHeaders = utils.StringMapFlag{}
+)
+
+func init() {
+	flag.Var(&pushHeaders, "push.headers", "Comma-separated key=value headers sent with each push, e.g. for authentication.")
+}
+
+// verifyPushFlags returns an error if push mode is set with invalid push flags
+func verifyPushFlags() error {
+	switch *pushMode {
+	case "":
+		return nil
+	case push.ModeRemoteWrite, push.ModePushgateway:
+	default:
+		return fmt.Errorf("unknown push mode '%s', expected %s or %s", *pushMode, push.ModeRemoteWrite, push.ModePushgateway)
+	}
+
+	if *pushURL == "" {
+		return fmt.Errorf("push.url must be set in %s mode", *pushMode)
+	}
+	if *pushInterval <= 0 || *pushTimeout <= 0 {
+		return fmt.Errorf("push.interval and push.timeout must be positive")
+	}
+
+	return nil
+}
+
+// pushLabels returns the job and node labels identifying the pushed metrics
+func pushLabels() map[string]string {
+	node := *pushNodeName
+	if node == "" {
+		hostName, err := os.Hostname()
+		if err != nil {
+			slog.Warn("could not get host name for node label", "err", err)
+		}
+		node = hostName
+	}
+
+	return map[string]string{"job": *pushJob, "node": node}
+}
+
+// newPushSink returns the sink of the push mode
+func newPushSink() push.Sink {
+	labels := pushLabels()
+	if *pushMode == push.ModePushgateway {
+		return push.NewPushgateway(*pushURL, labels["job"], map[string]string{"node": labels["node"]}, pushHeaders)
+	}
+
+	return push.NewRemoteWrite(*pushURL, labels, pushHeaders, *pushBufferSize)
+}
+
+// runPush pushes the metrics of the collector until the context is done
+func runPush(ctx context.Context, collector prometheus.Collector) error {
+	// A dedicated registry keeps the go and process metrics of the default registry out of the push
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return err
+	}
+
+	slog.Info("pushing metrics", "mode", *pushMode, "url", *pushURL, "interval", *pushInterval)
+	push.Run(ctx, registry, newPushSink(), *pushInterval, *pushTimeout)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/push"
+)
+
+var _ = DescribeTable("test verifying push flags", // verifyPushFlags
+	func(flags map[string]string, expectedErr string) {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+		for name, value := range flags {
+			Expect(flag.Set(name, value)).To(Succeed())
+		}
+
+		err := verifyPushFlags()
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("disabled", map[string]string{}, ""),
+	Entry("remote-write", map[string]string{"push.mode": "remote-write", "push.url": "http://prometheus:9090/api/v1/write"}, ""),
+	Entry("pushgateway", map[string]string{"push.mode": "pushgateway", "push.url": "http://pushgateway:9091"}, ""),
+	Entry("unknown mode", map[string]string{"push.mode": "graphite"}, "unknown push mode 'graphite'"),
+	Entry("missing url", map[string]string{"push.mode": "remote-write"}, "push.url must be set in remote-write mode"),
+	Entry("zero interval", map[string]string{"push.mode": "pushgateway", "push.url": "http://pushgateway:9091", "push.interval": "0s"},
+		"must be positive"),
+)
+
+var _ = Describe("test creating the push sink", func() { // newPushSink, pushLabels
+	BeforeEach(func() {
+		snapshot := config.Snapshot(flag.CommandLine)
+		DeferCleanup(config.Restore, flag.CommandLine, snapshot)
+	})
+
+	It("labels the metrics with the job and node", func() {
+		Expect(flag.Set("push.node-name", "node1")).To(Succeed())
+
+		Expect(pushLabels()).To(Equal(map[string]string{"job": defaultPushJob, "node": "node1"}))
+	})
+
+	It("uses the host name without a node name", func() {
+		Expect(flag.Set("push.node-name", "")).To(Succeed())
+
+		Expect(pushLabels()["node"]).ToNot(BeEmpty())
+	})
+
+	It("returns the sink of the mode", func() {
+		Expect(flag.Set("push.mode", "pushgateway")).To(Succeed())
+		Expect(newPushSink()).To(BeAssignableToTypeOf(&push.Pushgateway{}))
+
+		Expect(flag.Set("push.mode", "remote-write")).To(Succeed())
+		Expect(newPushSink()).To(BeAssignableToTypeOf(&push.RemoteWrite{}))
+	})
+})
@@ -0,0 +1,129 @@
+package main
+
+// snapshot archives the host state read by the enabled collectors, and replay serves metrics from such an archive
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+)
+
+const snapshotCommand = "snapshot"
+
+// replayDir is the directory the replayed snapshot or generated host is extracted to
+var replayDir string
+
+// runSnapshot parses the snapshot command flags and writes the archive to the output file, or to w if none is set.
+// It returns the exit code.
+func runSnapshot(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet(snapshotCommand, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	output := fs.String("output", "", "Path of the snapshot archive, written to stdout if not set.")
+	_ = fs.Parse(args) // exits on error
+
+	loadAndVerifyFlags(config.CommandLine(fs))
+	defer removeReplay()
+
+	if err := writeSnapshot(*output, w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeSnapshot writes the archive to path, or to w if path is empty. A partially written file is removed.
+func writeSnapshot(path string, w io.Writer) error {
+	if path == "" {
+		return collectors.WriteSnapshot(w)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file\n%v", err)
+	}
+
+	err = collectors.WriteSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	slog.Info("snapshot written", "path", path)
+	return nil
+}
+
+// loadReplay extracts the snapshot archive at path to a temporary directory and points the collectors at it
+func loadReplay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := loadSnapshot(f); err != nil {
+		return err
+	}
+
+	slog.Info("replaying snapshot", "path", path)
+	return nil
+}
+
+// loadDemo points the collectors at a generated host
+func loadDemo() error {
+	archive := &bytes.Buffer{}
+	if err := fakehost.New(fakehost.DefaultOptions).WriteSnapshot(archive); err != nil {
+		return err
+	}
+
+	if err := loadSnapshot(archive); err != nil {
+		return err
+	}
+
+	slog.Info("serving metrics of a generated host")
+	return nil
+}
+
+func loadSnapshot(r io.Reader) error {
+	dir, err := os.MkdirTemp("", "sriov-snapshot-")
+	if err != nil {
+		return err
+	}
+	replayDir = dir
+
+	if err := snapshot.Extract(r, dir); err != nil {
+		return err
+	}
+
+	return collectors.LoadSnapshot(dir)
+}
+
+// replaying returns true if metrics are served from a snapshot or generated host instead of the host
+func replaying() bool {
+	return *replay != "" || *demo
+}
+
+// removeReplay removes the extracted snapshot, if any
+func removeReplay() {
+	if replayDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(replayDir); err != nil {
+		slog.Warn("could not remove extracted snapshot", "path", replayDir, "err", err)
+	}
+	replayDir = ""
+}
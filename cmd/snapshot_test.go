@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+// restoreAfterReplay restores the paths and netlink functions replaced by loading a snapshot, and removes it
+func restoreAfterReplay() {
+	pathFlags := map[string]string{}
+	for _, name := range []string{"path.sysbuspci", "path.sysclassnet"} {
+		pathFlags[name] = flag.Lookup(name).Value.String()
+	}
+	DeferCleanup(func() {
+		for name, value := range pathFlags {
+			Expect(flag.Set(name, value)).To(Succeed())
+		}
+		vfstats.ListLinks = netlink.LinkList
+		vfstats.GetLink = netlink.LinkByName
+		removeReplay()
+	})
+}
+
+var _ = Describe("test loading a replayed snapshot", func() { // loadReplay, removeReplay
+	BeforeEach(restoreAfterReplay)
+
+	It("extracts the archive and removes it", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "snapshot.tar.gz")
+		f, err := os.Create(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		sw := snapshot.NewWriter(f)
+		Expect(sw.File("pci/0000:3b:00.0/sriov_totalvfs", []byte("8"))).To(Succeed())
+		Expect(sw.Dir("net")).To(Succeed())
+		Expect(sw.Close()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(loadReplay(path)).To(Succeed())
+		Expect(filepath.Join(replayDir, "pci", "0000:3b:00.0", "sriov_totalvfs")).To(BeARegularFile())
+		Expect(flag.Lookup("path.sysbuspci").Value.String()).To(Equal(filepath.Join(replayDir, "pci")))
+
+		dir := replayDir
+		removeReplay()
+		Expect(dir).ToNot(BeADirectory())
+		Expect(replayDir).To(BeEmpty())
+	})
+
+	It("fails on a file that is not an archive", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "snapshot.tar.gz")
+		Expect(os.WriteFile(path, []byte("not an archive"), 0o600)).To(Succeed())
+
+		Expect(loadReplay(path)).To(MatchError(ContainSubstring("could not read archive")))
+	})
+
+	It("fails on a missing file", func() {
+		Expect(loadReplay(filepath.Join(GinkgoT().TempDir(), "missing.tar.gz"))).To(HaveOccurred())
+		Expect(replayDir).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test loading the demo host", func() { // loadDemo
+	BeforeEach(restoreAfterReplay)
+
+	It("serves the stats of every generated vf", func() {
+		Expect(loadDemo()).To(Succeed())
+
+		registry := prometheus.NewRegistry()
+		Expect(registry.Register(collectors.Enabled())).To(Succeed())
+		families, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		dump := buildDump(families)
+		Expect(dump).To(HaveLen(fakehost.DefaultOptions.PFs * fakehost.DefaultOptions.VFsPerPF))
+		Expect(dump[0].Reader).To(Equal("sysfs"))
+		Expect(dump[0].Stats).To(HaveKeyWithValue("rx_packets", float64(1001000)))
+	})
+})
+
+var _ = Describe("test writing a snapshot file", func() { // writeSnapshot
+	It("fails when the file can not be created", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "missing", "snapshot.tar.gz")
+
+		Expect(writeSnapshot(path, nil)).To(MatchError(ContainSubstring("could not create snapshot file")))
+		Expect(path).ToNot(BeAnExistingFile())
+	})
+})
@@ -4,9 +4,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,48 +23,201 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/logging"
 )
 
 const (
 	defaultRateBurst         = 10
 	defaultReadHeaderTimeout = 10 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultLogDedupInterval  = 5 * time.Minute
 )
 
 var (
 	addr            = flag.String("web.listen-address", ":9808", "Port to listen on for web interface and telemetry.")
 	rateLimit       = flag.Int("web.rate-limit", 1, "Limit for requests per second.")
 	rateBurst       = flag.Int("web.rate-burst", defaultRateBurst, "Maximum per second burst rate for requests.")
+	shutdownTimeout = flag.Duration("web.shutdown-timeout", defaultShutdownTimeout,
+		"Maximum time to wait for in-flight requests to complete on shutdown.")
+	logLevel         = flag.String("log.level", "info", "Minimum level of log messages: debug, info, warn or error.")
+	logFormat        = flag.String("log.format", logging.FormatText, "Format of log messages: text or json.")
+	logDedupInterval = flag.Duration("log.dedup-interval", defaultLogDedupInterval,
+		"Interval in which repeated warnings and errors are only logged once, 0 disables deduplication.")
+	configFile = flag.String("config.file", "",
+		"Path to a YAML or JSON configuration file, reloaded on SIGHUP or when the file changes.")
+	enableOpenMetrics = flag.Bool("web.enable-openmetrics", false,
+		"Serves the OpenMetrics format to scrapers requesting it. The VF counters have no _total suffix, so OpenMetrics "+
+			"exposes them with the unknown type and without their created timestamps.")
+	openMetricsCreatedSamples = flag.Bool("web.openmetrics-created-samples", false,
+		"Adds _created samples with the created timestamp of each counter when serving the OpenMetrics text format, "+
+			"requires web.enable-openmetrics.")
+	replay = flag.String("replay", "",
+		"Path to a snapshot archive to serve metrics from instead of the host, as written by the snapshot command.")
+	demo            = flag.Bool("demo", false, "Serve metrics of a generated SR-IOV host instead of the host, for machines without SR-IOV NICs.")
 	metricsEndpoint = "/metrics"
 )
 
 func main() {
-	parseAndVerifyFlags()
+	if len(os.Args) > 1 && os.Args[1] == dumpCommand {
+		os.Exit(runDump(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == snapshotCommand {
+		os.Exit(runSnapshot(os.Args[2:], os.Stdout))
+	}
+
+	loader := parseAndVerifyFlags()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := startKube(ctx); err != nil {
+		slog.Error("pods could not be watched", "err", err)
+		removeReplay()
+		os.Exit(1)
+	}
 
-	err := prometheus.Register(collectors.Enabled())
+	collector := newReloadableCollector(collectors.Enabled())
+	err := prometheus.Register(collector)
 	if err != nil {
-		log.Fatalf("collector could not be registered: %v", err)
-		return
+		slog.Error("collector could not be registered", "err", err)
+		removeReplay()
+		os.Exit(1)
 	}
 
-	// Use the default promhttp handler wrapped with middleware to serve at the metrics endpoint
+	provider, err := startOTLP(ctx, collector)
+	if err != nil {
+		slog.Error("otlp push could not be started", "err", err)
+		removeReplay()
+		os.Exit(1)
+	}
+
+	// Use the metrics handler wrapped with middleware to serve at the metrics endpoint
+	limiter := rate.NewLimiter(rate.Limit(*rateLimit), *rateBurst)
 	handlerWithMiddleware := limitRequests(
 		getOnly(
 			endpointOnly(
-				noBody(promhttp.Handler()), metricsEndpoint)),
-		rate.Limit(*rateLimit), *rateBurst)
+				noBody(metricsHandler()), metricsEndpoint)),
+		limiter)
+
+	var workers sync.WaitGroup
+	if err := startCustomMetrics(ctx, &workers, collector); err != nil {
+		slog.Error("custom metrics api could not be started", "err", err)
+		removeReplay()
+		os.Exit(1)
+	}
+
+	// A replayed snapshot does not change, so the config file is not watched
+	if loader != nil && !replaying() {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			loader.watch(ctx, collector, limiter)
+		}()
+	}
+
+	if *pushMode != "" {
+		err = runPush(ctx, collector)
+	} else {
+		server := &http.Server{
+			Addr:              *addr,
+			Handler:           handlerWithMiddleware,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+		}
+		slog.Info("listening", "address", *addr)
+		err = serve(ctx, server, *shutdownTimeout)
+	}
+
+	stop()
+	workers.Wait()
+	stopOTLP(provider, *shutdownTimeout)
+	removeReplay()
+
+	if err != nil {
+		slog.Error("server error", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("exporter stopped")
+}
+
+// serve runs the server, over TLS if it has a TLS config, until it fails or the context is done. On shutdown it
+// stops accepting connections and waits up to the timeout for in-flight scrapes to complete.
+func serve(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
 
-	server := &http.Server{
-		Addr:              *addr,
-		Handler:           handlerWithMiddleware,
-		ReadHeaderTimeout: defaultReadHeaderTimeout,
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown failed\n%v", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
-	log.Printf("listening on %v", *addr)
-	log.Fatalf("ListenAndServe error: %v", server.ListenAndServe())
+
+	return nil
 }
 
-func parseAndVerifyFlags() {
+func parseAndVerifyFlags() *configLoader {
 	flag.Parse()
-	verifyFlags()
+
+	return loadAndVerifyFlags(config.CommandLine(flag.CommandLine))
+}
+
+// loadAndVerifyFlags applies the config file, if any, to the parsed flags and verifies them.
+// The flags set on the command line keep their value.
+func loadAndVerifyFlags(commandLine map[string]bool) *configLoader {
+	var loader *configLoader
+	if *configFile != "" {
+		loader = newConfigLoader(*configFile, commandLine)
+		if err := loader.load(); err != nil {
+			log.Panicf("failed to load config file\n%v", err)
+		}
+	}
+
+	if err := verifyFlags(); err != nil {
+		log.Panic(err)
+	}
+
+	switch {
+	case *replay != "":
+		if err := loadReplay(*replay); err != nil {
+			removeReplay()
+			log.Panicf("failed to load snapshot\n%v", err)
+		}
+	case *demo:
+		if err := loadDemo(); err != nil {
+			removeReplay()
+			log.Panicf("failed to generate demo host\n%v", err)
+		}
+	}
+
+	return loader
+}
+
+// metricsHandler serves the default registry like promhttp.Handler, and the OpenMetrics format to scrapers requesting
+// it if enabled
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics:                   *enableOpenMetrics,
+			EnableOpenMetricsTextCreatedSamples: *openMetricsCreatedSamples,
+		}))
 }
 
 // endpointOnly restricts all responses to 404 where the passed endpoint isn't used. Used to minimize the possible outputs of the server.
@@ -65,7 +227,7 @@ func endpointOnly(next http.Handler, endpoint string) http.Handler {
 			w.WriteHeader(http.StatusNotFound)
 			_, err := w.Write([]byte{})
 			if err != nil {
-				log.Print(err)
+				slog.Warn("could not write response", "err", err)
 			}
 			return
 		}
@@ -80,7 +242,7 @@ func getOnly(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			_, err := w.Write([]byte{})
 			if err != nil {
-				log.Print(err)
+				slog.Warn("could not write response", "err", err)
 			}
 			return
 		}
@@ -95,7 +257,7 @@ func noBody(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusBadRequest)
 			_, err := w.Write([]byte{})
 			if err != nil {
-				log.Print(err)
+				slog.Warn("could not write response", "err", err)
 			}
 			return
 		}
@@ -103,9 +265,8 @@ func noBody(next http.Handler) http.Handler {
 	})
 }
 
-// limitRequests sets a rate limit and a burst limit for requests to the endpoint
-func limitRequests(next http.Handler, rateLimit rate.Limit, burstLimit int) http.Handler {
-	limiter := rate.NewLimiter(rateLimit, burstLimit)
+// limitRequests applies the limiter's rate limit and burst limit to requests to the endpoint
+func limitRequests(next http.Handler, limiter *rate.Limiter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !limiter.Allow() {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
@@ -115,8 +276,86 @@ func limitRequests(next http.Handler, rateLimit rate.Limit, burstLimit int) http
 	})
 }
 
-func verifyFlags() {
+// verifyFlags validates the flags and applies the logger and paths they configure
+func verifyFlags() error {
+	if err := validateFlags(); err != nil {
+		return err
+	}
+
+	if err := configureLogging(); err != nil {
+		return fmt.Errorf("invalid logging configuration\n%v", err)
+	}
+
+	return resolveFilepaths()
+}
+
+// resolveFilepaths points the collectors at the resolved path flags. The paths of a replayed snapshot are resolved
+// when it is loaded.
+func resolveFilepaths() error {
+	if replaying() {
+		return nil
+	}
+
 	if err := collectors.ResolveFilepaths(); err != nil {
-		log.Panicf("failed to resolve paths\n%v", err)
+		return fmt.Errorf("failed to resolve paths\n%v", err)
+	}
+
+	return nil
+}
+
+// validateFlags checks the flags without applying them, so an invalid configuration is rejected before anything
+// read by the running collectors is changed
+func validateFlags() error {
+	if _, err := logging.New(io.Discard, *logLevel, *logFormat, *logDedupInterval); err != nil {
+		return fmt.Errorf("invalid logging configuration\n%v", err)
+	}
+
+	if !replaying() {
+		if err := collectors.VerifyFilepaths(); err != nil {
+			return fmt.Errorf("failed to resolve paths\n%v", err)
+		}
+	}
+
+	if err := collectors.VerifyReaders(); err != nil {
+		return fmt.Errorf("invalid stats reader configuration\n%v", err)
 	}
+
+	if *openMetricsCreatedSamples && !*enableOpenMetrics {
+		return fmt.Errorf("web.openmetrics-created-samples requires web.enable-openmetrics")
+	}
+
+	if err := collectors.VerifyKubePodCPUSource(); err != nil {
+		return fmt.Errorf("invalid kubepodcpu configuration\n%v", err)
+	}
+
+	if err := verifyKubeFlags(); err != nil {
+		return fmt.Errorf("invalid kube configuration\n%v", err)
+	}
+
+	if err := verifyCustomMetricsFlags(); err != nil {
+		return fmt.Errorf("invalid custommetrics configuration\n%v", err)
+	}
+
+	if err := verifyPushFlags(); err != nil {
+		return fmt.Errorf("invalid push configuration\n%v", err)
+	}
+
+	if *otlpEndpoint != "" {
+		if err := otlpConfig().Verify(); err != nil {
+			return fmt.Errorf("invalid otlp configuration\n%v", err)
+		}
+	}
+
+	return nil
+}
+
+// configureLogging replaces the default logger with one using the log flags
+func configureLogging() error {
+	logger, err := logging.New(os.Stderr, *logLevel, *logFormat, *logDedupInterval)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	return nil
 }
@@ -2,15 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/collectors"
 )
 
 func TestMain(t *testing.T) {
@@ -62,7 +70,7 @@ var _ = DescribeTable("test noBody handler", // noBody
 
 var _ = DescribeTable("test limitRequests handler", // limitRequests
 	func(limit int, requests int, expectedResponse int) {
-		handler := limitRequests(promhttp.Handler(), rate.Limit(limit), limit)
+		handler := limitRequests(promhttp.Handler(), rate.NewLimiter(rate.Limit(limit), limit))
 
 		code := http.StatusOK
 		for i := 0; i < requests; i++ {
@@ -78,3 +86,123 @@ var _ = DescribeTable("test limitRequests handler", // limitRequests
 	Entry("returns status 'OK' when the number of requests does not exceed the request limit", 10, 10, http.StatusOK),
 	Entry("returns status 'Too Many Requests' when number of requests exceeds the request limit", 10, 11, http.StatusTooManyRequests),
 )
+
+var _ = DescribeTable("test metrics handler", // metricsHandler
+	func(accept string, openMetrics, createdSamples bool, expectedContentType string, expected, unexpected []string) {
+		restoreAfterReplay()
+		Expect(loadDemo()).To(Succeed())
+		Expect(flag.Set("web.enable-openmetrics", strconv.FormatBool(openMetrics))).To(Succeed())
+		DeferCleanup(flag.Set, "web.enable-openmetrics", "false")
+		Expect(flag.Set("web.openmetrics-created-samples", strconv.FormatBool(createdSamples))).To(Succeed())
+		DeferCleanup(flag.Set, "web.openmetrics-created-samples", "false")
+
+		registry := prometheus.NewRegistry()
+		Expect(registry.Register(collectors.Enabled())).To(Succeed())
+		defaultRegisterer, defaultGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+		prometheus.DefaultRegisterer, prometheus.DefaultGatherer = registry, registry
+		DeferCleanup(func() { prometheus.DefaultRegisterer, prometheus.DefaultGatherer = defaultRegisterer, defaultGatherer })
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, metricsEndpoint, http.NoBody)
+		request.Header.Set("Accept", accept)
+		metricsHandler().ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix(expectedContentType))
+		for _, line := range expected {
+			Expect(recorder.Body.String()).To(ContainSubstring(line))
+		}
+		for _, line := range unexpected {
+			Expect(recorder.Body.String()).ToNot(ContainSubstring(line))
+		}
+	},
+	Entry("text format by default", "", false, false, "text/plain",
+		[]string{"# TYPE sriov_vf_rx_bytes counter"}, []string{"# EOF"}),
+	Entry("text format when openmetrics is requested but not enabled", "application/openmetrics-text; version=1.0.0", false, false,
+		"text/plain", []string{"# TYPE sriov_vf_rx_bytes counter"}, []string{"# EOF"}),
+	Entry("openmetrics when enabled and requested", "application/openmetrics-text; version=1.0.0", true, false,
+		"application/openmetrics-text",
+		// the vf counters have no _total suffix, so they lose their type and created timestamps
+		[]string{"# TYPE sriov_vf_rx_bytes unknown", "# UNIT sriov_vf_rx_bytes bytes", "# EOF"}, []string{"sriov_vf_rx_bytes_created"}),
+	Entry("openmetrics with created samples", "application/openmetrics-text; version=1.0.0", true, true,
+		// and their created samples are parsed as separate series
+		"application/openmetrics-text", []string{"# TYPE sriov_vf_rx_bytes unknown", "sriov_vf_rx_bytes_created{"}, nil),
+)
+
+var _ = Describe("test serving until shutdown", func() { // serve
+	var address string
+
+	BeforeEach(func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		address = lis.Addr().String()
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	// newServer returns a server whose handler signals entered and blocks until released is closed
+	newServer := func(entered chan<- struct{}, released <-chan struct{}) *http.Server {
+		return &http.Server{
+			Addr: address,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-released
+				w.WriteHeader(http.StatusOK)
+			}),
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+		}
+	}
+
+	// request gets the metrics endpoint once the server listens and sends the status code of the response
+	request := func(responses chan<- int) {
+		defer GinkgoRecover()
+		Eventually(func() error {
+			resp, err := http.Get("http://" + address + metricsEndpoint)
+			if err == nil {
+				responses <- resp.StatusCode
+				_ = resp.Body.Close()
+			}
+			return err
+		}).Should(Succeed())
+	}
+
+	It("waits for in-flight requests before returning", func() {
+		entered, released := make(chan struct{}, 1), make(chan struct{})
+		server := newServer(entered, released)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- serve(ctx, server, time.Minute) }()
+
+		responses := make(chan int, 1)
+		go request(responses)
+
+		Eventually(entered).Should(Receive())
+		cancel()
+		Consistently(served, 100*time.Millisecond).ShouldNot(Receive())
+
+		close(released)
+		Eventually(responses).Should(Receive(Equal(http.StatusOK)))
+		Eventually(served).Should(Receive(BeNil()))
+	})
+
+	It("returns an error when in-flight requests outlast the timeout", func() {
+		entered, released := make(chan struct{}, 1), make(chan struct{})
+		defer close(released)
+		server := newServer(entered, released)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- serve(ctx, server, 50*time.Millisecond) }()
+
+		go request(make(chan int, 1))
+
+		Eventually(entered).Should(Receive())
+		cancel()
+		Eventually(served).Should(Receive(MatchError(ContainSubstring("shutdown failed"))))
+	})
+
+	It("returns the error when the server can not start", func() {
+		server := newServer(make(chan struct{}, 1), nil)
+		server.Addr = "invalid-address"
+		Expect(serve(context.Background(), server, time.Minute)).To(HaveOccurred())
+	})
+})
@@ -8,7 +8,8 @@ package collectors
 import (
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -23,6 +24,10 @@ const (
 	labelPF          = "pf"
 	labelVF          = "vf"
 	labelUID         = "uid"
+	labelStat        = "stat"
+	labelReader      = "reader"
+	labelReason      = "reason"
+	labelCollector   = "collector"
 )
 
 // Stats reader type constants.
@@ -37,23 +42,63 @@ var (
 	disabled           = false
 	collectorState     = make(map[string]*bool)
 	collectorFunctions = make(map[string]func() prometheus.Collector)
+	collectorFlags     = make(map[string][]string)
 )
 
 // SriovCollector registers the collectors used for specific data and exposes a Collect method to gather the data
 type SriovCollector []prometheus.Collector
 
+// namedCollector is an enabled collector together with its name, so it can be kept when the collectors are rebuilt
+type namedCollector struct {
+	prometheus.Collector
+	name string
+}
+
+// scrapeCollector is a collector sharing what is read during a scrape with the other collectors of the scrape
+type scrapeCollector interface {
+	collectScrape(ch chan<- prometheus.Metric, s *scrape)
+}
+
+// scrape lazily reads the vf stats once and shares them between all collectors of a scrape. The stats are read with
+// the caches of the first collector asking for them, all of them read the same pfs with the same flags.
+type scrape struct {
+	once sync.Once
+	pfs  []pfStats
+}
+
+// vfStats returns the raw stats of the vfs of every pf with a stats reader, read by c on the first call
+func (s *scrape) vfStats(c sriovDevCollector) []pfStats {
+	s.once.Do(func() {
+		s.pfs = c.readStats()
+	})
+
+	return s.pfs
+}
+
 // Register defines a flag for a collector and adds it to the registry of enabled collectors
 // if the flag is set to true - either through the default option or the flag passed on start.
+// The flags the collector reads when it is created, and whose change requires creating it again, are passed in flags.
 // Run by each individual collector in its init function.
-func register(name string, enabled bool, collector func() prometheus.Collector) {
+func register(name string, enabled bool, collector func() prometheus.Collector, flags ...string) {
 	collectorState[name] = &enabled
 	collectorFunctions[name] = collector
+	collectorFlags[name] = flags
 	flag.BoolVar(collectorState[name], "collector."+name, enabled, fmt.Sprintf("Enables the %v collector", name))
 }
 
-// Collect metrics from all enabled collectors in unordered sequence.
+// Collect metrics from all enabled collectors in unordered sequence, sharing the vf stats read for the scrape.
 func (s SriovCollector) Collect(ch chan<- prometheus.Metric) {
+	current := &scrape{}
 	for _, collector := range s {
+		if named, ok := collector.(namedCollector); ok {
+			collector = named.Collector
+		}
+
+		if c, ok := collector.(scrapeCollector); ok {
+			c.collectScrape(ch, current)
+			continue
+		}
+
 		collector.Collect(ch)
 	}
 }
@@ -70,22 +115,70 @@ func Enabled() SriovCollector {
 	collectors := make([]prometheus.Collector, 0)
 	for collector, enabled := range collectorState {
 		if enabled != nil && *enabled {
-			log.Printf("The %v collector is enabled", collector)
-			collectors = append(collectors, collectorFunctions[collector]())
+			slog.Info("collector enabled", labelCollector, collector)
+			collectors = append(collectors, namedCollector{collectorFunctions[collector](), collector})
+		}
+	}
+	return collectors
+}
+
+// Rebuild returns the enabled collectors, keeping the collectors of current, and their caches, that are still enabled
+// and none of whose flags are in changed. The other enabled collectors are created again. Collectors keep the state
+// that has to outlive a rebuild, such as counters, in package level trackers shared by the collectors created again.
+func Rebuild(current SriovCollector, changed map[string]bool) SriovCollector {
+	kept := make(map[string]prometheus.Collector, len(current))
+	for _, collector := range current {
+		if named, ok := collector.(namedCollector); ok {
+			kept[named.name] = named
+		}
+	}
+
+	collectors := make([]prometheus.Collector, 0)
+	for collector, enabled := range collectorState {
+		if enabled == nil || !*enabled {
+			continue
+		}
+
+		if c, ok := kept[collector]; ok && !flagsChanged(collectorFlags[collector], changed) {
+			collectors = append(collectors, c)
+			continue
 		}
+
+		slog.Info("collector enabled", labelCollector, collector)
+		collectors = append(collectors, namedCollector{collectorFunctions[collector](), collector})
 	}
 	return collectors
 }
 
+func flagsChanged(flags []string, changed map[string]bool) bool {
+	for _, name := range flags {
+		if changed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveFilepaths resolves the path flags and points the collectors at them
 func ResolveFilepaths() error {
-	resolveFuncs := []func() error{
+	return resolveFilepaths(true)
+}
+
+// VerifyFilepaths checks that the path flags resolve, without changing the flags or the paths read by the collectors
+func VerifyFilepaths() error {
+	return resolveFilepaths(false)
+}
+
+func resolveFilepaths(apply bool) error {
+	resolveFuncs := []func(bool) error{
 		resolveSriovDevFilepaths,
 		resolveKubePodCPUFilepaths,
 		resolveKubePodDeviceFilepaths,
+		resolveVfResourcePoolFilepaths,
 	}
 
 	for _, resolveFunc := range resolveFuncs {
-		if err := resolveFunc(); err != nil {
+		if err := resolveFunc(apply); err != nil {
 			return err
 		}
 	}
@@ -93,6 +186,18 @@ func ResolveFilepaths() error {
 	return nil
 }
 
-var logFatal = func(msg string, args ...any) {
-	log.Fatalf(msg, args...)
+var collectorSuccessDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(collectorNamespace, "collector", "success"),
+	"Whether the last collection by the collector succeeded",
+	[]string{labelCollector}, nil,
+)
+
+// publishCollectorSuccess reports the outcome of a collection, so failures are visible as a metric instead of ending the scrape or the process
+func publishCollectorSuccess(ch chan<- prometheus.Metric, name string, err error) {
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, name)
 }
@@ -1,10 +1,12 @@
 package collectors
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"path/filepath"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -27,18 +29,46 @@ func TestCollectors(t *testing.T) {
 var _ = BeforeSuite(func() {
 	utils.EvalSymlinks = evalSymlinks
 
-	logFatal = func(msg string, args ...any) {
-		log.Printf(msg, args...)
-	}
-
-	log.SetFlags(0)
+	slog.SetDefault(slog.New(testLogHandler{}))
 })
 
 var _ = BeforeEach(func() {
 	buffer = *gbytes.NewBuffer()
-	log.SetOutput(&buffer)
+
+	readerProbeFailures.reset()
 })
 
+// testLogHandler writes log records of every level to the test buffer as the message followed by key=value attributes
+type testLogHandler struct {
+	attrs []slog.Attr
+}
+
+func (h testLogHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h testLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+
+	writeAttr := func(attr slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+		return true
+	}
+	for _, attr := range h.attrs {
+		writeAttr(attr)
+	}
+	r.Attrs(writeAttr)
+	b.WriteString("\n")
+
+	_, err := buffer.Write([]byte(b.String()))
+	return err
+}
+
+func (h testLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return testLogHandler{attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h testLogHandler) WithGroup(string) slog.Handler { return h }
+
 type metric struct {
 	labels  map[string]string
 	counter float64
@@ -80,6 +110,61 @@ var _ = DescribeTable("test registering collector", // register
 
 // TODO: create Enabled unit test
 
+var _ = Describe("test rebuilding the enabled collectors", func() { // Rebuild
+	var testEnabled, otherEnabled bool
+
+	BeforeEach(func() {
+		state, functions, flags := collectorState, collectorFunctions, collectorFlags
+		DeferCleanup(func() {
+			collectorState, collectorFunctions, collectorFlags = state, functions, flags
+		})
+
+		testEnabled, otherEnabled = true, true
+		collectorState = map[string]*bool{"test": &testEnabled, "other": &otherEnabled}
+		collectorFunctions = map[string]func() prometheus.Collector{
+			"test":  func() prometheus.Collector { return prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"}) },
+			"other": func() prometheus.Collector { return prometheus.NewGauge(prometheus.GaugeOpts{Name: "other"}) },
+		}
+		collectorFlags = map[string][]string{"test": {"path.test"}}
+	})
+
+	byName := func(c SriovCollector) map[string]prometheus.Collector {
+		collectors := make(map[string]prometheus.Collector, len(c))
+		for _, collector := range c {
+			collectors[collector.(namedCollector).name] = collector
+		}
+		return collectors
+	}
+
+	It("keeps the collectors whose flags did not change", func() {
+		current := byName(Enabled())
+
+		kept := byName(Rebuild(SriovCollector{current["test"], current["other"]}, map[string]bool{"web.rate-limit": true}))
+		Expect(kept).To(HaveLen(2))
+		Expect(kept["test"]).To(BeIdenticalTo(current["test"]))
+		Expect(kept["other"]).To(BeIdenticalTo(current["other"]))
+	})
+
+	It("creates the collectors whose flags changed again", func() {
+		current := byName(Enabled())
+
+		rebuilt := byName(Rebuild(SriovCollector{current["test"], current["other"]}, map[string]bool{"path.test": true}))
+		Expect(rebuilt["test"]).NotTo(BeIdenticalTo(current["test"]))
+		Expect(rebuilt["other"]).To(BeIdenticalTo(current["other"]))
+	})
+
+	It("drops disabled collectors and creates enabled ones", func() {
+		otherEnabled = false
+		current := Enabled()
+		Expect(current).To(HaveLen(1))
+
+		testEnabled, otherEnabled = false, true
+		rebuilt := byName(Rebuild(current, map[string]bool{"collector.test": true, "collector.other": true}))
+		Expect(rebuilt).To(HaveLen(1))
+		Expect(rebuilt).To(HaveKey("other"))
+	})
+})
+
 func assertLogs(logs []string) {
 	for _, log := range logs {
 		Eventually(&buffer).WithTimeout(2 * time.Second).Should(gbytes.Say(log))
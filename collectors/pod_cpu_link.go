@@ -8,7 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -20,6 +20,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Sources of the pod cpus published by the kubepodcpu collector
+const (
+	cpuSourceCgroup       = "cgroup"
+	cpuSourcePodResources = "podresources"
+)
+
 var (
 	kubepodcpu        = "kubepodcpu"
 	kubePodCgroupPath = flag.String("path.kubecgroup",
@@ -27,6 +33,8 @@ var (
 	sysDevSysNodePath = flag.String("path.nodecpuinfo", "/sys/devices/system/node/", "Path for location of system cpu information")
 	cpuCheckPointFile = flag.String("path.cpucheckpoint",
 		"/var/lib/kubelet/cpu_manager_state", "Path for cpu manager checkpoint file")
+	kubePodCPUSource = flag.String("collector.kubepodcpusource", cpuSourceCgroup,
+		"Source of the pod cpus published by the kubepodcpu collector: cgroup or podresources")
 
 	kubecgroupfs    fs.FS
 	cpuinfofs       fs.FS
@@ -35,8 +43,11 @@ var (
 
 // kubepodCPUCollector holds a static representation of node cpu topology and uses it to update information about kubernetes pod cpu usage.
 type kubepodCPUCollector struct {
-	cpuInfo map[string]string
-	name    string
+	cpuInfo    map[string]string
+	cpuInfoErr error
+	source     string
+	enrichment PodEnrichment
+	name       string
 }
 
 // podCPULink contains the information about the pod and container a single cpu is attached to
@@ -53,11 +64,14 @@ type cpuManagerCheckpoint struct {
 
 // init runs the registration for this collector on package import
 func init() {
-	register(kubepodcpu, disabled, createKubepodCPUCollector)
+	register(kubepodcpu, disabled, createKubepodCPUCollector,
+		"path.nodecpuinfo", "path.kubecgroup", "path.cpucheckpoint", "collector.kubepodcpusource")
 }
 
 // Collect publishes the cpu information and all kubernetes pod cpu information to the prometheus channel
-// On each run it reads the guaranteed pod cpus and exposes the pod, container, and NUMA IDs to the collector
+// On each run it reads the guaranteed pod cpus from the cgroups or the kubelet pod resources, depending on
+// collector.kubepodcpusource, and exposes the pod, container, and NUMA IDs to the collector
+// Failures are published under sriov_collector_success.
 func (c kubepodCPUCollector) Collect(ch chan<- prometheus.Metric) {
 	// This exposes the basic cpu alignment to prometheus.
 	for cpu, numa := range c.cpuInfo {
@@ -77,29 +91,78 @@ func (c kubepodCPUCollector) Collect(ch chan<- prometheus.Metric) {
 		)
 	}
 
-	links, err := getGuaranteedPodCPUs()
+	var err error
+	if c.source == cpuSourcePodResources {
+		err = c.collectPodResourcesCPUs(ch)
+	} else {
+		err = c.collectCgroupCPUs(ch)
+	}
 	if err != nil {
-		log.Printf("pod cpu links not available: %v", err)
+		slog.Warn("pod cpu links not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
 		return
 	}
 
-	for _, link := range links {
-		desc := prometheus.NewDesc(
-			prometheus.BuildFQName(collectorNamespace, "", c.name),
-			"pod_cpu",
-			[]string{labelCPUID, labelNumaNode, labelUID, labelContainerID}, nil,
-		)
+	publishCollectorSuccess(ch, c.name, c.cpuInfoErr)
+}
 
+// collectCgroupCPUs publishes the guaranteed pod cpus read from the kubernetes cgroups, labeled by pod UID and
+// runtime container ID
+func (c kubepodCPUCollector) collectCgroupCPUs(ch chan<- prometheus.Metric) error {
+	links, err := getGuaranteedPodCPUs()
+	if err != nil {
+		return err
+	}
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "", c.name),
+		"pod_cpu",
+		c.enrichment.podLabelNames([]string{labelCPUID, labelNumaNode, labelUID, labelContainerID}), nil,
+	)
+	for _, link := range links {
+		// The cgroup names hold the pod UID with '_' in place of '-'
+		pod := c.enrichment.podByUID(strings.ReplaceAll(link.podID, "_", "-"))
 		ch <- prometheus.MustNewConstMetric(
 			desc,
 			prometheus.CounterValue,
 			1,
-			link.cpu,
-			c.cpuInfo[link.cpu],
-			link.podID,
-			link.containerID,
+			c.enrichment.podLabelValues([]string{link.cpu, c.cpuInfo[link.cpu], link.podID, link.containerID}, pod)...,
 		)
 	}
+
+	return nil
+}
+
+// collectPodResourcesCPUs publishes the exclusive cpus the kubelet pod resources API reports for each container,
+// labeled by pod, namespace and container name
+func (c kubepodCPUCollector) collectPodResourcesCPUs(ch chan<- prometheus.Metric) error {
+	resources, err := listPodResources()
+	if err != nil {
+		return err
+	}
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "", c.name),
+		"pod_cpu",
+		c.enrichment.podLabelNames([]string{labelCPUID, labelNumaNode, "pod", "namespace", "container"}), nil,
+	)
+	for _, podRes := range resources {
+		pod := c.enrichment.pod(podRes.GetNamespace(), podRes.GetName())
+		for _, container := range podRes.GetContainers() {
+			for _, cpu := range container.GetCpuIds() {
+				cpuID := strconv.FormatInt(cpu, 10)
+				ch <- prometheus.MustNewConstMetric(
+					desc,
+					prometheus.CounterValue,
+					1,
+					c.enrichment.podLabelValues(
+						[]string{cpuID, c.cpuInfo[cpuID], podRes.GetName(), podRes.GetNamespace(), container.GetName()}, pod)...,
+				)
+			}
+		}
+	}
+
+	return nil
 }
 
 // Describe is not defined for this collector
@@ -107,17 +170,19 @@ func (c kubepodCPUCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // createKubepodCPUCollector creates a static picture of the cpu topology of the system and returns a collector
-// It also creates a static list of cpus in the kubernetes parent cgroup.
+// If the topology can not be read the collector still publishes pod cpus, without their NUMA nodes, and reports the failure.
 func createKubepodCPUCollector() prometheus.Collector {
 	cpuInfo, err := getCPUInfo()
 	if err != nil {
-		// Exporter will fail here if file can not be read.
-		logFatal("Fatal Error: cpu info for node can not be collected, %v", err.Error())
+		slog.Warn("cpu info for node can not be collected", labelCollector, kubepodcpu, "err", err)
 	}
 
 	return kubepodCPUCollector{
-		cpuInfo: cpuInfo,
-		name:    kubepodcpu,
+		cpuInfo:    cpuInfo,
+		cpuInfoErr: err,
+		source:     *kubePodCPUSource,
+		enrichment: podEnrichment,
+		name:       kubepodcpu,
 	}
 }
 
@@ -162,7 +227,10 @@ func getCPUInfo() (map[string]string, error) {
 func getGuaranteedPodCPUs() ([]podCPULink, error) {
 	links := make([]podCPULink, 0)
 
-	kubeCPUString, kubeDefaultSet := getKubeDefaults()
+	kubeCPUString, kubeDefaultSet, err := getKubeDefaults()
+	if err != nil {
+		return links, err
+	}
 
 	podDirectoryFilenames, err := getPodDirectories()
 	if err != nil {
@@ -239,7 +307,7 @@ func readDefaultSet(data []byte) string {
 	checkpointFile := cpuManagerCheckpoint{}
 
 	if err := json.Unmarshal(data, &checkpointFile); err != nil {
-		log.Printf("cpu checkpoint file could not be unmarshalled, error: %v", err)
+		slog.Warn("cpu checkpoint file could not be unmarshalled", labelCollector, kubepodcpu, "err", err)
 		return ""
 	}
 
@@ -283,37 +351,63 @@ func parseCPURange(cpuString string) ([]string, error) {
 	return cpuList, nil
 }
 
-func getKubeDefaults() (string, string) {
+// getKubeDefaults reads the cpus of the kubernetes parent cgroup and the default cpu set of the cpu manager
+func getKubeDefaults() (string, string, error) {
 	kubeCPUString, err := readCPUSet("cpuset.cpus")
 	if err != nil {
-		// Exporter killed here as CPU collector can not work without this information.
-		logFatal("Fatal Error: cannot get information on Kubernetes CPU usage, %v", err.Error())
+		// The CPU collector can not work without this information.
+		return "", "", fmt.Errorf("cannot get information on Kubernetes CPU usage, %v", err)
 	}
 
 	cpuRawBytes, err := fs.ReadFile(cpucheckpointfs, filepath.Base(*cpuCheckPointFile))
 	if err != nil {
-		log.Printf("unable to read cpu checkpoint file '%s', error: %v", *cpuCheckPointFile, err)
+		slog.Warn("unable to read cpu checkpoint file", labelCollector, kubepodcpu, "path", *cpuCheckPointFile, "err", err)
 	}
 
-	return kubeCPUString, readDefaultSet(cpuRawBytes)
+	return kubeCPUString, readDefaultSet(cpuRawBytes), nil
 }
 
-func resolveKubePodCPUFilepaths() error {
-	if err := utils.ResolveFlag("path.kubecgroup", kubePodCgroupPath); err != nil {
+func resolveKubePodCPUFilepaths(apply bool) error {
+	nodePath := *sysDevSysNodePath
+	if err := utils.ResolveFlag("path.nodecpuinfo", &nodePath); err != nil {
 		return err
 	}
 
-	if err := utils.ResolveFlag("path.nodecpuinfo", sysDevSysNodePath); err != nil {
+	// The pod resources source does not read the cgroups or the checkpoint, so they need not be mounted
+	if *kubePodCPUSource == cpuSourcePodResources {
+		if apply {
+			*sysDevSysNodePath = nodePath
+			cpuinfofs = os.DirFS(nodePath)
+		}
+		return nil
+	}
+
+	cgroupPath, checkpointFile := *kubePodCgroupPath, *cpuCheckPointFile
+	if err := utils.ResolveFlag("path.kubecgroup", &cgroupPath); err != nil {
 		return err
 	}
 
-	if err := utils.ResolveFlag("path.cpucheckpoint", cpuCheckPointFile); err != nil {
+	if err := utils.ResolveFlag("path.cpucheckpoint", &checkpointFile); err != nil {
 		return err
 	}
 
-	kubecgroupfs = os.DirFS(*kubePodCgroupPath)
-	cpuinfofs = os.DirFS(*sysDevSysNodePath)
-	cpucheckpointfs = os.DirFS(filepath.Dir(*cpuCheckPointFile))
+	if apply {
+		*sysDevSysNodePath, *kubePodCgroupPath, *cpuCheckPointFile = nodePath, cgroupPath, checkpointFile
+		cpuinfofs = os.DirFS(nodePath)
+		kubecgroupfs = os.DirFS(cgroupPath)
+		cpucheckpointfs = os.DirFS(filepath.Dir(checkpointFile))
+	}
 
 	return nil
 }
+
+// VerifyKubePodCPUSource checks that the kubepodcpu collector source flag names a known source
+func VerifyKubePodCPUSource() error {
+	switch *kubePodCPUSource {
+	case cpuSourceCgroup, cpuSourcePodResources:
+		return nil
+	default:
+		return fmt.Errorf("collector.kubepodcpusource - unknown source '%s', must be %s or %s",
+			*kubePodCPUSource, cpuSourceCgroup, cpuSourcePodResources)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
 )
 
 var _ = DescribeTable("test pod cpu link collection", // Collect
@@ -32,7 +33,11 @@ var _ = DescribeTable("test pod cpu link collection", // Collect
 				labels[*label.Name] = *label.Value
 			}
 
-			metric := metric{labels: labels, counter: *m.Counter.Value}
+			value := m.GetCounter().GetValue()
+			if m.Gauge != nil {
+				value = m.GetGauge().GetValue()
+			}
+			metric := metric{labels: labels, counter: value}
 
 			Expect(metric).To(BeElementOf(expected))
 		}
@@ -68,7 +73,8 @@ var _ = DescribeTable("test pod cpu link collection", // Collect
 			{map[string]string{
 				"cpu_id": "3", "numa_node": "1",
 				"uid": "6b5b533a_6307_48d1_911f_07bf5d4e1c82", "container_id": "0123456789abcdefaaaa",
-			}, 1}}),
+			}, 1},
+			{map[string]string{"collector": kubepodcpu}, 1}}),
 	Entry("test unavailable kube cgroup directory",
 		fstest.MapFS{
 			"node0/cpu0":        {Mode: fs.ModeDir},
@@ -82,11 +88,77 @@ var _ = DescribeTable("test pod cpu link collection", // Collect
 			{map[string]string{"cpu": "cpu0", "numa_node": "0"}, 1},
 			{map[string]string{"cpu": "cpu2", "numa_node": "0"}, 1},
 			{map[string]string{"cpu": "cpu1", "numa_node": "1"}, 1},
-			{map[string]string{"cpu": "cpu3", "numa_node": "1"}, 1}},
-		"pod cpu links not available: could not read cpu files directory: "+
+			{map[string]string{"cpu": "cpu3", "numa_node": "1"}, 1},
+			{map[string]string{"collector": kubepodcpu}, 0}},
+		"pod cpu links not available collector=kubepodcpu err=could not read cpu files directory: "+
 			"readdir kubepods-pod6b5b533a_6307_48d1_911f_07bf5d4e1c83.slice: not implemented"),
 )
 
+var _ = Describe("test pod cpu link collection from pod resources", func() { // collectPodResourcesCPUs
+	BeforeEach(func() {
+		*kubePodCPUSource = cpuSourcePodResources
+		cpuinfofs = fstest.MapFS{
+			"node0/cpu0": {Mode: fs.ModeDir},
+			"node1/cpu1": {Mode: fs.ModeDir},
+		}
+		DeferCleanup(func() {
+			*kubePodCPUSource = cpuSourceCgroup
+			listPodResources = PodResources
+		})
+	})
+
+	It("publishes the exclusive cpus with the pod, namespace and container names", func() {
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return []*v1.PodResources{{
+				Name:      "pod1",
+				Namespace: "default",
+				Containers: []*v1.ContainerResources{
+					{Name: "app", CpuIds: []int64{0, 1}},
+					{Name: "sidecar"},
+				},
+			}}, nil
+		}
+
+		Expect(gatherMetrics(createKubepodCPUCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepodcpu"] 1`,
+			`sriov_cpu_info[name:"cpu" value:"cpu0" name:"numa_node" value:"0"] 1`,
+			`sriov_cpu_info[name:"cpu" value:"cpu1" name:"numa_node" value:"1"] 1`,
+			`sriov_kubepodcpu[name:"container" value:"app" name:"cpu_id" value:"0" name:"namespace" value:"default" ` +
+				`name:"numa_node" value:"0" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepodcpu[name:"container" value:"app" name:"cpu_id" value:"1" name:"namespace" value:"default" ` +
+				`name:"numa_node" value:"1" name:"pod" value:"pod1"] 1`,
+		}))
+	})
+
+	It("reports a failure when pod resources can not be listed", func() {
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return nil, errors.New("kubelet restarting")
+		}
+
+		Expect(gatherMetrics(createKubepodCPUCollector())).To(ContainElement(
+			`sriov_collector_success[name:"collector" value:"kubepodcpu"] 0`))
+		assertLogs([]string{"pod cpu links not available collector=kubepodcpu err=kubelet restarting"})
+	})
+})
+
+var _ = DescribeTable("test verifying the pod cpu source", // VerifyKubePodCPUSource
+	func(source string, expectedErr error) {
+		*kubePodCPUSource = source
+		DeferCleanup(func() { *kubePodCPUSource = cpuSourceCgroup })
+
+		err := VerifyKubePodCPUSource()
+		if expectedErr != nil {
+			Expect(err).To(MatchError(expectedErr.Error()))
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
+	},
+	Entry("cgroup", cpuSourceCgroup, nil),
+	Entry("pod resources", cpuSourcePodResources, nil),
+	Entry("unknown source", "checkpoint",
+		errors.New("collector.kubepodcpusource - unknown source 'checkpoint', must be cgroup or podresources")),
+)
+
 var _ = DescribeTable("test reading default cpu set", // readDefaultSet
 	func(data []byte, expected string, logs ...string) {
 		Expect(readDefaultSet(data)).To(Equal(expected))
@@ -102,7 +174,7 @@ var _ = DescribeTable("test reading default cpu set", // readDefaultSet
 	Entry("read failed with malformed data",
 		[]byte("\"policyName\":\"none\",\"checksum\":1353318690"),
 		"",
-		"cpu checkpoint file could not be unmarshalled, error: invalid character ':' after top-level value"),
+		"cpu checkpoint file could not be unmarshalled collector=kubepodcpu err=invalid character ':' after top-level value"),
 )
 
 var _ = DescribeTable("test creating kubepodCPU collector", // createKubepodCPUCollector
@@ -120,44 +192,59 @@ var _ = DescribeTable("test creating kubepodCPU collector", // createKubepodCPUC
 			"node0/cpu2": {Mode: fs.ModeDir},
 			"node1/cpu1": {Mode: fs.ModeDir},
 			"node1/cpu3": {Mode: fs.ModeDir}},
-		kubepodCPUCollector{cpuInfo: map[string]string{"0": "0", "2": "0", "1": "1", "3": "1"}, name: kubepodcpu}),
+		kubepodCPUCollector{cpuInfo: map[string]string{"0": "0", "2": "0", "1": "1", "3": "1"}, source: cpuSourceCgroup, name: kubepodcpu}),
 	Entry("directory doesn't exist",
 		fstest.MapFS{".": {Mode: fs.ModeExclusive}}, // to emulate the directory doesn't exist
-		kubepodCPUCollector{cpuInfo: map[string]string{}, name: kubepodcpu},
-		"Fatal Error: cpu info for node can not be collected, failed to read directory '/sys/devices/system/node/'\nreaddir .: not implemented"),
+		kubepodCPUCollector{
+			cpuInfo:    map[string]string{},
+			cpuInfoErr: fmt.Errorf("failed to read directory '/sys/devices/system/node/'\nreaddir .: not implemented"),
+			source:     cpuSourceCgroup,
+			name:       kubepodcpu,
+		},
+		"cpu info for node can not be collected collector=kubepodcpu err=failed to read directory '/sys/devices/system/node/'\nreaddir .: not implemented"),
 )
 
 var _ = DescribeTable("test getting kubernetes cpu list", // getKubeDefaults
-	func(fsys fs.FS, expectedKubeCPUString, expectedDefaultSet string) {
+	func(fsys fs.FS, expectedKubeCPUString, expectedDefaultSet string, expectedErr error) {
 		kubecgroupfs = fsys
 		cpucheckpointfs = fsys
 
-		kubeCPUString, kubeDefaultSet := getKubeDefaults()
+		kubeCPUString, kubeDefaultSet, err := getKubeDefaults()
 		Expect(kubeCPUString).To(Equal(expectedKubeCPUString))
 		Expect(kubeDefaultSet).To(Equal(expectedDefaultSet))
+
+		if expectedErr != nil {
+			Expect(err).To(MatchError(expectedErr.Error()))
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
 	},
 	Entry("read empty",
 		fstest.MapFS{
 			"cpuset.cpus":       {Data: []byte("")},
 			"cpu_manager_state": {Data: []byte("")}},
 		"",
-		""),
+		"",
+		nil),
 	Entry("read successful",
 		fstest.MapFS{
 			"cpuset.cpus":       {Data: []byte("0-87")},
 			"cpu_manager_state": {Data: []byte("{\"policyName\":\"static\",\"defaultCpuSet\":\"0-63\",\"checksum\":1058907510}")}},
 		"0-87",
-		"0-63"),
+		"0-63",
+		nil),
 	Entry("read successful with malformed data",
 		fstest.MapFS{
 			"cpuset.cpus":       {Data: []byte(" 0-87  ")},
 			"cpu_manager_state": {Data: []byte("{\"policyName\":\"static\",\"defaultCpuSet\":\"0-63\",\"checksum\":1058 907 51 0}")}},
 		"0-87",
-		""),
+		"",
+		nil),
 	Entry("read failed, file doesn't exist",
 		fstest.MapFS{},
 		"",
-		""),
+		"",
+		fmt.Errorf("cannot get information on Kubernetes CPU usage, could not open cgroup cpuset files, error: open cpuset.cpus: file does not exist")),
 )
 
 var _ = DescribeTable("test getting guaranteed pod cpus", // guaranteedPodCPUs
@@ -188,10 +275,7 @@ var _ = DescribeTable("test getting guaranteed pod cpus", // guaranteedPodCPUs
 	Entry("cgroup directory doesn't exist",
 		fstest.MapFS{".": {Mode: fs.ModeExclusive}},
 		[]podCPULink{},
-		fmt.Errorf("could not open path kubePod cgroups: readdir .: not implemented"),
-		"cannot get information on Kubernetes CPU usage, could not open cgroup cpuset files, error: open cpuset.cpus: file does not exist",
-		"unable to read cpu checkpoint file '/var/lib/kubelet/cpu_manager_state', error: open cpu_manager_state: file does not exist",
-		"cpu checkpoint file could not be unmarshalled, error: unexpected end of JSON input"),
+		fmt.Errorf("cannot get information on Kubernetes CPU usage, could not open cgroup cpuset files, error: open cpuset.cpus: file does not exist")),
 	Entry("unable to read pod cgroup directory",
 		fstest.MapFS{
 			"cpuset.cpus":       {Data: []byte("0-3")},
@@ -6,16 +6,20 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
 
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
 	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
 )
 
@@ -29,11 +33,18 @@ var (
 	podResourcesPath = flag.String("path.kubeletsocket",
 		"/var/lib/kubelet/pod-resources/kubelet.sock", "Path to kubelet resources socket")
 	pciAddressPattern = regexp.MustCompile(`^[[:xdigit:]]{4}:[[:xdigit:]]{2}:[[:xdigit:]]{2}\.\d$`)
+	// draPCIAddressPattern matches a PCI address at the end of a DRA or CDI device name. DRA device names can not
+	// contain ':' or '.', so drivers commonly replace them with '-', e.g. "pci-0000-3b-02-0".
+	draPCIAddressPattern = regexp.MustCompile(`([[:xdigit:]]{4})[-:]([[:xdigit:]]{2})[-:]([[:xdigit:]]{2})[-.](\d)$`)
+
+	// listPodResources is replaced when metrics are replayed from a snapshot
+	listPodResources = PodResources
 )
 
 // podDevLinkCollector the basic type used to collect information on kubernetes device links
 type podDevLinkCollector struct {
-	name string
+	enrichment PodEnrichment
+	name       string
 }
 
 // init runs the registration for this collector on package import
@@ -44,12 +55,27 @@ func init() {
 // This collector starts by making a call to the kubelet api which could create a delay.
 // This information could be cached on a loop after the previous call to improve prometheus scraping performance.
 
-// Collect scrapes the kubelet api and structures the returned value into a prometheus info metric.
+// Collect scrapes the kubelet api, or the device manager checkpoint if the api is not available, and structures the
+// returned value into a prometheus info metric. Failures to read both are published under sriov_collector_success.
 func (c podDevLinkCollector) Collect(ch chan<- prometheus.Metric) {
-	resources := PodResources()
+	resources, source, err := podResourcesWithFallback(c.enrichment)
+	if err != nil {
+		slog.Warn("pod resources not available", labelCollector, c.name, "err", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(podResourcesSourceDesc, prometheus.GaugeValue, 1, source)
+	}
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "", c.name),
+		c.name,
+		c.enrichment.deviceLabelNames([]string{labelPCIAddr, "dev_type", "pod", "namespace", "container"}), nil,
+	)
+
 	for _, podRes := range resources {
 		podName := podRes.GetName()
 		podNamespace := podRes.GetNamespace()
+		pod := c.enrichment.pod(podNamespace, podName)
+		networks := c.enrichment.deviceNetworks(pod, c.name)
 		for _, contRes := range podRes.Containers {
 			contName := contRes.GetName()
 			for _, devices := range contRes.GetDevices() {
@@ -59,24 +85,68 @@ func (c podDevLinkCollector) Collect(ch chan<- prometheus.Metric) {
 						continue
 					}
 
-					desc := prometheus.NewDesc(
-						prometheus.BuildFQName(collectorNamespace, "", c.name),
-						c.name,
-						[]string{labelPCIAddr, "dev_type", "pod", "namespace", "container"}, nil,
-					)
-
 					ch <- prometheus.MustNewConstMetric(
 						desc,
 						prometheus.CounterValue,
 						1,
-						dev,
-						devType,
-						podName,
-						podNamespace,
-						contName,
+						c.enrichment.deviceLabelValues([]string{dev, devType, podName, podNamespace, contName}, pod, networks, dev)...,
 					)
 				}
 			}
+
+			c.collectDynamicResources(ch, desc, contRes.GetDynamicResources(), pod, networks, podName, podNamespace, contName)
+		}
+	}
+
+	publishCollectorSuccess(ch, c.name, err)
+}
+
+// collectDynamicResources publishes the vfs allocated to a container through DRA resource claims under
+// sriov_kubepoddevice, with the driver and pool of the claim as dev_type, and with the claim under
+// sriov_kubepoddevice_dra. Devices whose PCI address can not be resolved from their names are not vfs and are skipped.
+func (c podDevLinkCollector) collectDynamicResources(ch chan<- prometheus.Metric, deviceDesc *prometheus.Desc,
+	dynamicResources []*v1.DynamicResource, pod *corev1.Pod, networks map[string]kube.DeviceNetwork,
+	podName, podNamespace, contName string) {
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "", c.name+"_dra"),
+		c.name+"_dra",
+		c.enrichment.deviceLabelNames(
+			[]string{labelPCIAddr, "driver", "pool", "device", "claim", "claim_namespace", "pod", "namespace", "container"}),
+		nil,
+	)
+
+	for _, dynamicRes := range dynamicResources {
+		for _, claimRes := range dynamicRes.GetClaimResources() {
+			addr := claimPCIAddr(claimRes)
+			if addr == "" {
+				slog.Debug("skipping dra device without a PCI address", labelCollector, c.name,
+					"driver", claimRes.GetDriverName(), "device", claimRes.GetDeviceName())
+				continue
+			}
+
+			devType := claimRes.GetDriverName() + "/" + claimRes.GetPoolName()
+			ch <- prometheus.MustNewConstMetric(
+				deviceDesc,
+				prometheus.CounterValue,
+				1,
+				c.enrichment.deviceLabelValues([]string{addr, devType, podName, podNamespace, contName}, pod, networks, addr)...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				desc,
+				prometheus.CounterValue,
+				1,
+				c.enrichment.deviceLabelValues([]string{
+					addr,
+					claimRes.GetDriverName(),
+					claimRes.GetPoolName(),
+					claimRes.GetDeviceName(),
+					dynamicRes.GetClaimName(),
+					dynamicRes.GetClaimNamespace(),
+					podName,
+					podNamespace,
+					contName,
+				}, pod, networks, addr)...,
+			)
 		}
 	}
 }
@@ -87,7 +157,8 @@ func (c podDevLinkCollector) Describe(ch chan<- *prometheus.Desc) {
 
 func createPodDevLinkCollector() prometheus.Collector {
 	return podDevLinkCollector{
-		name: podDevLinkName,
+		enrichment: podEnrichment,
+		name:       podDevLinkName,
 	}
 }
 
@@ -95,32 +166,26 @@ func createPodDevLinkCollector() prometheus.Collector {
 // and the pods they are attached to.
 // We create and close a new connection here on each run. The performance impact of this
 // seems marginal - but sharing a connection might save cpu time
-func PodResources() []*v1.PodResources {
-	var podResource []*v1.PodResources
-
+func PodResources() ([]*v1.PodResources, error) {
 	kubeletSocket := "unix:///" + *podResourcesPath
 	client, conn, err := GetV1Client(kubeletSocket, kubeletConnTimeout, defaultPodResourcesMaxSize)
 	if err != nil {
-		log.Print(err)
-		return podResource
+		return nil, err
 	}
 
 	defer func() {
 		if err := conn.Close(); err != nil {
-			log.Printf("failed to close connection: %v", err)
+			slog.Warn("failed to close connection", "err", err)
 		}
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), kubeletConnTimeout)
 	defer cancel()
 	resp, err := client.List(ctx, &v1.ListPodResourcesRequest{})
 	if err != nil {
-		log.Printf("getPodResources: failed to list pod resources, %v.Get(_) = _, %v", client, err)
-		return podResource
+		return nil, fmt.Errorf("getPodResources: failed to list pod resources, %v.Get(_) = _, %v", client, err)
 	}
 
-	podResource = resp.PodResources
-
-	return podResource
+	return resp.PodResources, nil
 }
 
 // Checks to see if a device id matches a pci address. If not we're able to discard it.
@@ -128,14 +193,60 @@ func isPci(id string) bool {
 	return pciAddressPattern.MatchString(id)
 }
 
-func resolveKubePodDeviceFilepaths() error {
-	if err := utils.ResolveFlag("path.kubeletsocket", podResourcesPath); err != nil {
+// claimPCIAddr returns the PCI address of a device allocated through DRA, taken from its device name or else from
+// its CDI device names, or an empty string if none of them holds one
+func claimPCIAddr(claimRes *v1.ClaimResource) string {
+	names := []string{claimRes.GetDeviceName()}
+	for _, cdiDevice := range claimRes.GetCdiDevices() {
+		names = append(names, cdiDevice.GetName())
+	}
+
+	for _, name := range names {
+		if m := draPCIAddressPattern.FindStringSubmatch(name); m != nil {
+			return strings.ToLower(fmt.Sprintf("%s:%s:%s.%s", m[1], m[2], m[3], m[4]))
+		}
+	}
+
+	return ""
+}
+
+// resolveKubePodDeviceFilepaths resolves the directories of the kubelet socket and, if a collector reads the pod
+// resources, of the device manager checkpoint. The files are not resolved, they do not exist while the kubelet is down.
+func resolveKubePodDeviceFilepaths(apply bool) error {
+	socket, err := resolveDir("path.kubeletsocket", *podResourcesPath)
+	if err != nil {
 		return err
 	}
 
+	checkpoint := *deviceCheckpointFile
+	if readsPodResources() {
+		if checkpoint, err = resolveDir("path.devicecheckpoint", checkpoint); err != nil {
+			return err
+		}
+	}
+
+	if apply {
+		*podResourcesPath, *deviceCheckpointFile = socket, checkpoint
+	}
+
 	return nil
 }
 
+// resolveDir resolves the directory of a file path flag and returns the path of the file in the resolved directory
+func resolveDir(flag, path string) (string, error) {
+	dir := filepath.Dir(path)
+	if err := utils.ResolveFlag(flag, &dir); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// readsPodResources returns true if an enabled collector reads the pod resources, falling back to the checkpoint
+func readsPodResources() bool {
+	return *collectorState[podDevLinkName] || *collectorState[vfAllocationName] || *collectorState[podTrafficName]
+}
+
 // GetV1Client returns a client for the PodResourcesLister grpc service
 // Extracted from package k8s.io/kubernetes/pkg/kubelet/apis/podresources client.go v1.24.3
 // This is what is recommended for consumers of this package
@@ -160,7 +271,7 @@ func GetV1Client(socket string, connectionTimeout time.Duration, maxMsgSize int)
 		}
 		if !conn.WaitForStateChange(ctx, s) {
 			if err := conn.Close(); err != nil {
-				log.Printf("failed to close connection: %v", err)
+				slog.Warn("failed to close connection", "err", err)
 			}
 			return nil, nil, fmt.Errorf("error connecting to socket %s: timed out waiting for connection", socket)
 		}
@@ -9,7 +9,17 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakekubelet"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
 )
 
 var _ = Describe("test creating podDevLink collector", func() { // createPodDevLinkCollector
@@ -19,6 +29,172 @@ var _ = Describe("test creating podDevLink collector", func() { // createPodDevL
 	})
 })
 
+var _ = Describe("test podDevLink collection", func() { // Collect
+	var kubelet *fakekubelet.Server
+
+	BeforeEach(func() {
+		socketPath := filepath.Join(GinkgoT().TempDir(), "kubelet.sock")
+		kubelet = fakekubelet.NewServer(&fakekubelet.Scenario{})
+		Expect(kubelet.Start(socketPath)).To(Succeed())
+		DeferCleanup(kubelet.Stop)
+
+		podResourcesPathOrig := *podResourcesPath
+		*podResourcesPath = socketPath
+		DeferCleanup(func() { *podResourcesPath = podResourcesPathOrig })
+
+		deviceCheckpointFileOrig := *deviceCheckpointFile
+		*deviceCheckpointFile = filepath.Join(GinkgoT().TempDir(), "kubelet_internal_checkpoint")
+		DeferCleanup(func() { *deviceCheckpointFile = deviceCheckpointFileOrig })
+	})
+
+	It("publishes the pci devices of each container", func() {
+		scenario, err := fakekubelet.ParseScenario([]byte(`
+pods:
+  - name: pod1
+    namespace: default
+    containers:
+      - name: app
+        devices:
+          - resourceName: intel.com/sriov
+            deviceIds: ["0000:3b:02.0", "not-a-pci-address"]
+      - name: sidecar
+        devices:
+          - resourceName: intel.com/sriov
+            deviceIds: ["0000:3b:02.1"]
+`))
+		Expect(err).ToNot(HaveOccurred())
+		kubelet.SetScenario(scenario)
+
+		metrics := gatherMetrics(createPodDevLinkCollector())
+
+		Expect(metrics).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"sidecar" name:"dev_type" value:"intel.com/sriov" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"pod1"] 1`,
+			`sriov_pod_resources_source_info[name:"source" value:"podresources"] 1`,
+		}))
+		Expect(kubelet.Calls(fakekubelet.MethodList)).To(Equal(1))
+	})
+
+	It("publishes the vfs allocated through resource claims", func() {
+		scenario, err := fakekubelet.ParseScenario([]byte(`
+pods:
+  - name: pod1
+    namespace: default
+    containers:
+      - name: app
+        dynamicResources:
+          - claimName: vf-claim
+            claimNamespace: default
+            claimResources:
+              - driverName: sriovnetwork.k8snetworkplumbingwg.io
+                poolName: node1
+                deviceName: pci-0000-3b-02-0
+              - driverName: example.com
+                poolName: node1
+                deviceName: vf1
+                cdiDevices:
+                  - name: example.com/vf=0000:3B:02.1
+              - driverName: example.com
+                poolName: node1
+                deviceName: vf2
+`))
+		Expect(err).ToNot(HaveOccurred())
+		kubelet.SetScenario(scenario)
+
+		Expect(gatherMetrics(createPodDevLinkCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"example.com/node1" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"sriovnetwork.k8snetworkplumbingwg.io/node1" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepoddevice_dra[name:"claim" value:"vf-claim" name:"claim_namespace" value:"default" name:"container" value:"app" ` +
+				`name:"device" value:"pci-0000-3b-02-0" name:"driver" value:"sriovnetwork.k8snetworkplumbingwg.io" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"pod1" name:"pool" value:"node1"] 1`,
+			`sriov_kubepoddevice_dra[name:"claim" value:"vf-claim" name:"claim_namespace" value:"default" name:"container" value:"app" ` +
+				`name:"device" value:"vf1" name:"driver" value:"example.com" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"pod1" name:"pool" value:"node1"] 1`,
+			`sriov_pod_resources_source_info[name:"source" value:"podresources"] 1`,
+		}))
+	})
+
+	It("adds the interface and network of each device from the network-status annotation", func() {
+		scenario, err := fakekubelet.ParseScenario([]byte(`
+pods:
+  - name: pod1
+    namespace: default
+    containers:
+      - name: app
+        devices:
+          - resourceName: intel.com/sriov
+            deviceIds: ["0000:3b:02.0", "0000:3b:02.1"]
+  - name: pod2
+    namespace: default
+    containers:
+      - name: app
+        devices:
+          - resourceName: intel.com/sriov
+            deviceIds: ["0000:3b:02.2"]
+`))
+		Expect(err).ToNot(HaveOccurred())
+		kubelet.SetScenario(scenario)
+
+		client := fake.NewClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod1",
+			Namespace: "default",
+			Annotations: map[string]string{kube.NetworkStatusAnnotation: `[
+				{"name": "default/sriov-a", "interface": "net1", "device-info": {"type": "pci", "pci": {"pci-address": "0000:3b:02.0"}}},
+				{"name": "default/sriov-b", "interface": "net2", "device-info": {"type": "pci", "pci": {"pci-address": "0000:3b:02.1"}}}
+			]`},
+		}})
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		pods, err := kube.WatchPods(ctx, client, "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		SetPodEnrichment(PodEnrichment{Pods: pods, Networks: true})
+		DeferCleanup(SetPodEnrichment, PodEnrichment{})
+
+		Expect(gatherMetrics(createPodDevLinkCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"interface" value:"" ` +
+				`name:"namespace" value:"default" name:"network" value:"" name:"pciAddr" value:"0000:3b:02.2" name:"pod" value:"pod2"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"interface" value:"net1" ` +
+				`name:"namespace" value:"default" name:"network" value:"default/sriov-a" name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"interface" value:"net2" ` +
+				`name:"namespace" value:"default" name:"network" value:"default/sriov-b" name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"pod1"] 1`,
+			`sriov_pod_resources_source_info[name:"source" value:"podresources"] 1`,
+		}))
+	})
+
+	It("reports a failure when pod resources can not be listed", func() {
+		kubelet.SetScenario(&fakekubelet.Scenario{Errors: map[string]string{fakekubelet.MethodList: "kubelet restarting"}})
+
+		ch := make(chan prometheus.Metric, 1)
+		createPodDevLinkCollector().Collect(ch)
+
+		m := dto.Metric{}
+		Expect((<-ch).Write(&m)).To(Succeed())
+		Expect(m.GetLabel()).To(HaveLen(1))
+		Expect(m.GetLabel()[0].GetValue()).To(Equal(podDevLinkName))
+		Expect(m.GetGauge().GetValue()).To(Equal(0.0))
+		Expect(&buffer).To(gbytes.Say("failed to list pod resources.*kubelet restarting"))
+	})
+})
+
+var _ = DescribeTable("test resolving the pci address of a claimed device", // claimPCIAddr
+	func(claimRes *v1.ClaimResource, expected string) {
+		Expect(claimPCIAddr(claimRes)).To(Equal(expected))
+	},
+	Entry("pci address device name", &v1.ClaimResource{DeviceName: "0000-3b-02-0"}, "0000:3b:02.0"),
+	Entry("prefixed device name", &v1.ClaimResource{DeviceName: "pci-0000-af-0a-7"}, "0000:af:0a.7"),
+	Entry("cdi device name",
+		&v1.ClaimResource{DeviceName: "vf0", CdiDevices: []*v1.CDIDevice{{Name: "example.com/vf=0000:3B:02.1"}}}, "0000:3b:02.1"),
+	Entry("no pci address",
+		&v1.ClaimResource{DeviceName: "vf0", CdiDevices: []*v1.CDIDevice{{Name: "example.com/vf=vf0"}}}, ""),
+)
+
 var _ = DescribeTable("test pci address regexp: "+pciAddressPattern.String(), // isPci
 	func(pciAddr string, expected bool) {
 		Expect(isPci(pciAddr)).To(Equal(expected))
@@ -0,0 +1,89 @@
+package collectors
+
+// pod_enrichment adds metadata of the pods watched through the Kubernetes API to the pod metrics
+
+import (
+	"log/slog"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+// podEnrichment is the enrichment of the pod metrics, empty unless set with SetPodEnrichment
+var podEnrichment PodEnrichment
+
+// PodEnrichment selects the metadata of the watched pods added to the kubepoddevice and kubepodcpu metrics
+type PodEnrichment struct {
+	Pods *kube.Pods
+	// Networks adds the interface and network of each device from the Multus network-status annotation
+	Networks bool
+	Metadata kube.PodMetadata
+}
+
+// SetPodEnrichment sets the pod metadata added to the pod metrics. It must be called before the collectors are created.
+func SetPodEnrichment(enrichment PodEnrichment) {
+	podEnrichment = enrichment
+}
+
+// pod returns the watched pod with the given namespace and name, or nil if the pods are not watched
+func (e PodEnrichment) pod(namespace, name string) *corev1.Pod {
+	if e.Pods == nil {
+		return nil
+	}
+
+	return e.Pods.Get(namespace, name)
+}
+
+// podByUID returns the watched pod with the given UID, or nil if the pods are not watched
+func (e PodEnrichment) podByUID(uid string) *corev1.Pod {
+	if e.Pods == nil {
+		return nil
+	}
+
+	return e.Pods.GetByUID(uid)
+}
+
+// deviceNetworks returns the networks of the devices of a pod from its network-status annotation, or nil if
+// networks are not enabled or the pod has no annotation
+func (e PodEnrichment) deviceNetworks(pod *corev1.Pod, collector string) map[string]kube.DeviceNetwork {
+	if !e.Networks || pod == nil {
+		return nil
+	}
+
+	networks, err := kube.DeviceNetworks(pod)
+	if err != nil {
+		slog.Warn("invalid network-status annotation", labelCollector, collector, "pod", pod.Namespace+"/"+pod.Name, "err", err)
+	}
+
+	return networks
+}
+
+// deviceLabelNames adds the names of the network and metadata labels of a device metric
+func (e PodEnrichment) deviceLabelNames(names []string) []string {
+	if e.Networks {
+		names = append(names, "interface", "network")
+	}
+
+	return e.podLabelNames(names)
+}
+
+// deviceLabelValues adds the network of the device at addr and the metadata of its pod, empty if unknown
+func (e PodEnrichment) deviceLabelValues(values []string, pod *corev1.Pod, networks map[string]kube.DeviceNetwork, addr string) []string {
+	if e.Networks {
+		network := networks[addr]
+		values = append(values, network.Interface, network.Network)
+	}
+
+	return e.podLabelValues(values, pod)
+}
+
+// podLabelNames adds the names of the metadata labels of a pod metric
+func (e PodEnrichment) podLabelNames(names []string) []string {
+	return append(names, e.Metadata.LabelNames()...)
+}
+
+// podLabelValues adds the metadata of the pod, empty if unknown
+func (e PodEnrichment) podLabelValues(values []string, pod *corev1.Pod) []string {
+	return append(values, e.Metadata.Values(pod)...)
+}
@@ -0,0 +1,96 @@
+package collectors
+
+import (
+	"context"
+	"io/fs"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+var _ = Describe("test enriching pod metrics with pod metadata", func() { // PodEnrichment
+	BeforeEach(func() {
+		client := fake.NewClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "upf-5d9f8c7b6-x2x7q",
+			Namespace:   "default",
+			UID:         "6b5b533a-6307-48d1-911f-07bf5d4e1c82",
+			Labels:      map[string]string{"app": "upf", "pod-template-hash": "5d9f8c7b6"},
+			Annotations: map[string]string{"example.com/tier": "data-plane"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "upf-5d9f8c7b6", Controller: ptr.To(true)},
+			},
+		}})
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		pods, err := kube.WatchPods(ctx, client, "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		SetPodEnrichment(PodEnrichment{
+			Pods:     pods,
+			Metadata: kube.PodMetadata{Labels: []string{"app"}, Annotations: []string{"example.com/tier"}, Owner: true},
+		})
+		DeferCleanup(SetPodEnrichment, PodEnrichment{})
+
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return []*v1.PodResources{
+				{Name: "upf-5d9f8c7b6-x2x7q", Namespace: "default", Containers: []*v1.ContainerResources{{
+					Name:    "app",
+					Devices: []*v1.ContainerDevices{{ResourceName: "intel.com/sriov", DeviceIds: []string{"0000:3b:02.0"}}},
+					CpuIds:  []int64{2},
+				}}},
+				{Name: "unknown", Namespace: "default", Containers: []*v1.ContainerResources{{
+					Name:    "app",
+					Devices: []*v1.ContainerDevices{{ResourceName: "intel.com/sriov", DeviceIds: []string{"0000:3b:02.1"}}},
+				}}},
+			}, nil
+		}
+		DeferCleanup(func() { listPodResources = PodResources })
+		cpuinfofs = fstest.MapFS{"node0/cpu2": {Mode: fs.ModeDir}}
+	})
+
+	It("adds the selected metadata to the pod devices", func() {
+		Expect(gatherMetrics(createPodDevLinkCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 1`,
+			`sriov_kubepoddevice[name:"annotation_example_com_tier" value:"" name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" ` +
+				`name:"label_app" value:"" name:"namespace" value:"default" name:"owner_kind" value:"" name:"owner_name" value:"" ` +
+				`name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"unknown"] 1`,
+			`sriov_kubepoddevice[name:"annotation_example_com_tier" value:"data-plane" name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" ` +
+				`name:"label_app" value:"upf" name:"namespace" value:"default" name:"owner_kind" value:"Deployment" name:"owner_name" value:"upf" ` +
+				`name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"upf-5d9f8c7b6-x2x7q"] 1`,
+			`sriov_pod_resources_source_info[name:"source" value:"podresources"] 1`,
+		}))
+	})
+
+	It("adds the selected metadata to the pod cpus from pod resources", func() {
+		*kubePodCPUSource = cpuSourcePodResources
+		DeferCleanup(func() { *kubePodCPUSource = cpuSourceCgroup })
+
+		Expect(gatherMetrics(createKubepodCPUCollector())).To(ContainElement(
+			`sriov_kubepodcpu[name:"annotation_example_com_tier" value:"data-plane" name:"container" value:"app" name:"cpu_id" value:"2" ` +
+				`name:"label_app" value:"upf" name:"namespace" value:"default" name:"numa_node" value:"0" name:"owner_kind" value:"Deployment" ` +
+				`name:"owner_name" value:"upf" name:"pod" value:"upf-5d9f8c7b6-x2x7q"] 1`))
+	})
+
+	It("finds the pods of the cgroup pod cpus by UID", func() {
+		fsys := fstest.MapFS{
+			"node0/cpu2":        {Mode: fs.ModeDir},
+			"cpuset.cpus":       {Data: []byte("0-1")},
+			"cpu_manager_state": {Data: []byte("{\"policyName\":\"static\",\"defaultCpuSet\":\"0-1\",\"checksum\":1353318690}")},
+			"kubepods-pod6b5b533a_6307_48d1_911f_07bf5d4e1c82.slice/0123456789abcdefaaaa/cpuset.cpus": {Data: []byte("2")}}
+		cpuinfofs, kubecgroupfs, cpucheckpointfs = fsys, fsys, fsys
+
+		Expect(gatherMetrics(createKubepodCPUCollector())).To(ContainElement(
+			`sriov_kubepodcpu[name:"annotation_example_com_tier" value:"data-plane" name:"container_id" value:"0123456789abcdefaaaa" ` +
+				`name:"cpu_id" value:"2" name:"label_app" value:"upf" name:"numa_node" value:"0" name:"owner_kind" value:"Deployment" ` +
+				`name:"owner_name" value:"upf" name:"uid" value:"6b5b533a_6307_48d1_911f_07bf5d4e1c82"] 1`))
+	})
+})
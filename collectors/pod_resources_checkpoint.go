@@ -0,0 +1,145 @@
+package collectors
+
+// pod_resources_checkpoint reads the device allocations of the pods from the kubelet device manager checkpoint,
+// as a fallback when the kubelet pod resources socket is not available
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	// Sources of the pod resources of the kubepoddevice collector
+	podResourcesSourceAPI        = "podresources"
+	podResourcesSourceCheckpoint = "checkpoint"
+)
+
+var (
+	deviceCheckpointFile = flag.String("path.devicecheckpoint",
+		"/var/lib/kubelet/device-plugins/kubelet_internal_checkpoint", "Path to kubelet device manager checkpoint file")
+
+	podResourcesSourceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "pod_resources", "source_info"),
+		"Source of the pod resources of the last collection, the kubelet pod resources api or the device manager checkpoint",
+		[]string{"source"}, nil,
+	)
+)
+
+// deviceCheckpoint is the structure needed to extract the device allocations from the kubelet device manager checkpoint
+type deviceCheckpoint struct {
+	Data struct {
+		PodDeviceEntries []deviceCheckpointEntry
+	}
+}
+
+// deviceCheckpointEntry is the allocation of the devices of a resource to a container. DeviceIDs holds the device
+// ids by NUMA node in current kubelets and a plain list in kubelets before 1.20.
+type deviceCheckpointEntry struct {
+	PodUID        string
+	ContainerName string
+	ResourceName  string
+	DeviceIDs     json.RawMessage
+}
+
+// deviceIDs returns the device ids of the entry in either checkpoint format
+func (e deviceCheckpointEntry) deviceIDs() ([]string, error) {
+	var ids []string
+	if err := json.Unmarshal(e.DeviceIDs, &ids); err == nil {
+		return ids, nil
+	}
+
+	byNUMANode := map[string][]string{}
+	if err := json.Unmarshal(e.DeviceIDs, &byNUMANode); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]string, 0, len(byNUMANode))
+	for node := range byNUMANode {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+
+	for _, node := range nodes {
+		ids = append(ids, byNUMANode[node]...)
+	}
+
+	return ids, nil
+}
+
+// podResourcesWithFallback lists the pod resources from the kubelet pod resources api, or from the device manager
+// checkpoint if the api is not available, and returns the source they were read from
+func podResourcesWithFallback(enrichment PodEnrichment) ([]*v1.PodResources, string, error) {
+	resources, err := listPodResources()
+	if err == nil {
+		return resources, podResourcesSourceAPI, nil
+	}
+
+	slog.Debug("pod resources api not available, reading the device manager checkpoint", "err", err)
+	resources, checkpointErr := checkpointPodResources(enrichment)
+	if checkpointErr != nil {
+		return nil, "", fmt.Errorf("%v\n%v", err, checkpointErr)
+	}
+
+	return resources, podResourcesSourceCheckpoint, nil
+}
+
+// checkpointPodResources reconstructs the device allocations of the pods from the device manager checkpoint.
+// The checkpoint only holds the pod UIDs, the pod name and namespace are taken from the watched pods and the UID is
+// used as name of the pods that are not watched.
+func checkpointPodResources(enrichment PodEnrichment) ([]*v1.PodResources, error) {
+	data, err := os.ReadFile(*deviceCheckpointFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read device checkpoint file '%s'\n%v", *deviceCheckpointFile, err)
+	}
+
+	checkpoint := deviceCheckpoint{}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("device checkpoint file could not be unmarshalled\n%v", err)
+	}
+
+	pods := make(map[string]*v1.PodResources)
+	containers := make(map[string]*v1.ContainerResources)
+	var uids []string
+
+	for _, entry := range checkpoint.Data.PodDeviceEntries {
+		ids, err := entry.deviceIDs()
+		if err != nil {
+			slog.Warn("invalid device ids in device checkpoint", "pod_uid", entry.PodUID, "resource", entry.ResourceName, "err", err)
+			continue
+		}
+
+		podRes, ok := pods[entry.PodUID]
+		if !ok {
+			podRes = &v1.PodResources{Name: entry.PodUID}
+			if pod := enrichment.podByUID(entry.PodUID); pod != nil {
+				podRes.Name, podRes.Namespace = pod.Name, pod.Namespace
+			}
+			pods[entry.PodUID] = podRes
+			uids = append(uids, entry.PodUID)
+		}
+
+		key := entry.PodUID + "/" + entry.ContainerName
+		contRes, ok := containers[key]
+		if !ok {
+			contRes = &v1.ContainerResources{Name: entry.ContainerName}
+			containers[key] = contRes
+			podRes.Containers = append(podRes.Containers, contRes)
+		}
+
+		contRes.Devices = append(contRes.Devices, &v1.ContainerDevices{ResourceName: entry.ResourceName, DeviceIds: ids})
+	}
+
+	resources := make([]*v1.PodResources, 0, len(uids))
+	for _, uid := range uids {
+		resources = append(resources, pods[uid])
+	}
+
+	return resources, nil
+}
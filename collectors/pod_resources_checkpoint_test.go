@@ -0,0 +1,90 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+var _ = Describe("test reading pod resources from the device manager checkpoint", func() { // podResourcesWithFallback
+	BeforeEach(func() {
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return nil, errors.New("pod resources socket not available")
+		}
+		DeferCleanup(func() { listPodResources = PodResources })
+
+		deviceCheckpointFileOrig := *deviceCheckpointFile
+		*deviceCheckpointFile = filepath.Join(GinkgoT().TempDir(), "kubelet_internal_checkpoint")
+		DeferCleanup(func() { *deviceCheckpointFile = deviceCheckpointFileOrig })
+	})
+
+	It("publishes the devices of the checkpoint and its source", func() {
+		client := fake.NewClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod1",
+			Namespace: "default",
+			UID:       "6b5b533a-6307-48d1-911f-07bf5d4e1c82",
+		}})
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		pods, err := kube.WatchPods(ctx, client, "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		SetPodEnrichment(PodEnrichment{Pods: pods})
+		DeferCleanup(SetPodEnrichment, PodEnrichment{})
+
+		Expect(os.WriteFile(*deviceCheckpointFile, []byte(`{"Data": {
+			"PodDeviceEntries": [
+				{"PodUID": "6b5b533a-6307-48d1-911f-07bf5d4e1c82", "ContainerName": "app", "ResourceName": "intel.com/sriov",
+				 "DeviceIDs": {"0": ["0000:3b:02.0"], "1": ["0000:af:02.0"]}, "AllocResp": "CgA="},
+				{"PodUID": "0f1c2d3e-0000-4000-8000-000000000000", "ContainerName": "app", "ResourceName": "intel.com/sriov",
+				 "DeviceIDs": {"-1": ["0000:3b:02.1"]}, "AllocResp": "CgA="},
+				{"PodUID": "0f1c2d3e-0000-4000-8000-000000000000", "ContainerName": "app", "ResourceName": "example.com/gpu",
+				 "DeviceIDs": {"-1": ["gpu-0"]}, "AllocResp": "CgA="}
+			],
+			"RegisteredDevices": {"intel.com/sriov": ["0000:3b:02.0", "0000:3b:02.1", "0000:af:02.0"]}
+		}, "Checksum": 1234}`), 0o600)).To(Succeed())
+
+		Expect(gatherMetrics(createPodDevLinkCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"namespace" value:"" ` +
+				`name:"pciAddr" value:"0000:3b:02.1" name:"pod" value:"0f1c2d3e-0000-4000-8000-000000000000"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:3b:02.0" name:"pod" value:"pod1"] 1`,
+			`sriov_kubepoddevice[name:"container" value:"app" name:"dev_type" value:"intel.com/sriov" name:"namespace" value:"default" ` +
+				`name:"pciAddr" value:"0000:af:02.0" name:"pod" value:"pod1"] 1`,
+			`sriov_pod_resources_source_info[name:"source" value:"checkpoint"] 1`,
+		}))
+	})
+
+	It("reads the device ids of checkpoints written by kubelets before 1.20", func() {
+		Expect(os.WriteFile(*deviceCheckpointFile, []byte(`{"Data": {"PodDeviceEntries": [
+			{"PodUID": "6b5b533a-6307-48d1-911f-07bf5d4e1c82", "ContainerName": "app", "ResourceName": "intel.com/sriov",
+			 "DeviceIDs": ["0000:3b:02.0"], "AllocResp": "CgA="}
+		]}}`), 0o600)).To(Succeed())
+
+		resources, source, err := podResourcesWithFallback(PodEnrichment{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(podResourcesSourceCheckpoint))
+		Expect(resources).To(HaveLen(1))
+		Expect(resources[0].GetName()).To(Equal("6b5b533a-6307-48d1-911f-07bf5d4e1c82"))
+		Expect(resources[0].GetContainers()[0].GetDevices()[0].GetDeviceIds()).To(Equal([]string{"0000:3b:02.0"}))
+	})
+
+	It("reports a failure when neither source can be read", func() {
+		Expect(gatherMetrics(createPodDevLinkCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"kubepoddevice"] 0`,
+		}))
+		Expect(&buffer).To(gbytes.Say("pod resources not available.*socket not available\ncould not read device checkpoint file"))
+	})
+})
@@ -0,0 +1,271 @@
+package collectors
+
+// pod_traffic accounts the traffic of the vfs to the pods holding them. The vf counters are cumulative across pods,
+// so the traffic between two scrapes is added to per pod and per namespace counters of the pod holding the vf.
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const podTrafficName = "podtraffic"
+
+// podTraffic is the traffic accounted by the pod traffic collectors, the sampler of the custom metrics API has its own
+var podTraffic = newTrafficTracker()
+
+// podKey identifies a pod by namespace and name
+type podKey struct {
+	namespace string
+	pod       string
+}
+
+// vfTraffic is the holder of a vf at the last scrape, as published and as read from the source, and the stats read then
+type vfTraffic struct {
+	holder    podKey
+	seen      allocationHolder
+	allocated bool
+	stats     sriovStats
+}
+
+// trafficTracker holds the traffic accounted to each pod and namespace since the exporter started
+type trafficTracker struct {
+	mu         sync.Mutex
+	source     string
+	vfs        map[string]vfTraffic
+	pods       map[podKey]sriovStats
+	namespaces map[string]sriovStats
+}
+
+func newTrafficTracker() *trafficTracker {
+	return &trafficTracker{
+		vfs:        make(map[string]vfTraffic),
+		pods:       make(map[podKey]sriovStats),
+		namespaces: make(map[string]sriovStats),
+	}
+}
+
+// observe adds the traffic of each vf since the last observation to the pod holding it then, so the traffic of a vf
+// released or reassigned between two scrapes goes to its previous holder. It returns the totals of the pods and
+// namespaces. A pod holding no vf is returned one last time and then forgotten, the namespace totals are kept.
+// The holders change as told by changeOfHolder. A vf without stats keeps the stats of its last observation.
+func (t *trafficTracker) observe(stats map[string]sriovStats, holders map[string]allocationHolder, source string) (map[podKey]sriovStats, map[string]sriovStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	active := make(map[podKey]bool)
+	for addr, current := range stats {
+		previous, ok := t.vfs[addr]
+		if len(current) == 0 {
+			if ok && previous.allocated {
+				active[previous.holder] = true
+			}
+			continue
+		}
+
+		if ok && previous.allocated {
+			delta := counterDelta(previous.stats, current)
+			addStats(t.pods, previous.holder, delta)
+			addStats(t.namespaces, previous.holder.namespace, delta)
+		}
+
+		holder, allocated := holders[addr]
+		wasAllocated := ok && previous.allocated
+		next := vfTraffic{holder: podKey{holder.namespace, holder.pod}, seen: holder, allocated: allocated, stats: current}
+		if wasAllocated && changeOfHolder(previous.seen, wasAllocated, holder, allocated, source, t.source != source) == holderKept {
+			next.holder, next.allocated = previous.holder, true
+			if !allocated {
+				next.seen = previous.seen
+			}
+		}
+
+		if next.allocated {
+			// The holder is published with zero traffic from its first observation
+			zero := counterDelta(current, current)
+			active[next.holder] = true
+			addStats(t.pods, next.holder, zero)
+			addStats(t.namespaces, next.holder.namespace, zero)
+		}
+		t.vfs[addr] = next
+	}
+	t.source = source
+
+	for addr := range t.vfs {
+		if _, ok := stats[addr]; !ok {
+			delete(t.vfs, addr)
+		}
+	}
+
+	pods := make(map[podKey]sriovStats, len(t.pods))
+	for key, totals := range t.pods {
+		pods[key] = copyStats(totals)
+		if !active[key] {
+			delete(t.pods, key)
+		}
+	}
+
+	namespaces := make(map[string]sriovStats, len(t.namespaces))
+	for namespace, totals := range t.namespaces {
+		namespaces[namespace] = copyStats(totals)
+	}
+
+	return pods, namespaces
+}
+
+// counterDelta returns the increase of each stat. A stat lower than before was reset, e.g. by the vf being
+// re-created, and increased by its current value. A stat not read before has no increase.
+func counterDelta(before, after sriovStats) sriovStats {
+	delta := make(sriovStats, len(after))
+	for name, value := range after {
+		previous, ok := before[name]
+		switch {
+		case !ok:
+			delta[name] = 0
+		case value < previous:
+			delta[name] = value
+		default:
+			delta[name] = value - previous
+		}
+	}
+
+	return delta
+}
+
+// addStats adds the stats to the totals of key
+func addStats[K comparable](totals map[K]sriovStats, key K, stats sriovStats) {
+	if totals[key] == nil {
+		totals[key] = make(sriovStats, len(stats))
+	}
+
+	for name, value := range stats {
+		totals[key][name] += value
+	}
+}
+
+func copyStats(stats sriovStats) sriovStats {
+	copied := make(sriovStats, len(stats))
+	for name, value := range stats {
+		copied[name] = value
+	}
+
+	return copied
+}
+
+// podTrafficCollector publishes the vf traffic of each pod and namespace
+type podTrafficCollector struct {
+	devs       sriovDevCollector
+	enrichment PodEnrichment
+	name       string
+}
+
+// init runs the registration for this collector on package import
+func init() {
+	register(podTrafficName, disabled, createPodTrafficCollector, sriovDevFlags...)
+}
+
+// Collect reads the vf stats and the pod resources and publishes the traffic accounted to each pod holding a vf
+// under sriov_pod_vf_<stat>_total, and to each namespace under sriov_namespace_vf_<stat>_total. Nothing is
+// accounted while the pod resources can not be read, the traffic is then added to the holders seen before.
+func (c podTrafficCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectScrape(ch, &scrape{})
+}
+
+// collectScrape publishes the traffic of the pods and namespaces with the vf stats read for the scrape
+func (c podTrafficCollector) collectScrape(ch chan<- prometheus.Metric, s *scrape) {
+	resources, source, err := podResourcesWithFallback(c.enrichment)
+	if err != nil {
+		slog.Warn("pod resources not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
+		return
+	}
+
+	pods, namespaces := podTraffic.observe(canonicalVFStats(s.vfStats(c.devs)), allocationHolders(resources, c.enrichment), source)
+
+	for key, totals := range pods {
+		pod := c.enrichment.pod(key.namespace, key.pod)
+		for name, value := range totals {
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(collectorNamespace, "pod_vf", name+"_total"),
+				"Statistic "+name+" of the vfs held by the pod since the exporter started.",
+				c.enrichment.podLabelNames([]string{"pod", "namespace"}), nil,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value),
+				c.enrichment.podLabelValues([]string{key.pod, key.namespace}, pod)...)
+		}
+	}
+
+	for namespace, totals := range namespaces {
+		for name, value := range totals {
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(collectorNamespace, "namespace_vf", name+"_total"),
+				"Statistic "+name+" of the vfs held by the pods of the namespace since the exporter started.",
+				[]string{"namespace"}, nil,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), namespace)
+		}
+	}
+
+	publishCollectorSuccess(ch, c.name, nil)
+}
+
+// Describe is not defined for this collector
+func (c podTrafficCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func createPodTrafficCollector() prometheus.Collector {
+	return podTrafficCollector{
+		devs:       createSriovDevCollector().(sriovDevCollector),
+		enrichment: podEnrichment,
+		name:       podTrafficName,
+	}
+}
+
+// PodTraffic is the vf traffic accounted to a pod since the first sample
+type PodTraffic struct {
+	Namespace string
+	Pod       string
+	Stats     map[string]int64
+}
+
+// PodTrafficSampler accounts the vf traffic to the pods outside of the scrapes, for the custom metrics API. It reads
+// the vf stats once per sample, and reads the flags and paths of the collectors, so it is sampled and rebuilt under
+// the same lock as the collectors.
+type PodTrafficSampler struct {
+	devs    sriovDevCollector
+	tracker *trafficTracker
+}
+
+// NewPodTrafficSampler returns a sampler of the vfs of the pfs on the host. It must be created after the collector
+// paths are resolved.
+func NewPodTrafficSampler() *PodTrafficSampler {
+	return &PodTrafficSampler{
+		devs:    createSriovDevCollector().(sriovDevCollector),
+		tracker: newTrafficTracker(),
+	}
+}
+
+// Rebuild creates the device collector of the sampler again if any of its flags are in changed, keeping the traffic
+// accounted so far
+func (s *PodTrafficSampler) Rebuild(changed map[string]bool) {
+	if flagsChanged(sriovDevFlags, changed) {
+		s.devs = createSriovDevCollector().(sriovDevCollector)
+	}
+}
+
+// Sample reads the vf stats and the pod resources and returns the traffic of each pod holding a vf
+func (s *PodTrafficSampler) Sample() ([]PodTraffic, error) {
+	resources, source, err := podResourcesWithFallback(podEnrichment)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, _ := s.tracker.observe(canonicalVFStats(s.devs.readStats()), allocationHolders(resources, podEnrichment), source)
+
+	traffic := make([]PodTraffic, 0, len(pods))
+	for key, totals := range pods {
+		traffic = append(traffic, PodTraffic{Namespace: key.namespace, Pod: key.pod, Stats: totals})
+	}
+
+	return traffic, nil
+}
@@ -0,0 +1,242 @@
+package collectors
+
+import (
+	"io/fs"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+// reset forgets all vfs, pods and namespaces
+func (t *trafficTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.source = ""
+	t.vfs = make(map[string]vfTraffic)
+	t.pods = make(map[podKey]sriovStats)
+	t.namespaces = make(map[string]sriovStats)
+}
+
+var _ = Describe("test accounting vf traffic to pods", func() { // trafficTracker.observe
+	var (
+		tracker *trafficTracker
+		podA    = allocationHolder{pod: "pod-a", namespace: "team-a", container: "app"}
+		podB    = allocationHolder{pod: "pod-b", namespace: "team-b", container: "app"}
+		keyA    = podKey{"team-a", "pod-a"}
+		keyB    = podKey{"team-b", "pod-b"}
+	)
+
+	BeforeEach(func() {
+		tracker = newTrafficTracker()
+	})
+
+	It("does not attribute the traffic of a vf to its next holder", func() {
+		pods, namespaces := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1000}},
+			map[string]allocationHolder{"0000:3b:02.0": podA}, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 0}}))
+		Expect(namespaces).To(Equal(map[string]sriovStats{"team-a": {"rx_bytes": 0}}))
+
+		pods, _ = tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1500}},
+			map[string]allocationHolder{"0000:3b:02.0": podA}, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 500}}))
+
+		By("reassigning the vf, the traffic since the last scrape goes to its previous holder")
+		pods, namespaces = tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1700}},
+			map[string]allocationHolder{"0000:3b:02.0": podB}, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 700}, keyB: {"rx_bytes": 0}}))
+		Expect(namespaces).To(Equal(map[string]sriovStats{"team-a": {"rx_bytes": 700}, "team-b": {"rx_bytes": 0}}))
+
+		By("forgetting the previous holder after publishing its last total")
+		pods, namespaces = tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 2000}},
+			map[string]allocationHolder{"0000:3b:02.0": podB}, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyB: {"rx_bytes": 300}}))
+		Expect(namespaces).To(Equal(map[string]sriovStats{"team-a": {"rx_bytes": 700}, "team-b": {"rx_bytes": 300}}))
+	})
+
+	It("sums the vfs of a pod and ignores unallocated vfs", func() {
+		stats := map[string]sriovStats{"0000:3b:02.0": {"tx_packets": 10}, "0000:3b:02.1": {"tx_packets": 20}, "0000:3b:02.2": {"tx_packets": 30}}
+		holders := map[string]allocationHolder{"0000:3b:02.0": podA, "0000:3b:02.1": podA}
+		tracker.observe(stats, holders, podResourcesSourceAPI)
+
+		pods, _ := tracker.observe(map[string]sriovStats{
+			"0000:3b:02.0": {"tx_packets": 15}, "0000:3b:02.1": {"tx_packets": 27}, "0000:3b:02.2": {"tx_packets": 90},
+		}, holders, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"tx_packets": 12}}))
+	})
+
+	It("counts a reset counter from zero", func() {
+		holders := map[string]allocationHolder{"0000:3b:02.0": podA}
+		tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1000}}, holders, podResourcesSourceAPI)
+
+		pods, _ := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 200}}, holders, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 200}}))
+	})
+
+	It("keeps the holder of a vf when the pod resources are read from the checkpoint", func() {
+		uid := "6b5b533a-6307-48d1-911f-07bf5d4e1c82"
+		byUID := allocationHolder{pod: uid, uid: uid, container: "app"}
+		tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1000}},
+			map[string]allocationHolder{"0000:3b:02.0": podA}, podResourcesSourceAPI)
+
+		for _, rxBytes := range []int64{1500, 1700} {
+			pods, _ := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": rxBytes}},
+				map[string]allocationHolder{"0000:3b:02.0": byUID}, podResourcesSourceCheckpoint)
+			Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": rxBytes - 1000}}))
+		}
+
+		pods, _ := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 2000}},
+			map[string]allocationHolder{"0000:3b:02.0": podA}, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 1000}}))
+	})
+
+	It("keeps the holder of a vf allocated through DRA while the checkpoint is read", func() {
+		claim := allocationHolder{pod: "pod-a", namespace: "team-a", container: "app", dynamic: true}
+		tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1000}},
+			map[string]allocationHolder{"0000:3b:02.0": claim}, podResourcesSourceAPI)
+
+		pods, _ := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1500}}, nil, podResourcesSourceCheckpoint)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 500}}))
+	})
+
+	It("keeps the stats of a vf that could not be read", func() {
+		holders := map[string]allocationHolder{"0000:3b:02.0": podA}
+		tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1000}}, holders, podResourcesSourceAPI)
+
+		pods, _ := tracker.observe(map[string]sriovStats{"0000:3b:02.0": {}}, holders, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 0}}))
+
+		pods, _ = tracker.observe(map[string]sriovStats{"0000:3b:02.0": {"rx_bytes": 1200}}, holders, podResourcesSourceAPI)
+		Expect(pods).To(Equal(map[podKey]sriovStats{keyA: {"rx_bytes": 200}}))
+	})
+})
+
+var _ = Describe("test pod traffic collection", func() { // podTrafficCollector.Collect
+	var vf netlink.VfInfo
+
+	BeforeEach(func() {
+		podTraffic.reset()
+		DeferCleanup(podTraffic.reset)
+
+		devfs = fstest.MapFS{
+			"0000:3b:00.0/sriov_totalvfs": {Data: []byte("64")},
+			"0000:3b:00.0/sriov_numvfs":   {Data: []byte("1")},
+			"0000:3b:00.0/class":          {Data: []byte("0x020000")},
+			"0000:3b:00.0/net/ens1f0":     {Mode: fs.ModeDir},
+			"0000:3b:00.0/virtfn0":        {Data: []byte("../0000:3b:02.0"), Mode: fs.ModeSymlink},
+		}
+		netfs = fstest.MapFS{}
+		collectorPriority = []string{readerSysfs, readerNetlink}
+
+		vf = netlink.VfInfo{ID: 0, RxBytes: 1000}
+		vfstats.ListLinks = func() ([]netlink.Link, error) {
+			return []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0", Vfs: []netlink.VfInfo{vf}}}}, nil
+		}
+		DeferCleanup(func() { vfstats.ListLinks = netlink.LinkList })
+
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return []*v1.PodResources{{Name: "pod-a", Namespace: "team-a", Containers: []*v1.ContainerResources{{
+				Name:    "app",
+				Devices: []*v1.ContainerDevices{{ResourceName: "intel.com/sriov", DeviceIds: []string{"0000:3b:02.0"}}},
+			}}}}, nil
+		}
+		DeferCleanup(func() { listPodResources = PodResources })
+	})
+
+	It("publishes the traffic of each pod and namespace", func() {
+		collector := createPodTrafficCollector()
+		gatherMetrics(collector)
+
+		vf.RxBytes, vf.TxPackets = 4000, 7
+		metrics := gatherMetrics(collector)
+
+		Expect(metrics).To(ContainElements(
+			`sriov_collector_success[name:"collector" value:"podtraffic"] 1`,
+			`sriov_pod_vf_rx_bytes_total[name:"namespace" value:"team-a" name:"pod" value:"pod-a"] 3000`,
+			`sriov_pod_vf_tx_packets_total[name:"namespace" value:"team-a" name:"pod" value:"pod-a"] 7`,
+			`sriov_namespace_vf_rx_bytes_total[name:"namespace" value:"team-a"] 3000`,
+			`sriov_namespace_vf_tx_packets_total[name:"namespace" value:"team-a"] 7`,
+		))
+	})
+
+	It("reads the vf stats once per scrape with the vfstats collector", func() {
+		dumps := 0
+		vfstats.ListLinks = func() ([]netlink.Link, error) {
+			dumps++
+			return []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0", Vfs: []netlink.VfInfo{vf}}}}, nil
+		}
+		collector := SriovCollector{
+			namedCollector{createSriovDevCollector(), vfStatsCollectorName},
+			namedCollector{createPodTrafficCollector(), podTrafficName},
+		}
+
+		gatherMetrics(collector)
+		Expect(dumps).To(Equal(1))
+
+		vf.RxBytes = 4000
+		Expect(gatherMetrics(collector)).To(ContainElements(
+			`sriov_vf_rx_bytes[name:"numa_node" value:"" name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 4000`,
+			`sriov_pod_vf_rx_bytes_total[name:"namespace" value:"team-a" name:"pod" value:"pod-a"] 3000`,
+		))
+		Expect(dumps).To(Equal(2))
+	})
+})
+
+var _ = It("samples the traffic of the pods outside of a scrape", func() { // PodTrafficSampler.Sample
+	devfs = fstest.MapFS{
+		"0000:3b:00.0/sriov_totalvfs": {Data: []byte("64")},
+		"0000:3b:00.0/sriov_numvfs":   {Data: []byte("1")},
+		"0000:3b:00.0/class":          {Data: []byte("0x020000")},
+		"0000:3b:00.0/net/ens1f0":     {Mode: fs.ModeDir},
+		"0000:3b:00.0/virtfn0":        {Data: []byte("../0000:3b:02.0"), Mode: fs.ModeSymlink},
+	}
+	netfs = fstest.MapFS{}
+	collectorPriority = []string{readerSysfs, readerNetlink}
+
+	vf := netlink.VfInfo{ID: 0, TxBytes: 100}
+	vfstats.ListLinks = func() ([]netlink.Link, error) {
+		return []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0", Vfs: []netlink.VfInfo{vf}}}}, nil
+	}
+	DeferCleanup(func() { vfstats.ListLinks = netlink.LinkList })
+
+	listPodResources = func() ([]*v1.PodResources, error) {
+		return []*v1.PodResources{{Name: "pod-a", Namespace: "team-a", Containers: []*v1.ContainerResources{{
+			Name:    "app",
+			Devices: []*v1.ContainerDevices{{ResourceName: "intel.com/sriov", DeviceIds: []string{"0000:3b:02.0"}}},
+		}}}}, nil
+	}
+	DeferCleanup(func() { listPodResources = PodResources })
+
+	sampler := NewPodTrafficSampler()
+	_, err := sampler.Sample()
+	Expect(err).ToNot(HaveOccurred())
+
+	vf.TxBytes = 600
+	traffic, err := sampler.Sample()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(traffic).To(HaveLen(1))
+	Expect(traffic[0].Namespace).To(Equal("team-a"))
+	Expect(traffic[0].Pod).To(Equal("pod-a"))
+	Expect(traffic[0].Stats).To(HaveKeyWithValue("tx_bytes", int64(500)))
+})
+
+var _ = It("rebuilds the device collector of the sampler when its flags change", func() { // PodTrafficSampler.Rebuild
+	devfs = fstest.MapFS{
+		"0000:3b:00.0/sriov_totalvfs": {Data: []byte("64")},
+		"0000:3b:00.0/class":          {Data: []byte("0x020000")},
+	}
+	sampler := NewPodTrafficSampler()
+	Expect(sampler.devs.pfsWithNumaInfo).To(HaveKey("0000:3b:00.0"))
+
+	devfs = fstest.MapFS{}
+	sampler.Rebuild(map[string]bool{"log.level": true})
+	Expect(sampler.devs.pfsWithNumaInfo).To(HaveKey("0000:3b:00.0"))
+
+	sampler.Rebuild(map[string]bool{"path.sysbuspci": true})
+	Expect(sampler.devs.pfsWithNumaInfo).To(BeEmpty())
+})
@@ -0,0 +1,406 @@
+package collectors
+
+// snapshot captures the host state read by the collectors into an archive, and replays an extracted archive by
+// pointing the collectors' filesystems, pod resources and netlink links at it
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+// pfFiles are the files of a PF directory read by the vfstats collector
+var pfFiles = []string{"sriov_totalvfs", numVfsFile, netClassFile, "numa_node"}
+
+// WriteSnapshot archives the host state read by the collectors to w: the sysfs files of the SR-IOV PFs and their
+// VF stats, the netlink VF stats, and for enabled kubernetes collectors the pod cpusets, cpu NUMA layout,
+// cpu manager checkpoint and pod resources. Host state that can not be read is logged and left out.
+func WriteSnapshot(w io.Writer) error {
+	sw := snapshot.NewWriter(w)
+
+	steps := []func(*snapshot.Writer, []string) error{snapshotPCI, snapshotNet, snapshotNetlink}
+	podCPUsFromPodResources := *kubePodCPUSource == cpuSourcePodResources
+	if *collectorState[kubepodcpu] && podCPUsFromPodResources {
+		steps = append(steps, snapshotNodes)
+	} else if *collectorState[kubepodcpu] {
+		steps = append(steps, snapshotCgroups, snapshotNodes, snapshotCheckpoint)
+	}
+	if readsPodResources() || (*collectorState[kubepodcpu] && podCPUsFromPodResources) {
+		steps = append(steps, snapshotPodResources)
+	}
+	if readsPodResources() {
+		steps = append(steps, snapshotDeviceCheckpoint)
+	}
+	if *collectorState[vfResourcePoolName] {
+		steps = append(steps, snapshotDevicePluginConfig)
+	}
+
+	pfs := getSriovDevAddrs()
+	for _, step := range steps {
+		if err := step(sw, pfs); err != nil {
+			return fmt.Errorf("could not write snapshot\n%v", err)
+		}
+	}
+
+	return sw.Close()
+}
+
+// snapshotPCI archives the PF files, driver and VF links. Symlinks are rewritten to point within the archive.
+func snapshotPCI(sw *snapshot.Writer, pfs []string) error {
+	if err := sw.Dir(snapshot.PCIDir); err != nil {
+		return err
+	}
+
+	for _, pf := range pfs {
+		dir := filepath.Join(snapshot.PCIDir, pf)
+		for _, name := range pfFiles {
+			if data, err := fs.ReadFile(devfs, filepath.Join(pf, name)); err == nil {
+				if err := sw.File(filepath.Join(dir, name), data); err != nil {
+					return err
+				}
+			}
+		}
+
+		if name := getPFName(pf); name != "" {
+			if err := sw.Dir(filepath.Join(dir, pfNameFile, name)); err != nil {
+				return err
+			}
+		}
+
+		if driver := getPFDriver(pf); driver != "" {
+			if err := sw.Dir(filepath.Join(snapshot.DriverDir, driver)); err != nil {
+				return err
+			}
+			if err := sw.Symlink(filepath.Join(dir, pfDriverFile), filepath.Join(snapshot.DriverDir, driver)); err != nil {
+				return err
+			}
+		}
+
+		vfs, err := fs.Glob(devfs, filepath.Join(pf, "virtfn*"))
+		if err != nil {
+			return err
+		}
+		for _, vf := range vfs {
+			_, vfAddr := vfData(vf)
+			if vfAddr == "" {
+				continue
+			}
+			if err := snapshotVF(sw, vfAddr); err != nil {
+				return err
+			}
+			if err := sw.Symlink(filepath.Join(snapshot.PCIDir, vf), filepath.Join(snapshot.PCIDir, vfAddr)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapshotVF archives the vendor and device ids and the driver link of a VF, matched by the resource pool selectors
+func snapshotVF(sw *snapshot.Writer, vfAddr string) error {
+	dir := filepath.Join(snapshot.PCIDir, vfAddr)
+	if err := sw.Dir(dir); err != nil {
+		return err
+	}
+
+	for _, name := range []string{vendorFile, deviceFile} {
+		if data, err := fs.ReadFile(devfs, filepath.Join(vfAddr, name)); err == nil {
+			if err := sw.File(filepath.Join(dir, name), data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if driver := readDriver(vfAddr); driver != "" {
+		if err := sw.Dir(filepath.Join(snapshot.DriverDir, driver)); err != nil {
+			return err
+		}
+		return sw.Symlink(filepath.Join(dir, pfDriverFile), filepath.Join(snapshot.DriverDir, driver))
+	}
+
+	return nil
+}
+
+// snapshotNet archives the sysfs VF stats of each PF
+func snapshotNet(sw *snapshot.Writer, pfs []string) error {
+	if err := sw.Dir(snapshot.NetDir); err != nil {
+		return err
+	}
+
+	for _, pf := range pfs {
+		name := getPFName(pf)
+		sriovPath := filepath.Join(name, "device", "sriov")
+		if _, err := fs.Stat(netfs, sriovPath); name == "" || err != nil {
+			continue
+		}
+
+		if err := sw.Dir(filepath.Join(snapshot.NetDir, sriovPath)); err != nil {
+			return err
+		}
+
+		files, err := fs.Glob(netfs, filepath.Join(sriovPath, "*", "stats", "*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if utils.IsSymLink(netfs, file) {
+				continue
+			}
+			data, err := fs.ReadFile(netfs, file)
+			if err != nil {
+				slog.Warn("could not read stat file", labelPF, name, "path", file, "err", err)
+				continue
+			}
+			if err := sw.File(filepath.Join(snapshot.NetDir, file), data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapshotNetlink archives the netlink VF stats of each PF
+func snapshotNetlink(sw *snapshot.Writer, pfs []string) error {
+	links := vfstats.Dump()
+
+	vfs := make(map[string][]netlink.VfInfo)
+	for _, pf := range pfs {
+		name := getPFName(pf)
+		if perPF, ok := links[name]; ok {
+			for _, vf := range perPF.Vfs {
+				vfs[name] = append(vfs[name], vf)
+			}
+		}
+	}
+
+	if len(vfs) == 0 {
+		slog.Info("no netlink vf stats to capture")
+		return nil
+	}
+
+	data, err := json.Marshal(vfs)
+	if err != nil {
+		return err
+	}
+
+	return sw.File(snapshot.NetlinkFile, data)
+}
+
+// snapshotCgroups archives the cpusets of the kubernetes parent cgroup and the containers of each pod
+func snapshotCgroups(sw *snapshot.Writer, _ []string) error {
+	if err := sw.Dir(snapshot.CgroupDir); err != nil {
+		return err
+	}
+
+	cpuSetFile := "cpuset.cpus"
+	cpuSet, err := fs.ReadFile(kubecgroupfs, cpuSetFile)
+	if err != nil {
+		slog.Warn("could not capture kubernetes cpuset", labelCollector, kubepodcpu, "err", err)
+		return nil
+	}
+	if err := sw.File(filepath.Join(snapshot.CgroupDir, cpuSetFile), cpuSet); err != nil {
+		return err
+	}
+
+	pods, err := getPodDirectories()
+	if err != nil {
+		slog.Warn("could not capture pod cpusets", labelCollector, kubepodcpu, "err", err)
+		return nil
+	}
+
+	for _, pod := range pods {
+		containers, err := getContainerIDs(pod)
+		if err != nil {
+			slog.Warn("could not capture pod cpusets", labelCollector, kubepodcpu, "err", err)
+			continue
+		}
+
+		for _, container := range containers {
+			path := filepath.Join(pod, container, cpuSetFile)
+			data, err := fs.ReadFile(kubecgroupfs, path)
+			if err != nil {
+				continue
+			}
+			if err := sw.File(filepath.Join(snapshot.CgroupDir, path), data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapshotNodes archives the NUMA node of each cpu
+func snapshotNodes(sw *snapshot.Writer, _ []string) error {
+	if err := sw.Dir(snapshot.NodeDir); err != nil {
+		return err
+	}
+
+	cpuInfo, err := getCPUInfo()
+	if err != nil {
+		slog.Warn("could not capture cpu info", labelCollector, kubepodcpu, "err", err)
+		return nil
+	}
+
+	for cpu, numaNode := range cpuInfo {
+		if err := sw.Dir(filepath.Join(snapshot.NodeDir, "node"+numaNode, "cpu"+cpu)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshotCheckpoint archives the cpu manager checkpoint
+func snapshotCheckpoint(sw *snapshot.Writer, _ []string) error {
+	if err := sw.Dir(snapshot.CheckpointDir); err != nil {
+		return err
+	}
+
+	name := filepath.Base(*cpuCheckPointFile)
+	data, err := fs.ReadFile(cpucheckpointfs, name)
+	if err != nil {
+		slog.Warn("could not capture cpu checkpoint file", labelCollector, kubepodcpu, "err", err)
+		return nil
+	}
+
+	return sw.File(filepath.Join(snapshot.CheckpointDir, name), data)
+}
+
+// snapshotPodResources archives the pod resources listed by the kubelet
+func snapshotPodResources(sw *snapshot.Writer, _ []string) error {
+	resources, err := PodResources()
+	if err != nil {
+		slog.Warn("could not capture pod resources", labelCollector, podDevLinkName, "err", err)
+		return nil
+	}
+
+	data, err := protojson.Marshal(&v1.ListPodResourcesResponse{PodResources: resources})
+	if err != nil {
+		return err
+	}
+
+	return sw.File(snapshot.PodResourcesFile, data)
+}
+
+// snapshotDeviceCheckpoint archives the device manager checkpoint, read when the pod resources api is not available
+func snapshotDeviceCheckpoint(sw *snapshot.Writer, _ []string) error {
+	data, err := os.ReadFile(*deviceCheckpointFile)
+	if err != nil {
+		slog.Warn("could not capture device checkpoint file", labelCollector, podDevLinkName, "err", err)
+		return nil
+	}
+
+	return sw.File(snapshot.DeviceCheckpointFile, data)
+}
+
+// snapshotDevicePluginConfig archives the SR-IOV network device plugin config
+func snapshotDevicePluginConfig(sw *snapshot.Writer, _ []string) error {
+	data, err := os.ReadFile(*devicePluginConfig)
+	if err != nil {
+		slog.Warn("could not capture device plugin config", labelCollector, vfResourcePoolName, "err", err)
+		return nil
+	}
+
+	return sw.File(snapshot.DevicePluginFile, data)
+}
+
+// LoadSnapshot points the collectors at a snapshot extracted to dir, replacing the host filesystems, the kubelet
+// pod resources and the netlink links. It must be called before the collectors are created.
+func LoadSnapshot(dir string) error {
+	*sysBusPci = filepath.Join(dir, snapshot.PCIDir)
+	*sysClassNet = filepath.Join(dir, snapshot.NetDir)
+	if err := resolveSriovDevFilepaths(true); err != nil {
+		return fmt.Errorf("invalid snapshot\n%v", err)
+	}
+
+	kubecgroupfs = os.DirFS(filepath.Join(dir, snapshot.CgroupDir))
+	cpuinfofs = os.DirFS(filepath.Join(dir, snapshot.NodeDir))
+	cpucheckpointfs = os.DirFS(filepath.Join(dir, snapshot.CheckpointDir))
+	*devicePluginConfig = filepath.Join(dir, snapshot.DevicePluginFile)
+	*deviceCheckpointFile = filepath.Join(dir, snapshot.DeviceCheckpointFile)
+
+	if err := loadSnapshotPodResources(filepath.Join(dir, snapshot.PodResourcesFile)); err != nil {
+		return fmt.Errorf("invalid snapshot\n%v", err)
+	}
+
+	if err := loadSnapshotNetlink(filepath.Join(dir, snapshot.NetlinkFile)); err != nil {
+		return fmt.Errorf("invalid snapshot\n%v", err)
+	}
+
+	return nil
+}
+
+func loadSnapshotPodResources(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		listPodResources = func() ([]*v1.PodResources, error) {
+			return nil, fmt.Errorf("pod resources were not captured in the snapshot")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	resp := &v1.ListPodResourcesResponse{}
+	if err := protojson.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("%s - %v", snapshot.PodResourcesFile, err)
+	}
+
+	listPodResources = func() ([]*v1.PodResources, error) {
+		return resp.GetPodResources(), nil
+	}
+
+	return nil
+}
+
+func loadSnapshotNetlink(path string) error {
+	vfs := make(map[string][]netlink.VfInfo)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &vfs); err != nil {
+			return fmt.Errorf("%s - %v", snapshot.NetlinkFile, err)
+		}
+	}
+
+	links := make(map[string]netlink.Link, len(vfs))
+	for name, infos := range vfs {
+		links[name] = &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name, Vfs: infos}}
+	}
+
+	vfstats.ListLinks = func() ([]netlink.Link, error) {
+		list := make([]netlink.Link, 0, len(links))
+		for _, link := range links {
+			list = append(list, link)
+		}
+		return list, nil
+	}
+	vfstats.GetLink = func(name string) (netlink.Link, error) {
+		if link, ok := links[name]; ok {
+			return link, nil
+		}
+		return nil, fmt.Errorf("link %s was not captured in the snapshot", name)
+	}
+
+	return nil
+}
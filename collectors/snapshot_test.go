@@ -0,0 +1,219 @@
+package collectors
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakekubelet"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+// gatherMetrics collects from the collector and returns each metric as a sorted string of its name, labels and value
+func gatherMetrics(collector prometheus.Collector) []string {
+	registry := prometheus.NewRegistry()
+	Expect(registry.Register(SriovCollector{collector})).To(Succeed())
+
+	families, err := registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+
+	metrics := make([]string, 0)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			// the labels are formatted by hand, the text format of protobuf messages is deliberately unstable
+			labels := make([]string, 0, len(m.GetLabel()))
+			for _, pair := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("name:%q value:%q", pair.GetName(), pair.GetValue()))
+			}
+			value := m.GetCounter().GetValue() + m.GetGauge().GetValue()
+			metrics = append(metrics, fmt.Sprintf("%s[%s] %v", family.GetName(), strings.Join(labels, " "), value))
+		}
+	}
+	sort.Strings(metrics)
+
+	return metrics
+}
+
+var _ = Describe("test capturing and replaying snapshots", func() { // WriteSnapshot, LoadSnapshot
+	host := fstest.MapFS{
+		"0000:8a:00.0/sriov_totalvfs":              {Data: []byte("8")},
+		"0000:8a:00.0/sriov_numvfs":                {Data: []byte("2")},
+		"0000:8a:00.0/net/t_ens4f0":                {Mode: fs.ModeDir},
+		"0000:8a:00.0/numa_node":                   {Data: []byte("1")},
+		"0000:8a:00.0/class":                       {Data: []byte("0x020000")},
+		"0000:8a:00.0/driver":                      {Data: []byte("/sys/bus/pci/drivers/ice"), Mode: fs.ModeSymlink},
+		"0000:8a:00.0/virtfn0":                     {Data: []byte("/sys/devices/0000:8a:01.0"), Mode: fs.ModeSymlink},
+		"0000:8a:00.0/virtfn1":                     {Data: []byte("/sys/devices/0000:8a:01.1"), Mode: fs.ModeSymlink},
+		"0000:8a:01.0/vendor":                      {Data: []byte("0x8086\n")},
+		"0000:8a:01.0/device":                      {Data: []byte("0x1889\n")},
+		"0000:8a:01.0/driver":                      {Data: []byte("/sys/bus/pci/drivers/iavf"), Mode: fs.ModeSymlink},
+		"t_ens4f0/device/sriov/0/stats/rx_packets": {Data: []byte("4")},
+		"t_ens4f0/device/sriov/0/stats/tx_packets": {Data: []byte("8")},
+		"t_ens4f0/device/sriov/1/stats/rx_packets": {Data: []byte("16")},
+		"t_ens4f0/device/sriov/1/stats/tx_packets": {Data: []byte("32")},
+		"0000:9a:00.0/sriov_totalvfs":              {Data: []byte("8")},
+		"0000:9a:00.0/net/t_ens5f0":                {Mode: fs.ModeDir},
+		"0000:9a:00.0/numa_node":                   {Data: []byte("0")},
+		"0000:9a:00.0/class":                       {Data: []byte("0x020000")},
+		"0000:9a:00.0/virtfn0":                     {Data: []byte("/sys/devices/0000:9a:01.0"), Mode: fs.ModeSymlink},
+	}
+	cgroups := fstest.MapFS{
+		"cpuset.cpus": {Data: []byte("0-7")},
+		"kubepods-pod6b5b533a_6307_48d1_911f_07bf5d4e1c82.slice/0123456789abcdefaaaa/cpuset.cpus": {Data: []byte("2-3")},
+	}
+	nodes := fstest.MapFS{
+		"node0/cpu2": {Mode: fs.ModeDir},
+		"node1/cpu3": {Mode: fs.ModeDir},
+	}
+	checkpoint := fstest.MapFS{
+		"cpu_manager_state": {Data: []byte(`{"policyName":"static","defaultCpuSet":"0-1,4-7","checksum":1}`)},
+	}
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "t_ens5f0", Vfs: []netlink.VfInfo{{ID: 0, RxBytes: 64, TxBytes: 128}}}},
+	}
+
+	BeforeEach(func() {
+		sysBusPciOrig, sysClassNetOrig, devicePluginConfigOrig := *sysBusPci, *sysClassNet, *devicePluginConfig
+		kubepodcpuOrig, vfResourcePoolOrig := *collectorState[kubepodcpu], *collectorState[vfResourcePoolName]
+		DeferCleanup(func() {
+			*sysBusPci, *sysClassNet, *devicePluginConfig = sysBusPciOrig, sysClassNetOrig, devicePluginConfigOrig
+			*collectorState[kubepodcpu], *collectorState[vfResourcePoolName] = kubepodcpuOrig, vfResourcePoolOrig
+			utils.EvalSymlinks = evalSymlinks
+			vfstats.ListLinks = netlink.LinkList
+			vfstats.GetLink = netlink.LinkByName
+			listPodResources = PodResources
+		})
+
+		devfs, netfs = host, host
+		kubecgroupfs, cpuinfofs, cpucheckpointfs = cgroups, nodes, checkpoint
+		collectorPriority = []string{readerSysfs, readerNetlink}
+		*collectorState[kubepodcpu] = true
+		vfstats.ListLinks = func() ([]netlink.Link, error) { return links, nil }
+	})
+
+	It("replays the metrics collected on the host", func() {
+		readerProbeFailures.reset()
+		expected := append(gatherMetrics(createSriovDevCollector()), gatherMetrics(createKubepodCPUCollector())...)
+
+		archive := &bytes.Buffer{}
+		Expect(WriteSnapshot(archive)).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		Expect(snapshot.Extract(archive, dir)).To(Succeed())
+
+		// The extracted archive contains real symlinks
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		vfstats.ListLinks = nil
+		Expect(LoadSnapshot(dir)).To(Succeed())
+
+		readerProbeFailures.reset()
+		replayed := append(gatherMetrics(createSriovDevCollector()), gatherMetrics(createKubepodCPUCollector())...)
+		Expect(replayed).To(Equal(expected))
+		Expect(replayed).To(ContainElement(ContainSubstring("sriov_vf_tx_bytes")))
+		Expect(replayed).To(ContainElement(ContainSubstring("sriov_kubepodcpu")))
+	})
+
+	It("replays the resource pools of the vfs", func() {
+		*collectorState[vfResourcePoolName] = true
+		*devicePluginConfig = filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(*devicePluginConfig, []byte(`{"resourceList": [
+			{"resourceName": "sriov_netdevice", "selectors": {"vendors": ["8086"], "devices": ["1889"], "drivers": ["iavf"]}}
+		]}`), 0o600)).To(Succeed())
+		expected := gatherMetrics(createVfResourcePoolCollector())
+		Expect(expected).To(ContainElement(ContainSubstring(`value:"intel.com/sriov_netdevice"`)))
+
+		archive := &bytes.Buffer{}
+		Expect(WriteSnapshot(archive)).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		Expect(snapshot.Extract(archive, dir)).To(Succeed())
+
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		vfstats.ListLinks = nil
+		Expect(LoadSnapshot(dir)).To(Succeed())
+
+		Expect(gatherMetrics(createVfResourcePoolCollector())).To(Equal(expected))
+	})
+
+	It("replays the device manager checkpoint", func() {
+		socketPath := filepath.Join(GinkgoT().TempDir(), "kubelet.sock")
+		kubelet := fakekubelet.NewServer(&fakekubelet.Scenario{})
+		Expect(kubelet.Start(socketPath)).To(Succeed())
+		DeferCleanup(kubelet.Stop)
+
+		podResourcesPathOrig, deviceCheckpointFileOrig := *podResourcesPath, *deviceCheckpointFile
+		podDevLinkOrig := *collectorState[podDevLinkName]
+		DeferCleanup(func() {
+			*podResourcesPath, *deviceCheckpointFile = podResourcesPathOrig, deviceCheckpointFileOrig
+			*collectorState[podDevLinkName] = podDevLinkOrig
+		})
+
+		*collectorState[podDevLinkName] = true
+		*podResourcesPath = socketPath
+		*deviceCheckpointFile = filepath.Join(GinkgoT().TempDir(), "kubelet_internal_checkpoint")
+		data := []byte(`{"Data": {"PodDeviceEntries": [], "RegisteredDevices": {}}, "Checksum": 1234}`)
+		Expect(os.WriteFile(*deviceCheckpointFile, data, 0o600)).To(Succeed())
+
+		archive := &bytes.Buffer{}
+		Expect(WriteSnapshot(archive)).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		Expect(snapshot.Extract(archive, dir)).To(Succeed())
+
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		vfstats.ListLinks = nil
+		Expect(LoadSnapshot(dir)).To(Succeed())
+
+		Expect(*deviceCheckpointFile).To(Equal(filepath.Join(dir, snapshot.DeviceCheckpointFile)))
+		Expect(os.ReadFile(*deviceCheckpointFile)).To(Equal(data))
+	})
+
+	It("replays the captured pod resources", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.Mkdir(filepath.Join(dir, snapshot.PCIDir), 0o700)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, snapshot.NetDir), 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, snapshot.PodResourcesFile), []byte(`{"podResources": [{
+			"name": "pod1", "namespace": "default",
+			"containers": [{"name": "app", "devices": [{"resourceName": "intel.com/sriov", "deviceIds": ["0000:8a:01.0"]}]}]
+		}]}`), 0o600)).To(Succeed())
+
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		Expect(LoadSnapshot(dir)).To(Succeed())
+
+		resources, err := listPodResources()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resources).To(HaveLen(1))
+		Expect(resources[0].GetContainers()[0].GetDevices()).To(HaveLen(1))
+		Expect(resources[0].GetContainers()[0].GetDevices()[0].GetDeviceIds()).To(Equal([]string{"0000:8a:01.0"}))
+	})
+
+	It("reports pod resources missing from the snapshot", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.Mkdir(filepath.Join(dir, snapshot.PCIDir), 0o700)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, snapshot.NetDir), 0o700)).To(Succeed())
+
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		Expect(LoadSnapshot(dir)).To(Succeed())
+
+		resources, err := listPodResources()
+		Expect(err).To(MatchError("pod resources were not captured in the snapshot"))
+		Expect(resources).To(BeEmpty())
+	})
+
+	It("rejects a directory that is not a snapshot", func() {
+		utils.EvalSymlinks = filepath.EvalSymlinks
+		Expect(LoadSnapshot(GinkgoT().TempDir())).To(MatchError(ContainSubstring("invalid snapshot")))
+	})
+})
@@ -0,0 +1,208 @@
+package collectors
+
+// sriovNodeStateCollector compares the SR-IOV configuration desired by the SR-IOV Network Operator, read from the
+// SriovNetworkNodeState of the node, with the state of the host, and labels the VFs with the policy configuring them
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+const (
+	sriovNodeStateName = "sriovnodestate"
+
+	// Device types of a SriovNetworkNodePolicy. A vfio-pci vf is bound to the driver of that name, a netdevice vf
+	// to the kernel network driver of the NIC.
+	deviceTypeNetdevice = "netdevice"
+	deviceTypeVfioPCI   = "vfio-pci"
+
+	// Fields compared between the desired and the observed state
+	driftNumVfs = "numvfs"
+	driftMTU    = "mtu"
+	driftDriver = "driver"
+	// driftMissing is the drift of a vf configured by a policy that does not exist on the host, published with the
+	// PCI address of its pf
+	driftMissing = "missing"
+)
+
+var (
+	// nodeStates is the watched SriovNetworkNodeState of the node, nil unless set with SetNodeStates
+	nodeStates *kube.NodeStates
+
+	nodeStateSyncDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "node_state", "sync_info"),
+		"Sync status of the SriovNetworkNodeState of the node",
+		[]string{"sync_status"}, nil,
+	)
+	nodeStateDriftDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "node_state", "drift"),
+		"Whether the observed numvfs or mtu of a pf, or the driver of a vf, differs from the SriovNetworkNodeState, "+
+			"or a vf of the SriovNetworkNodeState is missing",
+		[]string{labelPCIAddr, labelPF, labelVF, "field"}, nil,
+	)
+	vfPolicyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "policy_info"),
+		"SriovNetworkNodePolicy, resource name and device type configuring the vf",
+		[]string{labelPCIAddr, labelPF, labelVF, "policy", "resource_name", "device_type"}, nil,
+	)
+)
+
+// sriovNodeStateCollector publishes the operator state of the node
+type sriovNodeStateCollector struct {
+	states *kube.NodeStates
+	name   string
+}
+
+// init runs the registration for this collector on package import
+func init() {
+	register(sriovNodeStateName, disabled, createSriovNodeStateCollector)
+}
+
+// SetNodeStates sets the watched SriovNetworkNodeState of the node. It must be called before the collectors are created.
+func SetNodeStates(states *kube.NodeStates) {
+	nodeStates = states
+}
+
+// NodeStateEnabled returns true if the sriovnodestate collector is enabled, which needs the node state to be watched
+func NodeStateEnabled() bool {
+	return *collectorState[sriovNodeStateName]
+}
+
+// Collect publishes the sync status of the node state, the policy of each configured vf and the drift of the
+// host from the desired configuration. Failures to read the node state are published under sriov_collector_success.
+func (c sriovNodeStateCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.states == nil {
+		err := errors.New("sriov network node state is not watched, the exporter must be restarted after enabling the collector")
+		slog.Warn("node state not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
+		return
+	}
+
+	state, err := c.states.Get()
+	if err != nil {
+		slog.Warn("node state not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(nodeStateSyncDesc, prometheus.GaugeValue, 1, state.Status.SyncStatus)
+
+	for _, iface := range state.Spec.Interfaces {
+		c.collectInterface(ch, iface)
+	}
+
+	publishCollectorSuccess(ch, c.name, nil)
+}
+
+// collectInterface compares the desired configuration of a pf and its vfs with the host. The numvfs and mtu of
+// externally managed pfs are not configured by the operator and not compared.
+func (c sriovNodeStateCollector) collectInterface(ch chan<- prometheus.Metric, iface kube.SriovInterface) {
+	pfAddr := iface.PCIAddress
+	pfName := getPFName(pfAddr)
+
+	if !iface.ExternallyManaged {
+		publishDrift(ch, pfAddr, pfName, "", driftNumVfs, readNumVfs(pfAddr) != strconv.Itoa(iface.NumVfs))
+		if iface.MTU > 0 {
+			publishDrift(ch, pfAddr, pfName, "", driftMTU, readMTU(pfName) != strconv.Itoa(iface.MTU))
+		}
+	}
+
+	if iface.NumVfs == 0 {
+		return
+	}
+
+	vfs, err := vfList(pfAddr)
+	if err != nil {
+		slog.Debug("no vfs to compare with the node state", labelCollector, c.name, labelPF, pfAddr, "err", err)
+	}
+
+	for _, group := range iface.VFGroups {
+		ids, err := group.VFIDs()
+		if err != nil {
+			slog.Warn("invalid vf group in node state", labelCollector, c.name, labelPF, pfAddr, "policy", group.PolicyName, "err", err)
+			continue
+		}
+
+		for _, id := range ids {
+			vfAddr, ok := vfs[id]
+			if !ok {
+				publishDrift(ch, pfAddr, pfName, id, driftMissing, true)
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(vfPolicyDesc, prometheus.GaugeValue, 1,
+				vfAddr, pfName, id, group.PolicyName, group.ResourceName, group.DeviceType)
+
+			if drifted, ok := driverDrift(group.DeviceType, vfAddr); ok {
+				publishDrift(ch, vfAddr, pfName, id, driftDriver, drifted)
+			}
+		}
+	}
+}
+
+// driverDrift returns whether the driver bound to the vf does not match the device type, and false for ok if the
+// device type is not known
+func driverDrift(deviceType, vfAddr string) (bool, bool) {
+	driver := readDriver(vfAddr)
+	switch deviceType {
+	case deviceTypeVfioPCI:
+		return driver != deviceTypeVfioPCI, true
+	case deviceTypeNetdevice:
+		return driver == "" || driver == deviceTypeVfioPCI, true
+	default:
+		return false, false
+	}
+}
+
+func publishDrift(ch chan<- prometheus.Metric, addr, pf, vf, field string, drifted bool) {
+	value := 0.0
+	if drifted {
+		value = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(nodeStateDriftDesc, prometheus.GaugeValue, value, addr, pf, vf, field)
+}
+
+// readMTU returns the mtu of a network interface, or an empty string if it can not be read
+func readMTU(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	mtu, err := fs.ReadFile(netfs, filepath.Join(name, "mtu"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(mtu))
+}
+
+// readDriver returns the name of the driver bound to a PCI device, or an empty string if none is bound
+func readDriver(addr string) string {
+	link, err := utils.EvalSymlinks(filepath.Join(*sysBusPci, addr, pfDriverFile))
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Base(link)
+}
+
+// Describe is not defined for this collector
+func (c sriovNodeStateCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func createSriovNodeStateCollector() prometheus.Collector {
+	return sriovNodeStateCollector{
+		states: nodeStates,
+		name:   sriovNodeStateName,
+	}
+}
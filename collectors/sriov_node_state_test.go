@@ -0,0 +1,156 @@
+package collectors
+
+import (
+	"context"
+	"io/fs"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/kube"
+)
+
+// watchNodeState serves the node state objects from a fake API server and sets them for the collector
+func watchNodeState(objects ...runtime.Object) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{kube.NodeStateResource: "SriovNetworkNodeStateList"}, objects...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	DeferCleanup(cancel)
+	states, err := kube.WatchNodeState(ctx, client, "sriov-network-operator", "node1", 10*time.Second)
+	Expect(err).ToNot(HaveOccurred())
+
+	SetNodeStates(states)
+	DeferCleanup(SetNodeStates, (*kube.NodeStates)(nil))
+}
+
+var _ = Describe("test sriov node state collection", func() { // sriovNodeStateCollector.Collect
+	BeforeEach(func() {
+		devfs = fstest.MapFS{
+			"0000:3b:00.0/net/ens1f0":   {Mode: fs.ModeDir},
+			"0000:3b:00.0/sriov_numvfs": {Data: []byte("2")},
+			"0000:3b:00.0/virtfn0":      {Data: []byte("../0000:3b:02.0"), Mode: fs.ModeSymlink},
+			"0000:3b:00.0/virtfn1":      {Data: []byte("../0000:3b:02.1"), Mode: fs.ModeSymlink},
+			"0000:3b:02.0/driver":       {Data: []byte("../../../../bus/pci/drivers/iavf"), Mode: fs.ModeSymlink},
+			"0000:3b:02.1/driver":       {Data: []byte("../../../../bus/pci/drivers/iavf"), Mode: fs.ModeSymlink},
+			"0000:5e:00.0/net/ens2f0":   {Mode: fs.ModeDir},
+			"0000:5e:00.0/sriov_numvfs": {Data: []byte("0")},
+		}
+		netfs = fstest.MapFS{
+			"ens1f0/mtu": {Data: []byte("9000")},
+			"ens2f0/mtu": {Data: []byte("1500")},
+		}
+	})
+
+	It("publishes the vf policies and the drift from the desired state", func() {
+		watchNodeState(&unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "sriovnetwork.openshift.io/v1",
+			"kind":       "SriovNetworkNodeState",
+			"metadata":   map[string]any{"name": "node1", "namespace": "sriov-network-operator"},
+			"spec": map[string]any{"interfaces": []any{
+				map[string]any{
+					"pciAddress": "0000:3b:00.0",
+					"numVfs":     int64(2),
+					"mtu":        int64(9000),
+					"vfGroups": []any{
+						map[string]any{"resourceName": "sriov_net", "deviceType": "netdevice", "vfRange": "0-0", "policyName": "policy-net"},
+						map[string]any{"resourceName": "sriov_dpdk", "deviceType": "vfio-pci", "vfRange": "1-1", "policyName": "policy-dpdk"},
+					},
+				},
+				map[string]any{
+					"pciAddress": "0000:5e:00.0",
+					"numVfs":     int64(4),
+					"mtu":        int64(9000),
+					"vfGroups": []any{
+						map[string]any{"resourceName": "sriov_net", "deviceType": "netdevice", "vfRange": "0-0", "policyName": "policy-net"},
+					},
+				},
+			}},
+			"status": map[string]any{"syncStatus": "InProgress"},
+		}})
+
+		Expect(gatherMetrics(createSriovNodeStateCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"sriovnodestate"] 1`,
+			`sriov_node_state_drift[name:"field" value:"driver" name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 0`,
+			`sriov_node_state_drift[name:"field" value:"driver" name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"vf" value:"1"] 1`,
+			`sriov_node_state_drift[name:"field" value:"missing" name:"pciAddr" value:"0000:5e:00.0" name:"pf" value:"ens2f0" name:"vf" value:"0"] 1`,
+			`sriov_node_state_drift[name:"field" value:"mtu" name:"pciAddr" value:"0000:3b:00.0" name:"pf" value:"ens1f0" name:"vf" value:""] 0`,
+			`sriov_node_state_drift[name:"field" value:"mtu" name:"pciAddr" value:"0000:5e:00.0" name:"pf" value:"ens2f0" name:"vf" value:""] 1`,
+			`sriov_node_state_drift[name:"field" value:"numvfs" name:"pciAddr" value:"0000:3b:00.0" name:"pf" value:"ens1f0" name:"vf" value:""] 0`,
+			`sriov_node_state_drift[name:"field" value:"numvfs" name:"pciAddr" value:"0000:5e:00.0" name:"pf" value:"ens2f0" name:"vf" value:""] 1`,
+			`sriov_node_state_sync_info[name:"sync_status" value:"InProgress"] 1`,
+			`sriov_vf_policy_info[name:"device_type" value:"netdevice" name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" ` +
+				`name:"policy" value:"policy-net" name:"resource_name" value:"sriov_net" name:"vf" value:"0"] 1`,
+			`sriov_vf_policy_info[name:"device_type" value:"vfio-pci" name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" ` +
+				`name:"policy" value:"policy-dpdk" name:"resource_name" value:"sriov_dpdk" name:"vf" value:"1"] 1`,
+		}))
+	})
+
+	It("does not compare the numvfs and mtu of externally managed pfs", func() {
+		watchNodeState(&unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "sriovnetwork.openshift.io/v1",
+			"kind":       "SriovNetworkNodeState",
+			"metadata":   map[string]any{"name": "node1", "namespace": "sriov-network-operator"},
+			"spec": map[string]any{"interfaces": []any{
+				map[string]any{
+					"pciAddress":        "0000:3b:00.0",
+					"numVfs":            int64(4),
+					"mtu":               int64(1500),
+					"externallyManaged": true,
+					"vfGroups": []any{
+						map[string]any{"resourceName": "sriov_net", "deviceType": "netdevice", "vfRange": "0-0", "policyName": "policy-net"},
+					},
+				},
+			}},
+			"status": map[string]any{"syncStatus": "Succeeded"},
+		}})
+
+		Expect(gatherMetrics(createSriovNodeStateCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"sriovnodestate"] 1`,
+			`sriov_node_state_drift[name:"field" value:"driver" name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 0`,
+			`sriov_node_state_sync_info[name:"sync_status" value:"Succeeded"] 1`,
+			`sriov_vf_policy_info[name:"device_type" value:"netdevice" name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" ` +
+				`name:"policy" value:"policy-net" name:"resource_name" value:"sriov_net" name:"vf" value:"0"] 1`,
+		}))
+	})
+
+	It("reports a failure without a node state", func() {
+		watchNodeState()
+
+		Expect(gatherMetrics(createSriovNodeStateCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"sriovnodestate"] 0`,
+		}))
+		assertLogs([]string{"node state not available collector=sriovnodestate"})
+	})
+
+	It("reports a failure when the node state is not watched", func() {
+		Expect(gatherMetrics(createSriovNodeStateCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"sriovnodestate"] 0`,
+		}))
+	})
+})
+
+var _ = DescribeTable("test comparing the vf driver with the device type", // driverDrift
+	func(deviceType, driver string, expectedDrift, expectedOk bool) {
+		devfs = fstest.MapFS{"0000:3b:02.0/driver": {Data: []byte("../drivers/" + driver), Mode: fs.ModeSymlink}}
+		if driver == "" {
+			devfs = fstest.MapFS{"0000:3b:02.0": {Mode: fs.ModeDir}}
+		}
+
+		drifted, ok := driverDrift(deviceType, "0000:3b:02.0")
+		Expect(drifted).To(Equal(expectedDrift))
+		Expect(ok).To(Equal(expectedOk))
+	},
+	Entry("netdevice bound to a network driver", "netdevice", "mlx5_core", false, true),
+	Entry("netdevice bound to vfio-pci", "netdevice", "vfio-pci", true, true),
+	Entry("netdevice without driver", "netdevice", "", true, true),
+	Entry("vfio-pci bound to vfio-pci", "vfio-pci", "vfio-pci", false, true),
+	Entry("vfio-pci bound to a network driver", "vfio-pci", "iavf", true, true),
+	Entry("unknown device type", "", "iavf", false, false),
+)
@@ -6,11 +6,13 @@ import (
 	"flag"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -23,17 +25,25 @@ const (
 
 var (
 	collectorPriority    utils.StringListFlag
+	readerOverrides      utils.StringMapFlag
+	pfFilter             utils.StringListFlag
 	defaultPriority            = utils.StringListFlag{readerSysfs, readerNetlink}
 	sysBusPci                  = flag.String("path.sysbuspci", "/sys/bus/pci/devices", "Path to sys/bus/pci/devices/ on host")
 	sysClassNet                = flag.String("path.sysclassnet", "/sys/class/net", "Path to sys/class/net/ on host")
 	pfNameFile                 = "net"
+	pfDriverFile               = "driver"
+	numVfsFile                 = "sriov_numvfs"
 	netClassFile               = "class"
 	netClass             int64 = 0x020000
 	vfStatsSubsystem           = "vf"
+	pfSubsystem                = "pf"
 	vfStatsCollectorName       = "vfstats"
 
 	devfs fs.FS
 	netfs fs.FS
+
+	// sriovDevFlags are the flags read by the caches of the collectors reading the vf stats
+	sriovDevFlags = []string{"path.sysbuspci", "path.sysclassnet", "filter.pfs", "collector.vfstatspriority", "collector.vfstatsoverride"}
 )
 
 // vfsPCIAddr is a map of VF IDs to VF PCI addresses i.e. {"0": "0000:3b:02.0", "1": "0000:3b:02.1"}
@@ -42,60 +52,181 @@ type vfsPCIAddr map[string]string
 // init runs the registration for this collector on package import
 func init() {
 	flag.Var(&collectorPriority, "collector.vfstatspriority", "Priority of collectors")
-	register(vfStatsCollectorName, enabled, createSriovDevCollector)
+	flag.Var(&readerOverrides, "collector.vfstatsoverride",
+		"Collector to use for pfs with the given driver or PCI address, e.g. ice=sysfs,0000:3b:00.0=netlink")
+	flag.Var(&pfFilter, "filter.pfs", "Only collect vf stats for the pfs with the given interface names or PCI addresses")
+	register(vfStatsCollectorName, enabled, createSriovDevCollector, sriovDevFlags...)
 }
 
 // This is the generic collector for VF stats.
 type sriovDevCollector struct {
 	name            string
 	pfsWithNumaInfo map[string]string
+	readers         *readerCache
+	devs            *topologyCache
 }
 
 type sriovDev struct {
 	name   string
+	driver string
 	reader sriovStatReader
 	vfs    vfsPCIAddr
+	numVfs string
 }
 
 // Collect runs the appropriate collector for each SR-IOV vf on the system and publishes its statistics.
 func (c sriovDevCollector) Collect(ch chan<- prometheus.Metric) {
-	log.Printf("collecting sr-iov device metrics")
-
-	priority := collectorPriority
-	if len(priority) == 0 {
-		log.Printf("collector.priority not specified in flags, using default priority")
-		priority = defaultPriority
-	}
-
-	log.Printf("collector priority: %s", priority)
-	for pfAddr, numaNode := range c.pfsWithNumaInfo {
-		pf := getSriovDev(pfAddr, priority)
+	c.collectScrape(ch, &scrape{})
+}
 
-		if pf.reader == nil {
-			continue
-		}
+// collectScrape publishes the stats of each vf, read once per scrape
+func (c sriovDevCollector) collectScrape(ch chan<- prometheus.Metric, s *scrape) {
+	slog.Debug("collecting sr-iov device metrics", labelCollector, c.name)
+
+	for _, pf := range s.vfStats(c) {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(collectorNamespace, pfSubsystem, "stats_reader_info"),
+				"Stats reader used for the PF.",
+				[]string{labelPF, labelReader}, nil,
+			),
+			prometheus.GaugeValue,
+			1,
+			pf.name,
+			pf.reader.Name(),
+		)
 
 		for id, address := range pf.vfs {
-			stats := pf.reader.ReadStats(pf.name, id)
+			rawStats := pf.stats[id]
+			created := vfCreated.observe(pf.addr, pf.numVfs, id, address, rawStats)
+			stats, driverStats := normalizeStats(pf.driver, rawStats)
 			for name, v := range stats {
-				desc := prometheus.NewDesc(
+				desc := prometheus.V2.NewDesc(
 					prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, name),
 					fmt.Sprintf("Statistic %s.", name),
-					[]string{labelPF, labelVF, labelPCIAddr, labelNumaNode}, nil,
+					prometheus.UnconstrainedLabels{labelPF, labelVF, labelPCIAddr, labelNumaNode}, nil,
+					prometheus.WithUnit(statUnit(name)),
+				)
+
+				ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(
+					desc,
+					prometheus.CounterValue,
+					float64(v),
+					created,
+					pf.name,
+					id,
+					address,
+					pf.numaNode,
 				)
+			}
 
-				ch <- prometheus.MustNewConstMetric(
+			if *vfRawStats {
+				for name, v := range driverStats {
+					desc := prometheus.NewDesc(
+						prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, name),
+						fmt.Sprintf("Statistic %s.", name),
+						[]string{labelPF, labelVF, labelPCIAddr, labelNumaNode}, nil,
+					)
+
+					ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(
+						desc,
+						prometheus.CounterValue,
+						float64(v),
+						created,
+						pf.name,
+						id,
+						address,
+						pf.numaNode,
+					)
+				}
+			}
+
+			if !*vfDriverStats {
+				continue
+			}
+
+			for name, v := range driverStats {
+				desc := prometheus.NewDesc(
+					prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, driverStatName),
+					"Driver specific statistic without a canonical name.",
+					[]string{labelPF, labelVF, labelPCIAddr, labelNumaNode, labelStat}, nil,
+				)
+
+				ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(
 					desc,
 					prometheus.CounterValue,
 					float64(v),
+					created,
 					pf.name,
 					id,
 					address,
-					numaNode,
+					pf.numaNode,
+					name,
 				)
 			}
 		}
 	}
+
+	readerProbeFailures.collect(ch)
+}
+
+// pfStats is a pf with a stats reader and the raw stats read for each of its vfs, keyed by vf id
+type pfStats struct {
+	sriovDev
+	addr     string
+	numaNode string
+	stats    map[string]sriovStats
+}
+
+// readStats reads the raw stats of the vfs of every pf with a stats reader. The stats of a vf are empty if they could
+// not be read, and the readers of a pf returning no stats for any vf are probed again on the next read.
+func (c sriovDevCollector) readStats() []pfStats {
+	priority := collectorPriority
+	if len(priority) == 0 {
+		slog.Debug("collector.vfstatspriority not specified in flags, using default priority", labelCollector, c.name)
+		priority = defaultPriority
+	}
+
+	slog.Debug("reader priority", labelCollector, c.name, "priority", strings.Join(priority, ","))
+	pfs := make([]pfStats, 0, len(c.pfsWithNumaInfo))
+	links := &linkDump{}
+	for pfAddr, numaNode := range c.pfsWithNumaInfo {
+		pf := c.getSriovDev(pfAddr, priority, links)
+
+		if pf.reader == nil {
+			continue
+		}
+
+		stats := make(map[string]sriovStats, len(pf.vfs))
+		hasStats := false
+		for id := range pf.vfs {
+			stats[id] = pf.reader.ReadStats(pf.name, id)
+			hasStats = hasStats || len(stats[id]) > 0
+		}
+
+		if !hasStats {
+			slog.Warn("reader returned no stats for any vf, readers will be probed again", labelPF, pf.name, labelReader, pf.reader.Name())
+			c.readers.forget(pfAddr)
+			c.devs.forget(pfAddr)
+		}
+
+		pfs = append(pfs, pfStats{sriovDev: pf, addr: pfAddr, numaNode: numaNode, stats: stats})
+	}
+
+	return pfs
+}
+
+// canonicalVFStats returns the canonical stats of the vfs of the pfs, keyed by vf PCI address. The stats of a vf are
+// empty if they could not be read.
+func canonicalVFStats(pfs []pfStats) map[string]sriovStats {
+	stats := make(map[string]sriovStats)
+	for _, pf := range pfs {
+		for id, address := range pf.vfs {
+			stats[address], _ = normalizeStats(pf.driver, pf.stats[id])
+		}
+	}
+
+	return stats
 }
 
 // Describe isn't implemented for this collector
@@ -104,12 +235,14 @@ func (c sriovDevCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // sriovDevCollector is initialized with the physical functions on the host. This is not updated after initialization.
 func createSriovDevCollector() prometheus.Collector {
-	devs := getSriovDevAddrs()
+	devs := filterPFs(getSriovDevAddrs())
 	numaNodes := getNumaNodes(devs)
 
 	return sriovDevCollector{
 		name:            vfStatsCollectorName,
 		pfsWithNumaInfo: numaNodes,
+		readers:         newReaderCache(),
+		devs:            newTopologyCache(),
 	}
 }
 
@@ -119,11 +252,11 @@ func getSriovDevAddrs() []string {
 
 	devs, err := fs.Glob(devfs, "*/sriov_totalvfs")
 	if err != nil {
-		log.Printf("Invalid pattern\n%v", err) // unreachable code
+		slog.Error("invalid pattern", "err", err) // unreachable code
 	}
 
 	if len(devs) == 0 {
-		log.Printf("no sriov net devices found")
+		slog.Warn("no sriov net devices found")
 	}
 
 	for _, dev := range devs {
@@ -136,26 +269,129 @@ func getSriovDevAddrs() []string {
 	return sriovDevs
 }
 
+// filterPFs returns the PFs matching the filter.pfs flag by interface name or PCI address, or all PFs if it is not set
+func filterPFs(devs []string) []string {
+	if len(pfFilter) == 0 {
+		return devs
+	}
+
+	allowed := make(map[string]bool, len(pfFilter))
+	for _, pf := range pfFilter {
+		allowed[pf] = true
+	}
+
+	filtered := make([]string, 0, len(devs))
+	for _, dev := range devs {
+		if allowed[dev] || allowed[getPFName(dev)] {
+			filtered = append(filtered, dev)
+		} else {
+			slog.Info("pf excluded by filter", labelPF, dev)
+		}
+	}
+
+	return filtered
+}
+
 // getSriovDev returns a sriovDev record containing the physical function interface name, stats reader and initialized virtual functions.
-func getSriovDev(pfAddr string, priority []string) sriovDev {
-	name := getPFName(pfAddr)
-	vfs, err := vfList(pfAddr)
-	if err != nil {
-		log.Printf("error getting vf address\n%v", err)
+// The topology and stats reader cached for the PF are reused, readers are only probed if there is none.
+func (c sriovDevCollector) getSriovDev(pfAddr string, priority []string, links *linkDump) sriovDev {
+	topology := c.devs.get(pfAddr)
+
+	if cached, ok := c.readers.get(pfAddr); ok {
+		return sriovDev{topology.name, topology.driver, newStatsReader(cached, topology.name, links), topology.vfs, topology.numVfs}
 	}
 
-	reader, err := getStatsReader(name, priority)
+	reader, err := getStatsReader(topology.name, topology.vfs, readerPriority(pfAddr, topology.driver, priority), links)
 	if err != nil {
-		log.Printf("error getting stats reader for %s: %v", name, err)
+		slog.Warn("could not get stats reader", labelPF, topology.name, "err", err)
+	} else {
+		c.readers.set(pfAddr, reader.Name())
 	}
 
 	return sriovDev{
-		name,
+		topology.name,
+		topology.driver,
 		reader,
-		vfs,
+		topology.vfs,
+		topology.numVfs,
 	}
 }
 
+// pfTopology is the interface name, driver and virtual functions of a PF along with the sriov_numvfs value they were read at
+type pfTopology struct {
+	name   string
+	driver string
+	vfs    vfsPCIAddr
+	numVfs string
+}
+
+// topologyCache holds the topology of each PF, keyed by PF PCI address, so the filesystem is only walked again
+// when the number of VFs of a PF changes.
+type topologyCache struct {
+	mu   sync.Mutex
+	devs map[string]pfTopology
+}
+
+func newTopologyCache() *topologyCache {
+	return &topologyCache{devs: make(map[string]pfTopology)}
+}
+
+// get returns the cached topology of a PF if its sriov_numvfs is unchanged, otherwise it is read from the filesystem.
+// A topology is only cached when sriov_numvfs can be read and the PF has VFs.
+func (c *topologyCache) get(pfAddr string) pfTopology {
+	numVfs := readNumVfs(pfAddr)
+
+	c.mu.Lock()
+	cached, ok := c.devs[pfAddr]
+	c.mu.Unlock()
+
+	if ok && numVfs != "" && cached.numVfs == numVfs {
+		return cached
+	}
+
+	topology := getPFTopology(pfAddr)
+	topology.numVfs = numVfs
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if numVfs != "" && len(topology.vfs) > 0 {
+		c.devs[pfAddr] = topology
+	} else {
+		delete(c.devs, pfAddr)
+	}
+
+	return topology
+}
+
+// forget removes the topology cached for a PF so it is read from the filesystem on the next scrape
+func (c *topologyCache) forget(pfAddr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devs, pfAddr)
+}
+
+// getPFTopology reads the interface name, driver and virtual functions of a PF from the filesystem
+func getPFTopology(pfAddr string) pfTopology {
+	name := getPFName(pfAddr)
+	driver := getPFDriver(pfAddr)
+	vfs, err := vfList(pfAddr)
+	if err != nil {
+		slog.Warn("could not get vf addresses", labelPF, pfAddr, "err", err)
+	}
+
+	return pfTopology{name: name, driver: driver, vfs: vfs}
+}
+
+// readNumVfs returns the number of enabled VFs of a PF, or an empty string if it can not be read
+func readNumVfs(pfAddr string) string {
+	numVfs, err := fs.ReadFile(devfs, filepath.Join(pfAddr, numVfsFile))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(numVfs))
+}
+
 // getNumaNodes returns the numa location for each of the PFs with SR-IOV capabilities
 func getNumaNodes(devs []string) map[string]string {
 	pfNumaInfo := make(map[string]string)
@@ -164,14 +400,14 @@ func getNumaNodes(devs []string) map[string]string {
 		numaFilepath := filepath.Join(dev, "numa_node")
 		numaRaw, err := fs.ReadFile(devfs, numaFilepath)
 		if err != nil {
-			log.Printf("could not read numa_node file for device '%s'\n%v", dev, err)
+			slog.Warn("could not read numa_node file", labelPF, dev, "err", err)
 			pfNumaInfo[dev] = ""
 			continue
 		}
 
 		numaNode := strings.TrimSpace(string(numaRaw))
 		if numaNode == noNumaInfo {
-			log.Printf("no numa node information for device '%s'", dev)
+			slog.Info("no numa node information", labelPF, dev)
 			pfNumaInfo[dev] = ""
 			continue
 		}
@@ -188,7 +424,7 @@ func vfList(dev string) (vfsPCIAddr, error) {
 
 	vfs, err := fs.Glob(devfs, filepath.Join(dev, "virtfn*"))
 	if err != nil {
-		log.Printf("Invalid pattern\n%v", err) // unreachable code
+		slog.Error("invalid pattern", "err", err) // unreachable code
 	}
 
 	// Read all VF directories and add VF PCI addr to the vfList
@@ -205,12 +441,31 @@ func vfList(dev string) (vfsPCIAddr, error) {
 	return vfList, nil
 }
 
+// sortedVFIDs returns the ids of the virtual functions in ascending numeric order
+func sortedVFIDs(vfs vfsPCIAddr) []string {
+	ids := make([]string, 0, len(vfs))
+	for id := range vfs {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.Atoi(ids[i])
+		b, errB := strconv.Atoi(ids[j])
+		if errA != nil || errB != nil {
+			return ids[i] < ids[j]
+		}
+		return a < b
+	})
+
+	return ids
+}
+
 // vfData gets vf id and pci address from the path specified
 func vfData(vfDir string) (string, string) {
 	if link, err := utils.EvalSymlinks(filepath.Join(*sysBusPci, vfDir)); err == nil {
 		return filepath.Base(vfDir)[6:], filepath.Base(link)
 	} else {
-		log.Printf("error evaluating symlink '%s'\n%v", vfDir, err)
+		slog.Warn("could not evaluate vf symlink", "path", vfDir, "err", err)
 		return "", ""
 	}
 }
@@ -220,13 +475,24 @@ func getPFName(device string) string {
 	pfDevPath := filepath.Join(device, pfNameFile)
 	pfdir, err := fs.ReadDir(devfs, pfDevPath)
 	if err != nil || len(pfdir) == 0 {
-		log.Printf("%s - could not get pf interface name in path '%s'\n%v", device, pfDevPath, err)
+		slog.Warn("could not get pf interface name", labelPF, device, "path", pfDevPath, "err", err)
 		return ""
 	}
 
 	return pfdir[0].Name()
 }
 
+// getPFDriver resolves the name of the kernel driver bound to the physical function at the given PCI address.
+func getPFDriver(device string) string {
+	link, err := utils.EvalSymlinks(filepath.Join(*sysBusPci, device, pfDriverFile))
+	if err != nil {
+		slog.Warn("could not resolve pf driver", labelPF, device, "err", err)
+		return ""
+	}
+
+	return filepath.Base(link)
+}
+
 // isNetDevice checks if the device is a net device by checking its device class
 func isNetDevice(filePath string) bool {
 	file, err := fs.ReadFile(devfs, filePath)
@@ -237,24 +503,28 @@ func isNetDevice(filePath string) bool {
 	classHex := strings.TrimSpace(string(file))
 	deviceClass, err := strconv.ParseInt(classHex, 0, 64)
 	if err != nil {
-		log.Printf("could not parse class file: %v", err)
+		slog.Warn("could not parse class file", "path", filePath, "err", err)
 		return false
 	}
 
 	return deviceClass == netClass
 }
 
-func resolveSriovDevFilepaths() error {
-	if err := utils.ResolveFlag("path.sysbuspci", sysBusPci); err != nil {
+func resolveSriovDevFilepaths(apply bool) error {
+	busPci, classNet := *sysBusPci, *sysClassNet
+	if err := utils.ResolveFlag("path.sysbuspci", &busPci); err != nil {
 		return err
 	}
 
-	if err := utils.ResolveFlag("path.sysclassnet", sysClassNet); err != nil {
+	if err := utils.ResolveFlag("path.sysclassnet", &classNet); err != nil {
 		return err
 	}
 
-	devfs = os.DirFS(*sysBusPci)
-	netfs = os.DirFS(*sysClassNet)
+	if apply {
+		*sysBusPci, *sysClassNet = busPci, classNet
+		devfs = os.DirFS(busPci)
+		netfs = os.DirFS(classNet)
+	}
 
 	return nil
 }
@@ -0,0 +1,78 @@
+package collectors
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+const (
+	benchPFs      = 4
+	benchVFsPerPF = 128
+)
+
+// benchmarkCollect measures a single scrape of the vf stats collector on the benchmark host.
+// With cached set the same collector is scraped on every iteration, otherwise a new collector is created for
+// every scrape so the topology is read and readers are probed each time. The sysfs reader gains little from the
+// caches, as a scrape is dominated by reading the stats directory of each vf, which is done either way.
+func benchmarkCollect(b *testing.B, priority []string, cached bool) {
+	host := fakehost.New(fakehost.Options{PFs: benchPFs, VFsPerPF: benchVFsPerPF, SysfsStats: true})
+	devfs = host.DevFS()
+	netfs = host.NetFS()
+	collectorPriority = priority
+	evalSymlinksOrig := utils.EvalSymlinks
+	utils.EvalSymlinks = host.EvalSymlinks
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	links := host.Netlink()
+	vfstats.ListLinks = links.LinkList
+	b.Cleanup(func() {
+		vfstats.ListLinks = netlink.LinkList
+		utils.EvalSymlinks = evalSymlinksOrig
+		slog.SetDefault(defaultLogger)
+	})
+
+	collector := createSriovDevCollector()
+	scrape := func() {
+		ch := make(chan prometheus.Metric, 1024)
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+			}
+			close(done)
+		}()
+		collector.Collect(ch)
+		close(ch)
+		<-done
+	}
+
+	scrape()
+	dumps := links.Dumps()
+
+	b.ResetTimer()
+	for range b.N {
+		if !cached {
+			collector = createSriovDevCollector()
+		}
+		scrape()
+	}
+
+	b.ReportMetric(float64(links.Dumps()-dumps)/float64(b.N), "dumps/scrape")
+}
+
+func BenchmarkCollectSysfs512VFs(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) { benchmarkCollect(b, []string{readerSysfs}, false) })
+	b.Run("cached", func(b *testing.B) { benchmarkCollect(b, []string{readerSysfs}, true) })
+}
+
+func BenchmarkCollectNetlink512VFs(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) { benchmarkCollect(b, []string{readerNetlink}, false) })
+	b.Run("cached", func(b *testing.B) { benchmarkCollect(b, []string{readerNetlink}, true) })
+}
@@ -0,0 +1,77 @@
+package collectors
+
+// sriovdev_created tracks when the counters of each VF started, so the VF stats carry a created timestamp that
+// changes when a VF is re-created and its counters restart from zero.
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	vfCreated = newCreatedTracker()
+
+	// now returns the current time, replaced in tests
+	now = time.Now
+)
+
+// vfKey identifies a VF by the PCI address of its PF and its id
+type vfKey struct {
+	pfAddr string
+	vf     string
+}
+
+// vfCounters is the created time of the counters of a VF and the values they had at the last scrape
+type vfCounters struct {
+	addr    string
+	created time.Time
+	stats   sriovStats
+}
+
+// createdTracker holds the counters of each VF seen so far, with the sriov_numvfs of their PF
+type createdTracker struct {
+	mu     sync.Mutex
+	numVfs map[string]string
+	vfs    map[vfKey]vfCounters
+}
+
+func newCreatedTracker() *createdTracker {
+	return &createdTracker{numVfs: make(map[string]string), vfs: make(map[vfKey]vfCounters)}
+}
+
+// observe records the stats of a VF and returns the time its counters started. The time is reset to now when the VF
+// is first seen, when the sriov_numvfs of its PF changed, when its PCI address changed or when a counter decreased.
+func (t *createdTracker) observe(pfAddr, numVfs, vf, addr string, stats sriovStats) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if previous, ok := t.numVfs[pfAddr]; ok && previous != numVfs {
+		for key := range t.vfs {
+			if key.pfAddr == pfAddr {
+				delete(t.vfs, key)
+			}
+		}
+	}
+	t.numVfs[pfAddr] = numVfs
+
+	key := vfKey{pfAddr, vf}
+	counters, ok := t.vfs[key]
+	if !ok || counters.addr != addr || decreased(counters.stats, stats) {
+		counters = vfCounters{addr: addr, created: now()}
+	}
+	counters.stats = stats
+	t.vfs[key] = counters
+
+	return counters.created
+}
+
+// decreased returns true if any stat is lower than before
+func decreased(before, after sriovStats) bool {
+	for name, value := range after {
+		if previous, ok := before[name]; ok && value < previous {
+			return true
+		}
+	}
+
+	return false
+}
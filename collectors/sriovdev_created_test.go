@@ -0,0 +1,125 @@
+package collectors
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+// fakeClock replaces now with a clock that only moves when advanced
+func fakeClock() func(time.Duration) {
+	t := time.Unix(1000, 0)
+	now = func() time.Time { return t }
+	DeferCleanup(func() { now = time.Now })
+
+	return func(d time.Duration) { t = t.Add(d) }
+}
+
+// reset forgets all VFs
+func (t *createdTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.numVfs = make(map[string]string)
+	t.vfs = make(map[vfKey]vfCounters)
+}
+
+var _ = Describe("test tracking vf created timestamps", func() { // createdTracker.observe
+	var (
+		tracker *createdTracker
+		advance func(time.Duration)
+	)
+
+	BeforeEach(func() {
+		tracker = newCreatedTracker()
+		advance = fakeClock()
+	})
+
+	It("keeps the created time while the counters increase", func() {
+		created := tracker.observe("0000:3b:00.0", "2", "0", "0000:3b:02.0", sriovStats{"rx_packets": 1})
+		Expect(created).To(Equal(time.Unix(1000, 0)))
+
+		advance(time.Minute)
+		Expect(tracker.observe("0000:3b:00.0", "2", "0", "0000:3b:02.0", sriovStats{"rx_packets": 5})).To(Equal(created))
+	})
+
+	DescribeTable("resets the created time",
+		func(numVfs, addr string, stats sriovStats) {
+			tracker.observe("0000:3b:00.0", "2", "0", "0000:3b:02.0", sriovStats{"rx_packets": 10, "tx_packets": 10})
+			tracker.observe("0000:3b:00.0", "2", "1", "0000:3b:02.1", sriovStats{"rx_packets": 10})
+
+			advance(time.Minute)
+			Expect(tracker.observe("0000:3b:00.0", numVfs, "0", addr, stats)).To(Equal(time.Unix(1060, 0)))
+		},
+		Entry("when a counter decreases", "2", "0000:3b:02.0", sriovStats{"rx_packets": 11, "tx_packets": 2}),
+		Entry("when the number of vfs changes", "4", "0000:3b:02.0", sriovStats{"rx_packets": 11, "tx_packets": 11}),
+		Entry("when the pci address changes", "2", "0000:3b:0a.0", sriovStats{"rx_packets": 11, "tx_packets": 11}),
+	)
+
+	It("resets all vfs of a pf when the number of vfs changes", func() {
+		tracker.observe("0000:3b:00.0", "2", "1", "0000:3b:02.1", sriovStats{"rx_packets": 10})
+		tracker.observe("0000:5e:00.0", "2", "1", "0000:5e:02.1", sriovStats{"rx_packets": 10})
+
+		advance(time.Minute)
+		tracker.observe("0000:3b:00.0", "0", "0", "0000:3b:02.0", sriovStats{})
+
+		Expect(tracker.observe("0000:3b:00.0", "0", "1", "0000:3b:02.1", sriovStats{"rx_packets": 20})).To(Equal(time.Unix(1060, 0)))
+		Expect(tracker.observe("0000:5e:00.0", "2", "1", "0000:5e:02.1", sriovStats{"rx_packets": 20})).To(Equal(time.Unix(1000, 0)))
+	})
+})
+
+var _ = Describe("test publishing vf counters", func() { // sriovDevCollector.Collect
+	It("declares units and created timestamps", func() {
+		advance := fakeClock()
+		vfCreated.reset()
+		DeferCleanup(vfCreated.reset)
+
+		host := fakehost.New(fakehost.Options{PFs: 1, VFsPerPF: 1, SysfsStats: true})
+		devfs, netfs = host.DevFS(), host.NetFS()
+		collectorPriority = []string{readerSysfs}
+		utils.EvalSymlinks = host.EvalSymlinks
+		DeferCleanup(func() {
+			utils.EvalSymlinks = evalSymlinks
+		})
+
+		registry := prometheus.NewPedanticRegistry()
+		Expect(registry.Register(SriovCollector{createSriovDevCollector()})).To(Succeed())
+
+		gather := func() map[string]*dto.MetricFamily {
+			families, err := registry.Gather()
+			Expect(err).ToNot(HaveOccurred())
+
+			byName := make(map[string]*dto.MetricFamily, len(families))
+			for _, family := range families {
+				byName[family.GetName()] = family
+			}
+			return byName
+		}
+
+		families := gather()
+		Expect(families["sriov_vf_tx_bytes"].GetUnit()).To(Equal("bytes"))
+		Expect(families["sriov_vf_rx_packets"].GetUnit()).To(Equal("packets"))
+		Expect(families["sriov_vf_rx_dropped"].GetUnit()).To(BeEmpty())
+		created := families["sriov_vf_rx_packets"].GetMetric()[0].GetCounter().GetCreatedTimestamp().AsTime()
+		Expect(created).To(Equal(time.Unix(1000, 0).UTC()))
+
+		advance(time.Minute)
+		families = gather()
+		Expect(families["sriov_vf_rx_packets"].GetMetric()[0].GetCounter().GetCreatedTimestamp().AsTime()).To(Equal(created))
+	})
+})
+
+var _ = DescribeTable("test stat units", // statUnit
+	func(stat, expected string) {
+		Expect(statUnit(stat)).To(Equal(expected))
+	},
+	Entry("bytes", "tx_bytes", "bytes"),
+	Entry("packets", "rx_packets", "packets"),
+	Entry("no unit suffix", "rx_dropped", ""),
+)
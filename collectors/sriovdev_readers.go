@@ -5,23 +5,93 @@ package collectors
 import (
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
 
-	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
 	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
 )
 
 const sriovVFStatsDir = "%s/device/sriov/%s/stats"
 
+// Reasons for a stats reader being rejected for a PF
+const (
+	probeReasonNotSupported  = "not_supported"
+	probeReasonNoStats       = "no_stats"
+	probeReasonUnknownReader = "unknown_reader"
+)
+
+var (
+	// knownReaders is the set of stats readers that can be selected through the priority and override flags
+	knownReaders = map[string]bool{readerSysfs: true, readerNetlink: true}
+
+	readerProbeFailures = newProbeFailureCounter()
+
+	readerProbeFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, pfSubsystem, "stats_reader_probe_failures_total"),
+		"Number of times a stats reader was rejected for a PF.",
+		[]string{labelPF, labelReader, labelReason}, nil,
+	)
+)
+
+// probeFailure identifies a stats reader rejected for a PF
+type probeFailure struct {
+	pf     string
+	reader string
+	reason string
+}
+
+// probeFailureCounter counts the stats readers rejected for each PF while selecting a reader.
+// The counts are copied before publishing so a slow consumer of the metrics channel never holds the lock.
+type probeFailureCounter struct {
+	mu     sync.Mutex
+	counts map[probeFailure]float64
+}
+
+func newProbeFailureCounter() *probeFailureCounter {
+	return &probeFailureCounter{counts: make(map[probeFailure]float64)}
+}
+
+// inc increments the failure count for a reader on a PF
+func (c *probeFailureCounter) inc(pf, reader, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[probeFailure{pf, reader, reason}]++
+}
+
+// collect publishes the failure counts to the prometheus channel
+func (c *probeFailureCounter) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	counts := make(map[probeFailure]float64, len(c.counts))
+	for failure, count := range c.counts {
+		counts[failure] = count
+	}
+	c.mu.Unlock()
+
+	for failure, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			readerProbeFailuresDesc,
+			prometheus.CounterValue,
+			count,
+			failure.pf,
+			failure.reader,
+			failure.reason,
+		)
+	}
+}
+
 type sriovStats map[string]int64
 
 // sriovStatReader is an interface which takes in the Physical Function name and vf id and returns the stats for the VF
 type sriovStatReader interface {
 	ReadStats(vfID string, pfName string) sriovStats
+	Name() string
 }
 
 // netlinkReader is able to read stats from drivers that support the netlink interface
@@ -35,62 +105,164 @@ type sysfsReader struct {
 	statsFS string
 }
 
-// check if a reader can read stats for a given pf and vfID
-func readerHasStats(reader sriovStatReader, pfName, vfID string) bool {
-	stats := reader.ReadStats(pfName, vfID)
-	return len(stats) > 0
+// readerCache holds the name of the stats reader selected for each PF, keyed by PF PCI address.
+// Readers are only probed when a PF has no cached reader, i.e. when it is first seen or its reader stopped returning stats.
+type readerCache struct {
+	mu      sync.Mutex
+	readers map[string]string
+}
+
+func newReaderCache() *readerCache {
+	return &readerCache{readers: make(map[string]string)}
+}
+
+// get returns the name of the reader cached for a PF
+func (c *readerCache) get(pfAddr string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reader, ok := c.readers[pfAddr]
+	return reader, ok
+}
+
+// set caches the name of the reader selected for a PF
+func (c *readerCache) set(pfAddr, reader string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers[pfAddr] = reader
+}
+
+// forget removes the reader cached for a PF so readers are probed again on the next scrape
+func (c *readerCache) forget(pfAddr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.readers, pfAddr)
+}
+
+// linkDump lazily performs a single netlink link dump and shares it between all PFs read during a scrape
+type linkDump struct {
+	once  sync.Once
+	links map[string]vfstats.PerPF
+}
+
+// get returns the netlink vf stats for a PF and whether the PF was found in the dump
+func (d *linkDump) get(pf string) (vfstats.PerPF, bool) {
+	d.once.Do(func() {
+		d.links = vfstats.Dump()
+	})
+
+	perPF, ok := d.links[pf]
+	if !ok {
+		return vfstats.PerPF{Pf: pf, Vfs: make(map[int]netlink.VfInfo)}, false
+	}
+	return perPF, true
+}
+
+// newStatsReader returns a reader of the given type for a PF, or nil if the reader type is unknown
+func newStatsReader(name, pf string, links *linkDump) sriovStatReader {
+	switch name {
+	case readerSysfs:
+		return sysfsReader{*sysClassNet + "/%s/device/sriov/%s/stats"}
+	case readerNetlink:
+		perPF, _ := links.get(pf)
+		return netlinkReader{perPF}
+	default:
+		return nil
+	}
+}
+
+// probeReader returns the id of the first vf, in ascending order, the reader can read stats for
+func probeReader(reader sriovStatReader, pfName string, vfs vfsPCIAddr) (string, bool) {
+	for _, id := range sortedVFIDs(vfs) {
+		if len(reader.ReadStats(pfName, id)) > 0 {
+			return id, true
+		}
+	}
+	return "", false
 }
 
 // getStatsReader returns the correct stat reader for the given PF
 // Currently only drivers that implement netlink or the sriov sysfs interface are supported
-func getStatsReader(pf string, priority []string) (sriovStatReader, error) {
-	// Try to find a collector that can actually read stats for at least VF 0
-	vfTestID := "0"
+func getStatsReader(pf string, vfs vfsPCIAddr, priority []string, links *linkDump) (sriovStatReader, error) {
+	// Try to find a collector that can actually read stats for at least one of the PF's VFs
 	for _, collector := range priority {
 		switch collector {
 		case readerSysfs:
 			sriovPath := pf + "/device/sriov"
-			if _, err := fs.Stat(netfs, sriovPath); !os.IsNotExist(err) {
-				reader := sysfsReader{*sysClassNet + "/%s/device/sriov/%s/stats"}
-				// Test if sysfsReader can read stats for VF 0
-				if readerHasStats(reader, pf, vfTestID) {
-					log.Printf("%s - using %s collector", pf, readerSysfs)
-					return reader, nil
-				} else {
-					log.Printf("%s - %s collector present but no stats found for vf%s", pf, readerSysfs, vfTestID)
-				}
-			} else {
-				log.Printf("%s does not support %s collector, directory '%s' does not exist", pf, readerSysfs, sriovPath)
+			if _, err := fs.Stat(netfs, sriovPath); os.IsNotExist(err) {
+				slog.Debug("pf does not support reader, directory does not exist", labelPF, pf, labelReader, readerSysfs, "path", sriovPath)
+				readerProbeFailures.inc(pf, readerSysfs, probeReasonNotSupported)
+				continue
 			}
 		case readerNetlink:
-			if vfstats.DoesPfSupportNetlink(pf) {
-				reader := netlinkReader{vfstats.VfStats(pf)}
-				// Test if netlinkReader can read stats for VF 0
-				if readerHasStats(reader, pf, vfTestID) {
-					log.Printf("%s - using %s collector", pf, readerNetlink)
-					return reader, nil
-				} else {
-					log.Printf("%s - %s collector present but no stats found for vf%s", pf, readerNetlink, vfTestID)
-				}
-			} else {
-				log.Printf("%s does not support %s collector", pf, readerNetlink)
+			if _, ok := links.get(pf); !ok {
+				slog.Debug("pf does not support reader", labelPF, pf, labelReader, readerNetlink)
+				readerProbeFailures.inc(pf, readerNetlink, probeReasonNotSupported)
+				continue
 			}
 		default:
-			log.Printf("%s - '%s' collector not supported", pf, collector)
+			slog.Warn("unknown reader", labelPF, pf, labelReader, collector)
+			readerProbeFailures.inc(pf, collector, probeReasonUnknownReader)
+			continue
 		}
+
+		reader := newStatsReader(collector, pf, links)
+		if vfID, ok := probeReader(reader, pf, vfs); ok {
+			slog.Info("using reader, stats found for vf", labelPF, pf, labelReader, collector, labelVF, vfID)
+			return reader, nil
+		}
+
+		slog.Info("reader present but no stats found for any vf", labelPF, pf, labelReader, collector)
+		readerProbeFailures.inc(pf, collector, probeReasonNoStats)
 	}
 	return nil, fmt.Errorf("no stats reader found for %s", pf)
 }
 
+// readerPriority returns the reader priority for a PF. A reader override for the PF's PCI address takes precedence
+// over an override for its driver, and both replace the global priority with the single overriding reader.
+func readerPriority(pfAddr, driver string, priority []string) []string {
+	if reader, ok := readerOverrides[pfAddr]; ok {
+		slog.Debug("using reader override for pf", labelPF, pfAddr, labelReader, reader)
+		return []string{reader}
+	}
+
+	if reader, ok := readerOverrides[driver]; ok && driver != "" {
+		slog.Debug("using reader override for driver", labelPF, pfAddr, labelReader, reader, "driver", driver)
+		return []string{reader}
+	}
+
+	return priority
+}
+
+// VerifyReaders checks that the readers named in the priority and override flags are known stats readers
+func VerifyReaders() error {
+	for _, reader := range collectorPriority {
+		if !knownReaders[reader] {
+			return fmt.Errorf("collector.vfstatspriority - unknown stats reader '%s'", reader)
+		}
+	}
+
+	for key, reader := range readerOverrides {
+		if !knownReaders[reader] {
+			return fmt.Errorf("collector.vfstatsoverride - unknown stats reader '%s' for '%s'", reader, key)
+		}
+	}
+
+	return nil
+}
+
 // ReadStats takes in the name of a PF and the VF Id and returns a stats object.
 func (r netlinkReader) ReadStats(pfName, vfID string) sriovStats {
 	id, err := strconv.Atoi(vfID)
 	if err != nil {
-		log.Print("error reading passed virtual function id")
+		slog.Warn("could not parse vf id", labelPF, pfName, labelVF, vfID, labelReader, readerNetlink, "err", err)
+		return sriovStats{}
+	}
+
+	vf, ok := r.data.Vfs[id]
+	if !ok {
 		return sriovStats{}
 	}
 
-	vf := r.data.Vfs[id]
 	//nolint:gosec // G115: Values are network stats unlikely to overflow int64
 	return map[string]int64{
 		"tx_bytes":     int64(vf.TxBytes),
@@ -104,35 +276,41 @@ func (r netlinkReader) ReadStats(pfName, vfID string) sriovStats {
 	}
 }
 
+// Name returns the name of the netlink reader
+func (r netlinkReader) Name() string {
+	return readerNetlink
+}
+
 func (r sysfsReader) ReadStats(pfName, vfID string) sriovStats {
 	stats := make(sriovStats, 0)
 
 	statDir := fmt.Sprintf(sriovVFStatsDir, pfName, vfID)
 	files, err := fs.ReadDir(netfs, statDir)
 	if err != nil {
-		log.Printf("error reading stats for %s vf%s\n%v", pfName, vfID, err)
+		slog.Warn("could not read vf stats", labelPF, pfName, labelVF, vfID, labelReader, readerSysfs, "err", err)
 		return stats
 	}
 
-	log.Printf("getting stats for %s vf%s", pfName, vfID)
+	slog.Debug("getting vf stats", labelPF, pfName, labelVF, vfID, labelReader, readerSysfs)
 
 	for _, f := range files {
 		path := filepath.Join(statDir, f.Name())
-		if utils.IsSymLink(netfs, path) {
-			log.Printf("could not stat file '%s'", path)
+		// The type of the entries is already read, so the directory is not read again for each stat file
+		if f.Type()&fs.ModeSymlink != 0 {
+			slog.Warn("skipping symlinked stat file", labelPF, pfName, labelVF, vfID, "path", path)
 			continue
 		}
 
 		statRaw, err := fs.ReadFile(netfs, path)
 		if err != nil {
-			log.Printf("error reading file, %v", err)
+			slog.Warn("could not read stat file", labelPF, pfName, labelVF, vfID, "err", err)
 			continue
 		}
 
 		statString := strings.TrimSpace(string(statRaw))
 		value, err := strconv.ParseInt(statString, 10, 64)
 		if err != nil {
-			log.Printf("%s - error parsing integer from value '%s'\n%v", f.Name(), statString, err)
+			slog.Warn("could not parse stat value", labelPF, pfName, labelVF, vfID, labelStat, f.Name(), "value", statString, "err", err)
 			continue
 		}
 
@@ -141,3 +319,8 @@ func (r sysfsReader) ReadStats(pfName, vfID string) sriovStats {
 
 	return stats
 }
+
+// Name returns the name of the sysfs reader
+func (r sysfsReader) Name() string {
+	return readerSysfs
+}
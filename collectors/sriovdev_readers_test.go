@@ -1,30 +1,56 @@
 package collectors
 
 import (
+	"fmt"
 	"io/fs"
 	"testing/fstest"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/vishvananda/netlink"
 
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
 	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
 )
 
+// probeFailuresFor returns the stats reader probe failures counted for a PF
+func probeFailuresFor(pf string) map[probeFailure]float64 {
+	readerProbeFailures.mu.Lock()
+	defer readerProbeFailures.mu.Unlock()
+
+	failures := make(map[probeFailure]float64)
+	for failure, count := range readerProbeFailures.counts {
+		if failure.pf == pf {
+			failures[failure] = count
+		}
+	}
+	return failures
+}
+
+// reset clears all failure counts
+func (c *probeFailureCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = make(map[probeFailure]float64)
+}
+
 var _ = DescribeTable("test getting stats reader for pf", // getStatsReader
-	func(pf string, priority []string, fsys fs.FS, link netlink.Link, expected sriovStatReader, logs ...string) {
+	func(pf string, priority []string, fsys fs.FS, link netlink.Link, expected sriovStatReader,
+		failures map[probeFailure]float64, logs ...string) {
 		netfs = fsys
 
 		if link != nil {
-			vfstats.GetLink = func(name string) (netlink.Link, error) {
-				return link, nil
+			vfstats.ListLinks = func() ([]netlink.Link, error) {
+				return []netlink.Link{link}, nil
 			}
 			DeferCleanup(func() {
-				vfstats.GetLink = netlink.LinkByName
+				vfstats.ListLinks = netlink.LinkList
 			})
 		}
 
-		statsReader, err := getStatsReader(pf, priority)
+		statsReader, err := getStatsReader(pf, vfsPCIAddr{"0": "0000:3b:02.0", "1": "0000:3b:02.1"}, priority, &linkDump{})
 
 		if expected != nil {
 			Expect(statsReader).To(Equal(expected))
@@ -34,6 +60,8 @@ var _ = DescribeTable("test getting stats reader for pf", // getStatsReader
 			Expect(err).To(HaveOccurred())
 		}
 
+		Expect(probeFailuresFor(pf)).To(Equal(failures))
+
 		assertLogs(logs)
 	},
 	Entry("with sysfs support",
@@ -45,22 +73,25 @@ var _ = DescribeTable("test getting stats reader for pf", // getStatsReader
 		},
 		nil,
 		sysfsReader{"/sys/class/net/%s/device/sriov/%s/stats"},
-		"ens785f0 - using sysfs collector"),
+		map[probeFailure]float64{},
+		"using reader, stats found for vf pf=ens785f0 reader=sysfs vf=0"),
 	Entry("without sysfs support",
 		"ens785f0",
 		[]string{"sysfs", "netlink"},
 		fstest.MapFS{},
-		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{}}}, //nolint:govet
-		netlinkReader{vfstats.VfStats("ens785f0")},
-		"ens785f0 does not support sysfs collector",
-		"ens785f0 - using netlink collector"),
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens785f0", Vfs: []netlink.VfInfo{{ID: 0}}}},
+		netlinkReader{vfstats.PerPF{Pf: "ens785f0", Vfs: map[int]netlink.VfInfo{0: {ID: 0}}}},
+		map[probeFailure]float64{{"ens785f0", "sysfs", "not_supported"}: 1},
+		"pf does not support reader, directory does not exist pf=ens785f0 reader=sysfs",
+		"using reader, stats found for vf pf=ens785f0 reader=netlink vf=0"),
 	Entry("without any collector support",
 		"ens785f0",
 		[]string{"unsupported_collector"},
 		fstest.MapFS{},
 		nil,
 		nil,
-		"ens785f0 - 'unsupported_collector' collector not supported"),
+		map[probeFailure]float64{{"ens785f0", "unsupported_collector", "unknown_reader"}: 1},
+		"unknown reader pf=ens785f0 reader=unsupported_collector"),
 	Entry("sysfs present but returns no stats, fallback to netlink",
 		"ens785f0",
 		[]string{"sysfs", "netlink"},
@@ -69,10 +100,30 @@ var _ = DescribeTable("test getting stats reader for pf", // getStatsReader
 			// sysfs stats file exists but is empty (simulates no stats)
 			"ens785f0/device/sriov/0/stats/rx_packets": {Data: []byte("")},
 		},
-		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{{ID: 0, TxPackets: 42}}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens785f0", Vfs: []netlink.VfInfo{{ID: 0, TxPackets: 42}}}},
 		netlinkReader{vfstats.PerPF{Pf: "ens785f0", Vfs: map[int]netlink.VfInfo{0: {ID: 0, TxPackets: 42}}}},
-		"ens785f0 - sysfs collector present but no stats found for vf0",
-		"ens785f0 - using netlink collector"),
+		map[probeFailure]float64{{"ens785f0", "sysfs", "no_stats"}: 1},
+		"reader present but no stats found for any vf pf=ens785f0 reader=sysfs",
+		"using reader, stats found for vf pf=ens785f0 reader=netlink vf=0"),
+	Entry("sysfs has no stats for vf 0, probed with vf 1",
+		"ens785f0",
+		[]string{"sysfs", "netlink"},
+		fstest.MapFS{
+			"ens785f0/device/sriov":                    {Mode: fs.ModeDir},
+			"ens785f0/device/sriov/1/stats/rx_packets": {Data: []byte("7")},
+		},
+		nil,
+		sysfsReader{"/sys/class/net/%s/device/sriov/%s/stats"},
+		map[probeFailure]float64{},
+		"using reader, stats found for vf pf=ens785f0 reader=sysfs vf=1"),
+	Entry("netlink has no stats for any vf",
+		"ens785f0",
+		[]string{"netlink"},
+		fstest.MapFS{},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens785f0", Vfs: []netlink.VfInfo{{ID: 4}}}},
+		nil,
+		map[probeFailure]float64{{"ens785f0", "netlink", "no_stats"}: 1},
+		"reader present but no stats found for any vf pf=ens785f0 reader=netlink"),
 )
 
 var _ = DescribeTable("test getting reading stats through sriov sysfs interface", // sysfsReader.ReadStats
@@ -98,13 +149,13 @@ var _ = DescribeTable("test getting reading stats through sriov sysfs interface"
 			"rx_bytes":   24,
 			"tx_packets": 12,
 			"tx_bytes":   48},
-		"getting stats for ens785f0 vf0"),
+		"getting vf stats pf=ens785f0 vf=0 reader=sysfs"),
 	Entry("without stats files",
 		"ens785f0",
 		"0",
 		fstest.MapFS{},
 		map[string]int64{},
-		"error reading stats for ens785f0 vf0",
+		"could not read vf stats pf=ens785f0 vf=0 reader=sysfs",
 		"open ens785f0/device/sriov/0/stats: file does not exist"),
 	Entry("with stat file as a symlink",
 		"ens785f0",
@@ -112,23 +163,97 @@ var _ = DescribeTable("test getting reading stats through sriov sysfs interface"
 		fstest.MapFS{
 			"ens785f0/device/sriov/0/stats/rx_packets": {Mode: fs.ModeSymlink}},
 		map[string]int64{},
-		"getting stats for ens785f0 vf0",
-		"could not stat file 'ens785f0/device/sriov/0/stats/rx_packets'"),
+		"getting vf stats pf=ens785f0 vf=0 reader=sysfs",
+		"skipping symlinked stat file pf=ens785f0 vf=0 path=ens785f0/device/sriov/0/stats/rx_packets"),
 	Entry("with stat file as a directory",
 		"ens785f0",
 		"0",
 		fstest.MapFS{
 			"ens785f0/device/sriov/0/stats/rx_packets": {Mode: fs.ModeDir}},
 		map[string]int64{},
-		"getting stats for ens785f0 vf0",
-		"error reading file, read ens785f0/device/sriov/0/stats/rx_packets: invalid argument"),
+		"getting vf stats pf=ens785f0 vf=0 reader=sysfs",
+		"could not read stat file pf=ens785f0 vf=0 err=read ens785f0/device/sriov/0/stats/rx_packets: invalid argument"),
 	Entry("with invalid stat file",
 		"ens785f0",
 		"0",
 		fstest.MapFS{
 			"ens785f0/device/sriov/0/stats/rx_packets": {Data: []byte("NaN")}},
 		map[string]int64{},
-		"getting stats for ens785f0 vf0",
-		"rx_packets - error parsing integer from value 'NaN'",
+		"getting vf stats pf=ens785f0 vf=0 reader=sysfs",
+		"could not parse stat value pf=ens785f0 vf=0 stat=rx_packets value=NaN",
 		"strconv.ParseInt: parsing \"NaN\": invalid syntax"),
 )
+
+var _ = Describe("test publishing stats reader probe failures", func() { // probeFailureCounter.collect
+	It("publishes a counter per pf, reader and reason", func() {
+		counter := newProbeFailureCounter()
+		counter.inc("ens785f0", "sysfs", "no_stats")
+		counter.inc("ens785f0", "sysfs", "no_stats")
+
+		ch := make(chan prometheus.Metric, 1)
+		counter.collect(ch)
+
+		m := dto.Metric{}
+		Expect((<-ch).Write(&m)).To(Succeed())
+		Expect(m.GetCounter().GetValue()).To(Equal(2.0))
+
+		labels := make(map[string]string, len(m.Label))
+		for _, label := range m.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		Expect(labels).To(Equal(map[string]string{"pf": "ens785f0", "reader": "sysfs", "reason": "no_stats"}))
+	})
+})
+
+var _ = DescribeTable("test getting reader priority for pf", // readerPriority
+	func(pfAddr, driver string, overrides utils.StringMapFlag, expected []string, logs ...string) {
+		readerOverrides = overrides
+		DeferCleanup(func() {
+			readerOverrides = nil
+		})
+
+		Expect(readerPriority(pfAddr, driver, []string{"sysfs", "netlink"})).To(Equal(expected))
+
+		assertLogs(logs)
+	},
+	Entry("without overrides",
+		"0000:3b:00.0", "ice", nil, []string{"sysfs", "netlink"}),
+	Entry("with driver override",
+		"0000:3b:00.0", "mlx5_core", utils.StringMapFlag{"mlx5_core": "netlink"}, []string{"netlink"},
+		"using reader override for driver pf=0000:3b:00.0 reader=netlink driver=mlx5_core"),
+	Entry("with pci address override taking precedence over driver override",
+		"0000:3b:00.0", "ice", utils.StringMapFlag{"ice": "sysfs", "0000:3b:00.0": "netlink"}, []string{"netlink"},
+		"using reader override for pf pf=0000:3b:00.0 reader=netlink"),
+	Entry("with override for another pf",
+		"0000:3b:00.0", "ice", utils.StringMapFlag{"0000:3b:00.1": "netlink"}, []string{"sysfs", "netlink"}),
+	Entry("with unknown driver",
+		"0000:3b:00.0", "", utils.StringMapFlag{"ice": "netlink"}, []string{"sysfs", "netlink"}),
+)
+
+var _ = DescribeTable("test verifying stats reader flags", // VerifyReaders
+	func(priority utils.StringListFlag, overrides utils.StringMapFlag, expectedErr error) {
+		collectorPriority = priority
+		readerOverrides = overrides
+		DeferCleanup(func() {
+			collectorPriority = nil
+			readerOverrides = nil
+		})
+
+		err := VerifyReaders()
+		if expectedErr != nil {
+			Expect(err).To(MatchError(expectedErr.Error()))
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
+	},
+	Entry("known readers",
+		utils.StringListFlag{"sysfs", "netlink"}, utils.StringMapFlag{"ice": "sysfs", "0000:3b:00.0": "netlink"}, nil),
+	Entry("no flags set",
+		nil, nil, nil),
+	Entry("unknown reader in priority",
+		utils.StringListFlag{"sysfs", "ethtool"}, nil,
+		fmt.Errorf("collector.vfstatspriority - unknown stats reader 'ethtool'")),
+	Entry("unknown reader in override",
+		nil, utils.StringMapFlag{"0000:3b:00.0": "ethtool"},
+		fmt.Errorf("collector.vfstatsoverride - unknown stats reader 'ethtool' for '0000:3b:00.0'")),
+)
@@ -0,0 +1,148 @@
+package collectors
+
+// sriovdev_stats normalizes the stat names returned by the stats readers to a canonical schema so that
+// the same VF is published under the same metric names regardless of driver, driver version or reader.
+
+import (
+	"flag"
+	"sort"
+	"strings"
+)
+
+const driverStatName = "driver_stat"
+
+var (
+	vfRawStats = flag.Bool("collector.vfrawstats", true,
+		"Publishes vf stats that have no canonical name as sriov_vf_<stat>, as before the stat names were normalized. "+
+			"Will be disabled by default in a future release, use collector.vfdriverstats instead")
+	vfDriverStats = flag.Bool("collector.vfdriverstats", false,
+		"Publishes vf stats that have no canonical name under sriov_vf_driver_stat")
+
+	// canonicalStats is the set of vf stats published as individual sriov_vf_<stat> metrics
+	canonicalStats = map[string]bool{
+		"rx_bytes":     true,
+		"tx_bytes":     true,
+		"rx_packets":   true,
+		"tx_packets":   true,
+		"rx_dropped":   true,
+		"tx_dropped":   true,
+		"rx_errors":    true,
+		"tx_errors":    true,
+		"rx_broadcast": true,
+		"tx_broadcast": true,
+		"rx_multicast": true,
+		"tx_multicast": true,
+	}
+
+	// commonStatAliases maps stat names used by more than one driver to their canonical name. Where a vf reports
+	// several aliases of a canonical stat, the first one in the table is published.
+	commonStatAliases = []statAlias{
+		{"rx_bcast", "rx_broadcast"},
+		{"tx_bcast", "tx_broadcast"},
+		{"rx_mcast", "rx_multicast"},
+		{"tx_mcast", "tx_multicast"},
+		{"rx_drop", "rx_dropped"},
+		{"tx_drop", "tx_dropped"},
+		{"rx_discards", "rx_dropped"},
+		{"tx_discards", "tx_dropped"},
+	}
+
+	// driverStatAliases maps driver specific stat names to their canonical name, taking precedence over commonStatAliases
+	driverStatAliases = map[string][]statAlias{
+		"i40e": {
+			{"rx_mcast_packets", "rx_multicast"},
+			{"rx_bcast_packets", "rx_broadcast"},
+		},
+		"ice": {
+			{"rx_mcast_packets", "rx_multicast"},
+			{"rx_bcast_packets", "rx_broadcast"},
+			{"tx_mcast_packets", "tx_multicast"},
+			{"tx_bcast_packets", "tx_broadcast"},
+		},
+		"mlx5_core": {
+			{"broadcast", "rx_broadcast"},
+			{"multicast", "rx_multicast"},
+		},
+	}
+)
+
+// statAlias maps a stat name reported by a driver to its canonical name
+type statAlias struct {
+	stat      string
+	canonical string
+}
+
+// CanonicalStats returns the names of the vf stats published as individual sriov_vf_<stat> metrics
+func CanonicalStats() []string {
+	stats := make([]string, 0, len(canonicalStats))
+	for stat := range canonicalStats {
+		stats = append(stats, stat)
+	}
+	sort.Strings(stats)
+
+	return stats
+}
+
+// canonicalStatName returns the canonical name of a stat reported for a vf of the given driver,
+// or an empty string if the stat has no canonical name
+func canonicalStatName(driver, stat string) string {
+	name, _ := canonicalStat(driver, stat)
+	return name
+}
+
+// canonicalStat returns the canonical name of a stat and its priority among the stats with the same canonical name,
+// lower first: the canonical name itself, then the driver aliases and the common aliases in table order
+func canonicalStat(driver, stat string) (string, int) {
+	priority := 1
+	for _, aliases := range [][]statAlias{driverStatAliases[driver], commonStatAliases} {
+		for _, alias := range aliases {
+			if alias.stat == stat {
+				return alias.canonical, priority
+			}
+			priority++
+		}
+	}
+
+	if canonicalStats[stat] {
+		return stat, 0
+	}
+
+	return "", 0
+}
+
+// statUnit returns the unit of a canonical stat, declared only where it is the suffix of the metric name as
+// OpenMetrics requires
+func statUnit(stat string) string {
+	switch {
+	case strings.HasSuffix(stat, "_bytes"):
+		return "bytes"
+	case strings.HasSuffix(stat, "_packets"):
+		return "packets"
+	default:
+		return ""
+	}
+}
+
+// normalizeStats splits the stats read for a vf into stats with a canonical name and the remaining raw driver stats.
+// A stat reported under its canonical name takes precedence over any alias of it, and an alias over the aliases after
+// it in the alias tables.
+func normalizeStats(driver string, stats sriovStats) (canonical, raw sriovStats) {
+	canonical = make(sriovStats, len(stats))
+	raw = make(sriovStats, 0)
+	priorities := make(map[string]int, len(stats))
+
+	for stat, value := range stats {
+		name, priority := canonicalStat(driver, stat)
+		if name == "" {
+			raw[stat] = value
+			continue
+		}
+
+		if current, ok := priorities[name]; !ok || priority < current {
+			canonical[name] = value
+			priorities[name] = priority
+		}
+	}
+
+	return canonical, raw
+}
@@ -0,0 +1,119 @@
+package collectors
+
+import (
+	"io/fs"
+	"strings"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("test getting canonical stat names", // canonicalStatName
+	func(driver, stat, expected string) {
+		Expect(canonicalStatName(driver, stat)).To(Equal(expected))
+	},
+	Entry("canonical name", "ice", "rx_bytes", "rx_bytes"),
+	Entry("common alias", "i40e", "rx_mcast", "rx_multicast"),
+	Entry("driver alias", "mlx5_core", "broadcast", "rx_broadcast"),
+	Entry("driver alias of another driver", "ice", "broadcast", ""),
+	Entry("unknown driver", "", "tx_errors", "tx_errors"),
+	Entry("unmapped stat", "ice", "rx_vlan_filtered", ""),
+)
+
+var _ = DescribeTable("test normalizing stats", // normalizeStats
+	func(driver string, stats, expectedCanonical, expectedRaw sriovStats) {
+		canonical, raw := normalizeStats(driver, stats)
+		Expect(canonical).To(Equal(expectedCanonical))
+		Expect(raw).To(Equal(expectedRaw))
+	},
+	Entry("netlink stats",
+		"",
+		sriovStats{"rx_bytes": 1, "tx_bytes": 2, "rx_broadcast": 3, "rx_multicast": 4},
+		sriovStats{"rx_bytes": 1, "tx_bytes": 2, "rx_broadcast": 3, "rx_multicast": 4},
+		sriovStats{}),
+	Entry("sysfs stats with aliases and unmapped stats",
+		"ice",
+		sriovStats{"rx_packets": 1, "rx_mcast": 2, "tx_bcast_packets": 3, "rx_vlan_filtered": 4},
+		sriovStats{"rx_packets": 1, "rx_multicast": 2, "tx_broadcast": 3},
+		sriovStats{"rx_vlan_filtered": 4}),
+	Entry("stats reported under both a canonical name and an alias",
+		"ice",
+		sriovStats{"rx_dropped": 1, "rx_discards": 2, "tx_discards": 3},
+		sriovStats{"rx_dropped": 1, "tx_dropped": 3},
+		sriovStats{}),
+	Entry("stats reported under two aliases",
+		"ice",
+		sriovStats{"rx_drop": 1, "rx_discards": 2, "tx_discards": 3, "tx_drop": 4},
+		sriovStats{"rx_dropped": 1, "tx_dropped": 4},
+		sriovStats{}),
+	Entry("stats reported under a driver alias and a common alias",
+		"ice",
+		sriovStats{"rx_mcast": 1, "rx_mcast_packets": 2},
+		sriovStats{"rx_multicast": 2},
+		sriovStats{}),
+	Entry("no stats",
+		"ice",
+		sriovStats{},
+		sriovStats{},
+		sriovStats{}),
+)
+
+var _ = Describe("test publishing driver stats", func() { // Collect
+	BeforeEach(func() {
+		fsys := fstest.MapFS{
+			"0000:5d:00.0/sriov_totalvfs":                    {Data: []byte("8")},
+			"0000:5d:00.0/net/t_ens1f0":                      {Mode: fs.ModeDir},
+			"0000:5d:00.0/numa_node":                         {Data: []byte("1")},
+			"0000:5d:00.0/class":                             {Data: []byte("0x020000")},
+			"0000:5d:00.0/driver":                            {Data: []byte("/sys/bus/pci/drivers/mlx5_core"), Mode: fs.ModeSymlink},
+			"0000:5d:00.0/virtfn0":                           {Data: []byte("/sys/devices/0000:5d:01.0"), Mode: fs.ModeSymlink},
+			"t_ens1f0/device/sriov/0/stats/multicast":        {Data: []byte("3")},
+			"t_ens1f0/device/sriov/0/stats/rx_vport_packets": {Data: []byte("5")},
+		}
+		devfs = fsys
+		netfs = fsys
+		collectorPriority = []string{"sysfs"}
+		DeferCleanup(func() {
+			*vfRawStats = true
+			*vfDriverStats = false
+		})
+	})
+
+	// vfMetrics returns the vf metrics of the collector
+	vfMetrics := func() []string {
+		metrics := make([]string, 0)
+		for _, m := range gatherMetrics(createSriovDevCollector()) {
+			if strings.HasPrefix(m, "sriov_vf_") {
+				metrics = append(metrics, m)
+			}
+		}
+
+		return metrics
+	}
+
+	It("publishes unmapped stats as sriov_vf_<stat> by default", func() {
+		Expect(vfMetrics()).To(Equal([]string{
+			`sriov_vf_rx_multicast[name:"numa_node" value:"1" name:"pciAddr" value:"0000:5d:01.0" name:"pf" value:"t_ens1f0" name:"vf" value:"0"] 3`,
+			`sriov_vf_rx_vport_packets[name:"numa_node" value:"1" name:"pciAddr" value:"0000:5d:01.0" name:"pf" value:"t_ens1f0" name:"vf" value:"0"] 5`,
+		}))
+	})
+
+	It("publishes unmapped stats under sriov_vf_driver_stat", func() {
+		*vfRawStats = false
+		*vfDriverStats = true
+
+		Expect(vfMetrics()).To(Equal([]string{
+			`sriov_vf_driver_stat[name:"numa_node" value:"1" name:"pciAddr" value:"0000:5d:01.0" name:"pf" value:"t_ens1f0" name:"stat" value:"rx_vport_packets" name:"vf" value:"0"] 5`,
+			`sriov_vf_rx_multicast[name:"numa_node" value:"1" name:"pciAddr" value:"0000:5d:01.0" name:"pf" value:"t_ens1f0" name:"vf" value:"0"] 3`,
+		}))
+	})
+
+	It("publishes only the canonical stats without raw and driver stats", func() {
+		*vfRawStats = false
+
+		Expect(vfMetrics()).To(Equal([]string{
+			`sriov_vf_rx_multicast[name:"numa_node" value:"1" name:"pciAddr" value:"0000:5d:01.0" name:"pf" value:"t_ens1f0" name:"vf" value:"0"] 3`,
+		}))
+	})
+})
@@ -3,8 +3,11 @@ package collectors
 import (
 	"fmt"
 	"io/fs"
+	"strings"
 	"testing/fstest"
 
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
 	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -15,25 +18,36 @@ import (
 )
 
 var _ = AfterEach(func() {
-	vfstats.GetLink = netlink.LinkByName
+	vfstats.ListLinks = netlink.LinkList
 })
 
+// isPFMetric checks if a metric is published per PF rather than per VF
+func isPFMetric(m prometheus.Metric) bool {
+	return strings.Contains(m.Desc().String(), `"sriov_pf_`)
+}
+
 var _ = DescribeTable("test vf stats collection", // Collect
 	func(priority []string, fsys fs.FS, link netlink.Device, expected []metric, logs ...string) {
 		devfs = fsys
 		netfs = fsys
 		collectorPriority = priority
 
-		vfstats.GetLink = func(name string) (netlink.Link, error) {
-			return &link, nil
+		vfstats.ListLinks = func() ([]netlink.Link, error) {
+			return []netlink.Link{&link}, nil
 		}
 
 		ch := make(chan prometheus.Metric, 1)
 		go createSriovDevCollector().Collect(ch)
 
 		for i := 0; i < len(expected); i++ {
+			promMetric := <-ch
+			if isPFMetric(promMetric) {
+				i--
+				continue
+			}
+
 			m := dto.Metric{}
-			err := (<-ch).Write(&m)
+			err := promMetric.Write(&m)
 			Expect(err).ToNot(HaveOccurred())
 
 			labels := make(map[string]string, 4)
@@ -68,10 +82,10 @@ var _ = DescribeTable("test vf stats collection", // Collect
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:1d:01.1", "pf": "t_ens785f0", "vf": "1"}, 16},
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:1d:01.1", "pf": "t_ens785f0", "vf": "1"}, 32}},
 		"collecting sr-iov device metrics",
-		"collector priority: \\[sysfs\\]",
-		"t_ens785f0 - using sysfs collector",
-		"getting stats for t_ens785f0 vf\\d",
-		"getting stats for t_ens785f0 vf\\d"),
+		"reader priority collector=vfstats priority=sysfs",
+		"using reader, stats found for vf pf=t_ens785f0 reader=sysfs",
+		"getting vf stats pf=t_ens785f0 vf=\\d reader=sysfs",
+		"getting vf stats pf=t_ens785f0 vf=\\d reader=sysfs"),
 	Entry("with only netlink",
 		[]string{"netlink"},
 		fstest.MapFS{
@@ -81,7 +95,7 @@ var _ = DescribeTable("test vf stats collection", // Collect
 			"0000:2e:00.0/class":          {Data: []byte("0x020000")},
 			"0000:2e:00.0/virtfn0":        {Data: []byte("/sys/devices/0000:2e:01.0"), Mode: fs.ModeSymlink},
 			"0000:2e:00.0/virtfn1":        {Data: []byte("/sys/devices/0000:2e:01.1"), Mode: fs.ModeSymlink}},
-		netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{
+		netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "t_ens801f0", Vfs: []netlink.VfInfo{
 			{
 				ID: 0, Mac: nil, Spoofchk: true, RxPackets: 11, TxPackets: 12, RxBytes: 13,
 				TxBytes: 14, Multicast: 15, Broadcast: 16, RxDropped: 17, TxDropped: 18,
@@ -109,8 +123,8 @@ var _ = DescribeTable("test vf stats collection", // Collect
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:2e:01.1", "pf": "t_ens801f0", "vf": "1"}, 27},
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:2e:01.1", "pf": "t_ens801f0", "vf": "1"}, 28}},
 		"collecting sr-iov device metrics",
-		"collector priority: \\[netlink\\]",
-		"t_ens801f0 - using netlink collector"),
+		"reader priority collector=vfstats priority=netlink",
+		"using reader, stats found for vf pf=t_ens801f0 reader=netlink"),
 	Entry("with both sysfs and netlink",
 		[]string{"sysfs", "netlink"},
 		fstest.MapFS{
@@ -126,7 +140,7 @@ var _ = DescribeTable("test vf stats collection", // Collect
 			"0000:4g:00.0/numa_node":                     {Data: []byte("0")},
 			"0000:4g:00.0/class":                         {Data: []byte("0x020000")},
 			"0000:4g:00.0/virtfn0":                       {Data: []byte("/sys/devices/0000:4g:01.0"), Mode: fs.ModeSymlink}},
-		netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{
+		netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "t_ens801f0", Vfs: []netlink.VfInfo{
 			{
 				ID: 0, Mac: nil, Spoofchk: true, RxPackets: 31, TxPackets: 32, RxBytes: 33,
 				TxBytes: 34, Multicast: 35, Broadcast: 36, RxDropped: 37, TxDropped: 38,
@@ -144,13 +158,13 @@ var _ = DescribeTable("test vf stats collection", // Collect
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:4g:01.0", "pf": "t_ens801f0", "vf": "0"}, 37},
 			{map[string]string{"numa_node": "0", "pciAddr": "0000:4g:01.0", "pf": "t_ens801f0", "vf": "0"}, 38}},
 		"collecting sr-iov device metrics",
-		"collector priority: \\[sysfs netlink\\]",
-		"t_ens785f0 - using sysfs collector",
-		"getting stats for t_ens785f0 vf\\d"),
+		"reader priority collector=vfstats priority=sysfs,netlink",
+		"using reader, stats found for vf pf=t_ens785f0 reader=sysfs",
+		"getting vf stats pf=t_ens785f0 vf=\\d reader=sysfs"),
 
 	// These logs are expected, but were causing instability in this test case, removed for now
 	// "t_ens801f0 does not support sysfs collector, directory 't_ens801f0/device/sriov' does not exist",
-	// "t_ens801f0 - using netlink collector",
+	// "using reader, stats found for vf pf=t_ens801f0 reader=netlink",
 )
 
 //nolint:dupl // Test table entries have similar structure by design
@@ -179,7 +193,9 @@ var _ = DescribeTable("test creating sriovDev collector", // createSriovDevColle
 			"0000:2b:00.1/class":          {Data: []byte("0x020000")}},
 		sriovDevCollector{
 			"vfstats",
-			map[string]string{"0000:1a:00.0": "1", "0000:1a:00.1": "1", "0000:2b:00.0": "2", "0000:2b:00.1": "2"}}),
+			map[string]string{"0000:1a:00.0": "1", "0000:1a:00.1": "1", "0000:2b:00.0": "2", "0000:2b:00.1": "2"},
+			newReaderCache(),
+			newTopologyCache()}),
 	Entry("mixed devices",
 		fstest.MapFS{
 			"0000:3c:00.0/sriov_totalvfs": {Data: []byte("63")},
@@ -196,9 +212,11 @@ var _ = DescribeTable("test creating sriovDev collector", // createSriovDevColle
 			"0000:4d:00.1/class":          {Data: []byte("0x020000")}},
 		sriovDevCollector{
 			"vfstats",
-			map[string]string{"0000:3c:00.0": "1", "0000:3c:00.1": "1", "0000:4d:00.0": "", "0000:4d:00.1": ""}},
-		"no numa node information for device '0000:4d:00.0'",
-		"no numa node information for device '0000:4d:00.1'"),
+			map[string]string{"0000:3c:00.0": "1", "0000:3c:00.1": "1", "0000:4d:00.0": "", "0000:4d:00.1": ""},
+			newReaderCache(),
+			newTopologyCache()},
+		"no numa node information pf=0000:4d:00.0",
+		"no numa node information pf=0000:4d:00.1"),
 	Entry("no sriov net devices",
 		fstest.MapFS{
 			"0000:5e:00.0/": {Mode: fs.ModeDir},
@@ -207,7 +225,9 @@ var _ = DescribeTable("test creating sriovDev collector", // createSriovDevColle
 			"0000:5e:00.3/": {Mode: fs.ModeDir}},
 		sriovDevCollector{
 			"vfstats",
-			map[string]string{}},
+			map[string]string{},
+			newReaderCache(),
+			newTopologyCache()},
 		"no sriov net devices found"),
 )
 
@@ -250,15 +270,16 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 		netfs = fsys
 
 		if link != nil {
-			vfstats.GetLink = func(name string) (netlink.Link, error) {
-				return link, nil
+			vfstats.ListLinks = func() ([]netlink.Link, error) {
+				return []netlink.Link{link}, nil
 			}
 			DeferCleanup(func() {
-				vfstats.GetLink = netlink.LinkByName
+				vfstats.ListLinks = netlink.LinkList
 			})
 		}
 
-		sriovDev := getSriovDev(dev, priority)
+		collector := sriovDevCollector{readers: newReaderCache(), devs: newTopologyCache()}
+		sriovDev := collector.getSriovDev(dev, priority, &linkDump{})
 		Expect(sriovDev).To(Equal(expected))
 
 		assertLogs(logs)
@@ -268,6 +289,7 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 		[]string{"sysfs", "netlink"},
 		fstest.MapFS{
 			"0000:4f:00.0/net/ens785f0":                {Mode: fs.ModeDir},
+			"0000:4f:00.0/driver":                      {Data: []byte("/sys/bus/pci/drivers/ice"), Mode: fs.ModeSymlink},
 			"0000:4f:00.0/virtfn0":                     {Data: []byte("/sys/devices/0000:4f:01.0"), Mode: fs.ModeSymlink},
 			"0000:4f:00.0/virtfn1":                     {Data: []byte("/sys/devices/0000:4f:01.1"), Mode: fs.ModeSymlink},
 			"ens785f0/device/sriov":                    {Mode: fs.ModeDir},
@@ -277,9 +299,11 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 		nil,
 		sriovDev{
 			"ens785f0",
+			"ice",
 			sysfsReader{"/sys/class/net/%s/device/sriov/%s/stats"},
-			map[string]string{"0": "0000:4f:01.0", "1": "0000:4f:01.1"}},
-		"ens785f0 - using sysfs collector"),
+			map[string]string{"0": "0000:4f:01.0", "1": "0000:4f:01.1"},
+			""},
+		"using reader, stats found for vf pf=ens785f0 reader=sysfs vf=0"),
 	Entry("without sysfs support",
 		"0000:6h:00.0",
 		[]string{"sysfs", "netlink"},
@@ -289,13 +313,16 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 			"0000:6h:00.0/virtfn1":      {Data: []byte("/sys/devices/0000:6h:01.1"), Mode: fs.ModeSymlink},
 			"0000:7i:00.0/net/ens801f0": {Mode: fs.ModeDir},
 			"0000:7i:00.0/virtfn0":      {Data: []byte("/sys/devices/0000:7i:01.0"), Mode: fs.ModeSymlink}},
-		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{}}}, //nolint:govet
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens785f0", Vfs: []netlink.VfInfo{{ID: 1}}}},
 		sriovDev{
 			"ens785f0",
-			netlinkReader{vfstats.VfStats("ens785f0")},
-			map[string]string{"0": "0000:6h:01.0", "1": "0000:6h:01.1"}},
-		"ens785f0 does not support sysfs collector",
-		"ens785f0 - using netlink collector"),
+			"",
+			netlinkReader{vfstats.PerPF{Pf: "ens785f0", Vfs: map[int]netlink.VfInfo{1: {ID: 1}}}},
+			map[string]string{"0": "0000:6h:01.0", "1": "0000:6h:01.1"},
+			""},
+		"could not resolve pf driver pf=0000:6h:00.0",
+		"pf does not support reader, directory does not exist pf=ens785f0 reader=sysfs",
+		"using reader, stats found for vf pf=ens785f0 reader=netlink vf=1"),
 	Entry("without any collector support",
 		"0000:8j:00.0",
 		[]string{"unsupported_collector"},
@@ -306,9 +333,11 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 		nil,
 		sriovDev{
 			"ens785f0",
+			"",
 			nil,
-			map[string]string{"0": "0000:8j:01.0", "1": "0000:8j:01.1"}},
-		"ens785f0 - 'unsupported_collector' collector not supported"),
+			map[string]string{"0": "0000:8j:01.0", "1": "0000:8j:01.1"},
+			""},
+		"unknown reader pf=ens785f0 reader=unsupported_collector"),
 	Entry("without any virtual functions",
 		"0000:9k:00.0",
 		[]string{"sysfs"},
@@ -317,11 +346,13 @@ var _ = DescribeTable("test getting sriov dev details", // getSriovDev
 		nil,
 		sriovDev{
 			"ens785f0",
+			"",
 			nil,
-			map[string]string{}},
-		"error getting vf address",
+			map[string]string{},
+			""},
+		"could not get vf addresses",
 		"no virtual functions found for pf '0000:9k:00.0'",
-		"ens785f0 does not support sysfs collector"),
+		"pf does not support reader, directory does not exist pf=ens785f0 reader=sysfs"),
 )
 
 var _ = DescribeTable("test getting numa node information for devices from filesystem", // getNumaNodes // TODO: ensure map order
@@ -349,12 +380,12 @@ var _ = DescribeTable("test getting numa node information for devices from files
 			"0000:5f:00.0/numa_node": {Data: []byte("-1")},
 			"0000:5f:00.1/numa_node": {Data: []byte("-1")}},
 		map[string]string{"0000:4e:00.0": "", "0000:4e:00.1": "", "0000:5f:00.0": "", "0000:5f:00.1": ""},
-		"could not read numa_node file for device '0000:4e:00.0'",
+		"could not read numa_node file pf=0000:4e:00.0",
 		"open 0000:4e:00.0/numa_node: file does not exist",
-		"could not read numa_node file for device '0000:4e:00.1'",
+		"could not read numa_node file pf=0000:4e:00.1",
 		"open 0000:4e:00.1/numa_node: file does not exist",
-		"no numa node information for device '0000:5f:00.0'",
-		"no numa node information for device '0000:5f:00.1'"),
+		"no numa node information pf=0000:5f:00.0",
+		"no numa node information pf=0000:5f:00.1"),
 	Entry("no sriov net devices",
 		[]string{"0000:6g:00.0", "0000:6g:00.1", "0000:6g:00.2", "0000:6g:00.3"},
 		fstest.MapFS{
@@ -363,13 +394,13 @@ var _ = DescribeTable("test getting numa node information for devices from files
 			"0000:6g:00.2/": {Mode: fs.ModeDir},
 			"0000:6g:00.3/": {Mode: fs.ModeDir}},
 		map[string]string{"0000:6g:00.0": "", "0000:6g:00.1": "", "0000:6g:00.2": "", "0000:6g:00.3": ""},
-		"could not read numa_node file for device '0000:6g:00.0'",
+		"could not read numa_node file pf=0000:6g:00.0",
 		"open 0000:6g:00.0/numa_node: file does not exist",
-		"could not read numa_node file for device '0000:6g:00.1'",
+		"could not read numa_node file pf=0000:6g:00.1",
 		"open 0000:6g:00.1/numa_node: file does not exist",
-		"could not read numa_node file for device '0000:6g:00.2'",
+		"could not read numa_node file pf=0000:6g:00.2",
 		"open 0000:6g:00.2/numa_node: file does not exist",
-		"could not read numa_node file for device '0000:6g:00.3'",
+		"could not read numa_node file pf=0000:6g:00.3",
 		"open 0000:6g:00.3/numa_node: file does not exist"),
 )
 
@@ -400,7 +431,7 @@ var _ = DescribeTable("test getting vf information for devices from filesystem",
 			"0000:9j:00.0/virtfn0": {Data: []byte("/sys/devices/0000:9j:01.0"), Mode: fs.ModeDir}},
 		map[string]string{},
 		fmt.Errorf("no virtual functions found for pf '0000:9j:00.0'"),
-		"error evaluating symlink '0000:9j:00.0/virtfn0'"),
+		"could not evaluate vf symlink path=0000:9j:00.0/virtfn0"),
 	Entry("vf file does not exist for specified sriov net device",
 		"0000:1c:00.0",
 		fstest.MapFS{},
@@ -428,7 +459,7 @@ var _ = DescribeTable("test getting vf data from filesystem", // vfData
 		fstest.MapFS{"0000:8i:00.0/virtfn0": {Mode: fs.ModeDir}},
 		"",
 		"",
-		"error evaluating symlink '0000:8i:00.0/virtfn0'"),
+		"could not evaluate vf symlink path=0000:8i:00.0/virtfn0"),
 )
 
 var _ = DescribeTable("test getting pf name from pci address on filesystem", // getPFName
@@ -448,6 +479,243 @@ var _ = DescribeTable("test getting pf name from pci address on filesystem", //
 		"0000:3e:00.0",
 		fstest.MapFS{},
 		"",
-		"0000:3e:00.0 - could not get pf interface name in path '0000:3e:00.0/net'",
+		"could not get pf interface name pf=0000:3e:00.0 path=0000:3e:00.0/net",
 		"open 0000:3e:00.0/net: file does not exist"),
 )
+
+var _ = DescribeTable("test getting pf driver from pci address on filesystem", // getPFDriver
+	func(dev string, fsys fs.FS, expected string, logs ...string) {
+		devfs = fsys
+
+		driver := getPFDriver(dev)
+		Expect(driver).To(Equal(expected))
+
+		assertLogs(logs)
+	},
+	Entry("driver bound",
+		"0000:2d:00.0",
+		fstest.MapFS{"0000:2d:00.0/driver": {Data: []byte("/sys/bus/pci/drivers/i40e"), Mode: fs.ModeSymlink}},
+		"i40e"),
+	Entry("no driver bound",
+		"0000:3e:00.0",
+		fstest.MapFS{"0000:3e:00.0/": {Mode: fs.ModeDir}},
+		"",
+		"could not resolve pf driver pf=0000:3e:00.0"),
+)
+
+var _ = Describe("test publishing stats reader info", func() { // Collect
+	It("publishes the reader used for each pf", func() {
+		fsys := fstest.MapFS{
+			"0000:6e:00.0/sriov_totalvfs":              {Data: []byte("8")},
+			"0000:6e:00.0/net/t_ens2f0":                {Mode: fs.ModeDir},
+			"0000:6e:00.0/numa_node":                   {Data: []byte("0")},
+			"0000:6e:00.0/class":                       {Data: []byte("0x020000")},
+			"0000:6e:00.0/virtfn0":                     {Data: []byte("/sys/devices/0000:6e:01.0"), Mode: fs.ModeSymlink},
+			"t_ens2f0/device/sriov/0/stats/rx_packets": {Data: []byte("1")},
+		}
+		devfs = fsys
+		netfs = fsys
+		collectorPriority = []string{"sysfs"}
+
+		ch := make(chan prometheus.Metric)
+		go func() {
+			createSriovDevCollector().Collect(ch)
+			close(ch)
+		}()
+
+		var info []map[string]string
+		for m := range ch {
+			if !strings.Contains(m.Desc().String(), "sriov_pf_stats_reader_info") {
+				continue
+			}
+
+			out := dto.Metric{}
+			Expect(m.Write(&out)).To(Succeed())
+			Expect(out.GetGauge().GetValue()).To(Equal(1.0))
+
+			labels := make(map[string]string, len(out.Label))
+			for _, label := range out.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			info = append(info, labels)
+		}
+
+		Expect(info).To(Equal([]map[string]string{{"pf": "t_ens2f0", "reader": "sysfs"}}))
+	})
+})
+
+var _ = Describe("test stats reader cache", func() { // getSriovDev, Collect
+	fsys := fstest.MapFS{
+		"0000:7f:00.0/sriov_totalvfs": {Data: []byte("8")},
+		"0000:7f:00.0/net/t_ens3f0":   {Mode: fs.ModeDir},
+		"0000:7f:00.0/numa_node":      {Data: []byte("0")},
+		"0000:7f:00.0/class":          {Data: []byte("0x020000")},
+		"0000:7f:00.0/virtfn0":        {Data: []byte("/sys/devices/0000:7f:01.0"), Mode: fs.ModeSymlink},
+		"t_ens3f0/device/sriov":       {Mode: fs.ModeDir},
+	}
+
+	BeforeEach(func() {
+		devfs = fsys
+		netfs = fsys
+		collectorPriority = []string{"sysfs"}
+	})
+
+	It("uses the cached reader without probing", func() {
+		collector := sriovDevCollector{readers: newReaderCache(), devs: newTopologyCache()}
+		collector.readers.set("0000:7f:00.0", readerSysfs)
+
+		pf := collector.getSriovDev("0000:7f:00.0", []string{"netlink"}, &linkDump{})
+		Expect(pf.reader).To(Equal(sysfsReader{"/sys/class/net/%s/device/sriov/%s/stats"}))
+		Expect(probeFailuresFor("t_ens3f0")).To(BeEmpty())
+	})
+
+	It("caches the reader selected by probing", func() {
+		fsys["t_ens3f0/device/sriov/0/stats/rx_packets"] = &fstest.MapFile{Data: []byte("1")}
+		DeferCleanup(func() {
+			delete(fsys, "t_ens3f0/device/sriov/0/stats/rx_packets")
+		})
+
+		collector := sriovDevCollector{readers: newReaderCache(), devs: newTopologyCache()}
+		collector.getSriovDev("0000:7f:00.0", []string{"sysfs"}, &linkDump{})
+
+		reader, ok := collector.readers.get("0000:7f:00.0")
+		Expect(ok).To(BeTrue())
+		Expect(reader).To(Equal(readerSysfs))
+	})
+
+	It("forgets the cached reader when it returns no stats", func() {
+		collector := createSriovDevCollector().(sriovDevCollector)
+		collector.readers.set("0000:7f:00.0", readerSysfs)
+
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+
+		_, ok := collector.readers.get("0000:7f:00.0")
+		Expect(ok).To(BeFalse())
+		assertLogs([]string{"reader returned no stats for any vf, readers will be probed again pf=t_ens3f0 reader=sysfs"})
+	})
+})
+
+var _ = DescribeTable("test sorting vf ids", // sortedVFIDs
+	func(vfs vfsPCIAddr, expected []string) {
+		Expect(sortedVFIDs(vfs)).To(Equal(expected))
+	},
+	Entry("numeric order", vfsPCIAddr{"10": "", "2": "", "0": "", "1": ""}, []string{"0", "1", "2", "10"}),
+	Entry("no vfs", vfsPCIAddr{}, []string{}),
+)
+
+var _ = Describe("test pf topology cache", func() { // topologyCache
+	var fsys fstest.MapFS
+
+	BeforeEach(func() {
+		fsys = fstest.MapFS{
+			"0000:8a:00.0/sriov_numvfs": {Data: []byte("1")},
+			"0000:8a:00.0/net/t_ens4f0": {Mode: fs.ModeDir},
+			"0000:8a:00.0/virtfn0":      {Data: []byte("/sys/devices/0000:8a:01.0"), Mode: fs.ModeSymlink},
+		}
+		devfs = fsys
+	})
+
+	It("reuses the topology while sriov_numvfs is unchanged", func() {
+		devs := newTopologyCache()
+		Expect(devs.get("0000:8a:00.0").vfs).To(Equal(vfsPCIAddr{"0": "0000:8a:01.0"}))
+
+		fsys["0000:8a:00.0/virtfn1"] = &fstest.MapFile{Data: []byte("/sys/devices/0000:8a:01.1"), Mode: fs.ModeSymlink}
+		Expect(devs.get("0000:8a:00.0").vfs).To(Equal(vfsPCIAddr{"0": "0000:8a:01.0"}))
+	})
+
+	It("reads the topology again when sriov_numvfs changes", func() {
+		devs := newTopologyCache()
+		Expect(devs.get("0000:8a:00.0").vfs).To(Equal(vfsPCIAddr{"0": "0000:8a:01.0"}))
+
+		fsys["0000:8a:00.0/virtfn1"] = &fstest.MapFile{Data: []byte("/sys/devices/0000:8a:01.1"), Mode: fs.ModeSymlink}
+		fsys["0000:8a:00.0/sriov_numvfs"] = &fstest.MapFile{Data: []byte("2")}
+		Expect(devs.get("0000:8a:00.0").vfs).To(Equal(vfsPCIAddr{"0": "0000:8a:01.0", "1": "0000:8a:01.1"}))
+	})
+
+	It("does not cache the topology when sriov_numvfs can not be read", func() {
+		delete(fsys, "0000:8a:00.0/sriov_numvfs")
+
+		devs := newTopologyCache()
+		devs.get("0000:8a:00.0")
+		Expect(devs.devs).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test netlink link dump", func() { // linkDump
+	It("dumps links once for all pfs", func() {
+		dumps := 0
+		vfstats.ListLinks = func() ([]netlink.Link, error) {
+			dumps++
+			return []netlink.Link{
+				&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0", Vfs: []netlink.VfInfo{{ID: 0}}}},
+				&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f1"}},
+			}, nil
+		}
+
+		links := &linkDump{}
+		_, ok := links.get("ens1f0")
+		Expect(ok).To(BeTrue())
+		_, ok = links.get("ens1f1")
+		Expect(ok).To(BeTrue())
+		perPF, ok := links.get("ens2f0")
+		Expect(ok).To(BeFalse())
+		Expect(perPF).To(Equal(vfstats.PerPF{Pf: "ens2f0", Vfs: map[int]netlink.VfInfo{}}))
+
+		Expect(dumps).To(Equal(1))
+	})
+})
+
+var _ = DescribeTable("test filtering pfs", // filterPFs
+	func(filter utils.StringListFlag, expected []string, logs ...string) {
+		devfs = fstest.MapFS{
+			"0000:9b:00.0/net/ens5f0": {Mode: fs.ModeDir},
+			"0000:9b:00.1/net/ens5f1": {Mode: fs.ModeDir},
+		}
+		pfFilter = filter
+		DeferCleanup(func() {
+			pfFilter = nil
+		})
+
+		Expect(filterPFs([]string{"0000:9b:00.0", "0000:9b:00.1"})).To(Equal(expected))
+
+		assertLogs(logs)
+	},
+	Entry("without filter", nil, []string{"0000:9b:00.0", "0000:9b:00.1"}),
+	Entry("by interface name", utils.StringListFlag{"ens5f1"}, []string{"0000:9b:00.1"},
+		"pf excluded by filter pf=0000:9b:00.0"),
+	Entry("by pci address", utils.StringListFlag{"0000:9b:00.0"}, []string{"0000:9b:00.0"},
+		"pf excluded by filter pf=0000:9b:00.1"),
+)
+
+var _ = DescribeTable("test collecting generated hosts", // Collect
+	func(opts fakehost.Options, reader string) {
+		host := fakehost.New(opts)
+		devfs, netfs = host.DevFS(), host.NetFS()
+		collectorPriority = []string{readerSysfs, readerNetlink}
+		vfstats.ListLinks = host.Netlink().LinkList
+		utils.EvalSymlinks = host.EvalSymlinks
+		DeferCleanup(func() {
+			utils.EvalSymlinks = evalSymlinks
+		})
+
+		metrics := gatherMetrics(createSriovDevCollector())
+
+		for _, pf := range host.PFs {
+			Expect(metrics).To(ContainElement(fmt.Sprintf(`sriov_pf_stats_reader_info[name:"pf" value:"%s" name:"reader" value:"%s"] 1`, pf.Name, reader)))
+			for _, vf := range pf.VFs {
+				Expect(metrics).To(ContainElement(fmt.Sprintf(
+					`sriov_vf_rx_packets[name:"numa_node" value:"%d" name:"pciAddr" value:"%s" name:"pf" value:"%s" name:"vf" value:"%d"] %v`,
+					pf.NUMANode, vf.Addr, pf.Name, vf.ID, float64(vf.Stats["rx_packets"]))))
+			}
+		}
+	},
+	Entry("with sysfs stats", fakehost.Options{PFs: 8, VFsPerPF: 64, NUMANodes: 2, SysfsStats: true}, readerSysfs),
+	Entry("in switchdev mode without sysfs stats",
+		fakehost.Options{PFs: 4, VFsPerPF: 32, Driver: "mlx5_core", NUMANodes: 2, Switchdev: true}, readerNetlink),
+)
@@ -0,0 +1,354 @@
+package collectors
+
+// vf_allocation tracks when each VF is allocated to and released from a pod, and flags leaked VFs: unallocated VFs
+// left with the MAC, VLAN or trust setting of their last pod, and allocations of PCI devices not present on the host
+
+import (
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	vfAllocationName = "vfallocation"
+
+	// Reasons of a leaked vf
+	leakReasonMAC   = "mac"
+	leakReasonVLAN  = "vlan"
+	leakReasonTrust = "trust"
+)
+
+var (
+	vfAllocations = newAllocationTracker()
+
+	vfAllocationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "allocations_total"),
+		"Number of times the vf was allocated to a pod since the exporter started",
+		[]string{labelPCIAddr, labelPF, labelVF}, nil,
+	)
+	vfReleasesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "releases_total"),
+		"Number of times the vf was released from a pod since the exporter started",
+		[]string{labelPCIAddr, labelPF, labelVF}, nil,
+	)
+	vfAllocationAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "allocation_age_seconds"),
+		"Time since the vf was allocated to the container holding it, counted from the start of the exporter for earlier allocations",
+		[]string{labelPCIAddr, labelPF, labelVF, "pod", "namespace", "container"}, nil,
+	)
+	vfLeakedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "leaked"),
+		"Unallocated vf with a non-default mac, vlan or trust setting",
+		[]string{labelPCIAddr, labelPF, labelVF, labelReason}, nil,
+	)
+	staleAllocationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, "", "stale_allocation"),
+		"Allocation of a PCI device that is not present on the host",
+		[]string{labelPCIAddr, "resource", "pod", "namespace", "container"}, nil,
+	)
+)
+
+// allocationHolder is the container a device is allocated to. The uid is set if the pod is known to the pod watch, or
+// if the pod is named by its UID because it was read from the device manager checkpoint without the pod watch.
+type allocationHolder struct {
+	pod       string
+	namespace string
+	uid       string
+	container string
+	resource  string
+	dynamic   bool
+}
+
+// identity returns the UID of the pod of the holder if known, else its namespace and name
+func (h allocationHolder) identity() string {
+	if h.uid != "" {
+		return h.uid
+	}
+
+	return h.namespace + "/" + h.pod
+}
+
+// holderChange is how the holder of a vf changed between two observations
+type holderChange int
+
+const (
+	// holderKept is no change of holder, or a change that can not be told from the pod resources
+	holderKept holderChange = iota
+	holderAllocated
+	holderReplaced
+	holderReleased
+)
+
+// changeOfHolder returns how the holder of a vf changed from seen, the holder last read from the pod resources, to
+// holder. Holders are compared by pod identity. A vf keeps its holder when the source of the pod resources changed, as
+// the pods may be named differently by each source, and while the device manager checkpoint is read if it was
+// allocated through DRA, as the checkpoint does not hold the resource claims.
+func changeOfHolder(seen allocationHolder, wasAllocated bool, holder allocationHolder, allocated bool, source string, sourceChanged bool) holderChange {
+	switch {
+	case !allocated && wasAllocated && seen.dynamic && source == podResourcesSourceCheckpoint:
+		return holderKept
+	case allocated && !wasAllocated:
+		return holderAllocated
+	case allocated && (sourceChanged || seen.identity() == holder.identity()):
+		return holderKept
+	case allocated:
+		return holderReplaced
+	case wasAllocated:
+		return holderReleased
+	default:
+		return holderKept
+	}
+}
+
+// allocation is the current holder of a vf, if any, and the number of times the vf changed hands
+type allocation struct {
+	holder    allocationHolder
+	allocated bool
+	since     time.Time
+	allocs    float64
+	releases  float64
+}
+
+// allocationTracker holds the allocation of each vf seen so far
+type allocationTracker struct {
+	mu     sync.Mutex
+	synced bool
+	source string
+	vfs    map[string]allocation
+	// seen is the holder of each allocated vf as last read from the source, which the next holders are compared to
+	seen map[string]allocationHolder
+}
+
+func newAllocationTracker() *allocationTracker {
+	return &allocationTracker{vfs: make(map[string]allocation), seen: make(map[string]allocationHolder)}
+}
+
+// observe records the holders of the present vfs, keyed by PCI address, and returns the allocation of each of them.
+// The holders change as told by changeOfHolder. Allocations found on the first observation are not counted, as they
+// were made before the exporter started, and neither are the changes found when the source of the pod resources
+// changed. Vfs no longer present are forgotten.
+func (t *allocationTracker) observe(present map[string]bool, holders map[string]allocationHolder, source string) map[string]allocation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for addr := range t.vfs {
+		if !present[addr] {
+			delete(t.vfs, addr)
+			delete(t.seen, addr)
+		}
+	}
+
+	count := t.synced && t.source == source
+	for addr := range present {
+		current := t.vfs[addr]
+		holder, allocated := holders[addr]
+		seen, wasAllocated := t.seen[addr]
+
+		switch changeOfHolder(seen, wasAllocated, holder, allocated, source, t.source != source) {
+		case holderAllocated:
+			if count {
+				current.allocs++
+			}
+			current.holder, current.allocated, current.since = holder, true, now()
+		case holderReplaced:
+			current.releases++
+			current.allocs++
+			current.holder, current.since = holder, now()
+		case holderReleased:
+			if count {
+				current.releases++
+			}
+			current.holder, current.allocated = allocationHolder{}, false
+		}
+
+		switch {
+		case allocated:
+			t.seen[addr] = holder
+		case !current.allocated:
+			delete(t.seen, addr)
+		}
+		t.vfs[addr] = current
+	}
+	t.synced, t.source = true, source
+
+	return t.snapshot()
+}
+
+// snapshot returns a copy of the allocations, the caller must hold the lock
+func (t *allocationTracker) snapshot() map[string]allocation {
+	vfs := make(map[string]allocation, len(t.vfs))
+	for addr, alloc := range t.vfs {
+		vfs[addr] = alloc
+	}
+
+	return vfs
+}
+
+// vfAllocationCollector publishes the allocation lifecycle and the leaked vfs of the host
+type vfAllocationCollector struct {
+	enrichment PodEnrichment
+	name       string
+}
+
+// vfInfo identifies a present vf by its pf
+type vfInfo struct {
+	pfName string
+	id     string
+}
+
+// init runs the registration for this collector on package import
+func init() {
+	register(vfAllocationName, disabled, createVfAllocationCollector)
+}
+
+// Collect correlates the vfs of the host with the pod resources, from the kubelet api or the device manager
+// checkpoint. Nothing but the collector failure is published if neither can be read, so that a kubelet restart
+// is not counted as the release of every vf.
+func (c vfAllocationCollector) Collect(ch chan<- prometheus.Metric) {
+	resources, source, err := podResourcesWithFallback(c.enrichment)
+	if err != nil {
+		slog.Warn("pod resources not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
+		return
+	}
+	holders := allocationHolders(resources, c.enrichment)
+
+	vfs := make(map[string]vfInfo)
+	present := make(map[string]bool)
+	settings := make(map[string]netlink.VfInfo)
+	links := &linkDump{}
+	for _, pfAddr := range filterPFs(getSriovDevAddrs()) {
+		vfList, err := vfList(pfAddr)
+		if err != nil {
+			slog.Debug("no vfs to track allocations of", labelCollector, c.name, labelPF, pfAddr, "err", err)
+			continue
+		}
+
+		pfName := getPFName(pfAddr)
+		perPF, _ := links.get(pfName)
+		for id, addr := range vfList {
+			vfs[addr] = vfInfo{pfName, id}
+			present[addr] = true
+
+			index, _ := strconv.Atoi(id)
+			if info, ok := perPF.Vfs[index]; ok {
+				settings[addr] = info
+			}
+		}
+	}
+
+	for addr, alloc := range vfAllocations.observe(present, holders, source) {
+		vf := vfs[addr]
+		ch <- prometheus.MustNewConstMetric(vfAllocationsDesc, prometheus.CounterValue, alloc.allocs, addr, vf.pfName, vf.id)
+		ch <- prometheus.MustNewConstMetric(vfReleasesDesc, prometheus.CounterValue, alloc.releases, addr, vf.pfName, vf.id)
+		if alloc.allocated {
+			ch <- prometheus.MustNewConstMetric(vfAllocationAgeDesc, prometheus.GaugeValue, now().Sub(alloc.since).Seconds(),
+				addr, vf.pfName, vf.id, alloc.holder.pod, alloc.holder.namespace, alloc.holder.container)
+		} else if info, ok := settings[addr]; ok {
+			publishLeaks(ch, addr, vf.pfName, vf.id, info)
+		}
+	}
+
+	for addr, holder := range holders {
+		if present[addr] {
+			continue
+		}
+		if _, err := fs.Stat(devfs, addr); err == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(staleAllocationDesc, prometheus.GaugeValue, 1,
+			addr, holder.resource, holder.pod, holder.namespace, holder.container)
+	}
+
+	publishCollectorSuccess(ch, c.name, nil)
+}
+
+// allocationHolders returns the container holding each PCI device allocated through a device plugin or a DRA claim
+func allocationHolders(resources []*v1.PodResources, enrichment PodEnrichment) map[string]allocationHolder {
+	holders := make(map[string]allocationHolder)
+	for _, podRes := range resources {
+		uid := podUID(podRes, enrichment)
+		for _, contRes := range podRes.GetContainers() {
+			holder := allocationHolder{pod: podRes.GetName(), namespace: podRes.GetNamespace(), uid: uid, container: contRes.GetName()}
+
+			for _, devices := range contRes.GetDevices() {
+				for _, dev := range devices.GetDeviceIds() {
+					if isPci(dev) {
+						holder.resource = devices.GetResourceName()
+						holders[dev] = holder
+					}
+				}
+			}
+
+			holder.dynamic = true
+			for _, dynamicRes := range contRes.GetDynamicResources() {
+				for _, claimRes := range dynamicRes.GetClaimResources() {
+					if addr := claimPCIAddr(claimRes); addr != "" {
+						holder.resource = claimRes.GetDriverName()
+						holders[addr] = holder
+					}
+				}
+			}
+		}
+	}
+
+	return holders
+}
+
+// podUID returns the UID of the pod of the resources, taken from the pod watch, or else the name of a pod read from the
+// device manager checkpoint without namespace, which is its UID. It returns an empty string if the UID is not known.
+func podUID(podRes *v1.PodResources, enrichment PodEnrichment) string {
+	if pod := enrichment.pod(podRes.GetNamespace(), podRes.GetName()); pod != nil {
+		return string(pod.UID)
+	}
+
+	if podRes.GetNamespace() == "" {
+		return podRes.GetName()
+	}
+
+	return ""
+}
+
+// publishLeaks publishes the settings of an unallocated vf that are left from its last holder
+func publishLeaks(ch chan<- prometheus.Metric, addr, pfName, id string, info netlink.VfInfo) {
+	leaks := map[string]bool{
+		leakReasonMAC:   !isZeroMAC(info.Mac),
+		leakReasonVLAN:  info.Vlan != 0,
+		leakReasonTrust: info.Trust == 1,
+	}
+
+	for reason, leaked := range leaks {
+		if leaked {
+			ch <- prometheus.MustNewConstMetric(vfLeakedDesc, prometheus.GaugeValue, 1, addr, pfName, id, reason)
+		}
+	}
+}
+
+// isZeroMAC returns true if the administrative mac of a vf is not set
+func isZeroMAC(mac []byte) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Describe is not defined for this collector
+func (c vfAllocationCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func createVfAllocationCollector() prometheus.Collector {
+	return vfAllocationCollector{
+		enrichment: podEnrichment,
+		name:       vfAllocationName,
+	}
+}
@@ -0,0 +1,210 @@
+package collectors
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/vfstats"
+)
+
+// reset forgets all vfs
+func (t *allocationTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.synced, t.source = false, ""
+	t.vfs = make(map[string]allocation)
+	t.seen = make(map[string]allocationHolder)
+}
+
+var _ = Describe("test tracking vf allocations", func() { // allocationTracker.observe
+	var (
+		tracker *allocationTracker
+		advance func(time.Duration)
+		present = map[string]bool{"0000:3b:02.0": true}
+		pod1    = allocationHolder{pod: "pod1", namespace: "default", container: "app", resource: "intel.com/sriov"}
+		pod2    = allocationHolder{pod: "pod2", namespace: "default", container: "app", resource: "intel.com/sriov"}
+	)
+
+	BeforeEach(func() {
+		tracker = newAllocationTracker()
+		advance = fakeClock()
+	})
+
+	It("does not count the allocations found on the first observation", func() {
+		vfs := tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+		Expect(vfs).To(Equal(map[string]allocation{
+			"0000:3b:02.0": {holder: pod1, allocated: true, since: time.Unix(1000, 0)},
+		}))
+	})
+
+	It("counts allocations, releases and changes of holder", func() {
+		tracker.observe(present, nil, podResourcesSourceAPI)
+
+		advance(time.Minute)
+		vfs := tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: pod1, allocated: true, since: time.Unix(1060, 0), allocs: 1}))
+
+		advance(time.Minute)
+		vfs = tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"].since).To(Equal(time.Unix(1060, 0)))
+
+		vfs = tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod2}, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: pod2, allocated: true, since: time.Unix(1120, 0), allocs: 2, releases: 1}))
+
+		vfs = tracker.observe(present, nil, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{since: time.Unix(1120, 0), allocs: 2, releases: 2}))
+	})
+
+	It("compares the holders by pod identity", func() {
+		uid := "6b5b533a-6307-48d1-911f-07bf5d4e1c82"
+		watched := allocationHolder{pod: "pod1", namespace: "default", uid: uid, container: "app"}
+		tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": watched}, podResourcesSourceAPI)
+
+		renamed := allocationHolder{pod: "pod1-renamed", namespace: "default", uid: uid, container: "app"}
+		vfs := tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": renamed}, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: watched, allocated: true, since: time.Unix(1000, 0)}))
+	})
+
+	It("does not count the changes of holder found when the source changed", func() {
+		tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+
+		uid := "6b5b533a-6307-48d1-911f-07bf5d4e1c82"
+		byUID := allocationHolder{pod: uid, uid: uid, container: "app", resource: "intel.com/sriov"}
+		advance(time.Minute)
+		vfs := tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": byUID}, podResourcesSourceCheckpoint)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: pod1, allocated: true, since: time.Unix(1000, 0)}))
+
+		vfs = tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": byUID}, podResourcesSourceCheckpoint)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: pod1, allocated: true, since: time.Unix(1000, 0)}))
+
+		vfs = tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: pod1, allocated: true, since: time.Unix(1000, 0)}))
+	})
+
+	It("keeps the vfs allocated through DRA while the checkpoint is read", func() {
+		claim := allocationHolder{pod: "pod1", namespace: "default", container: "app", resource: "sriov.example.com", dynamic: true}
+		tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": claim}, podResourcesSourceAPI)
+
+		for range 2 {
+			vfs := tracker.observe(present, nil, podResourcesSourceCheckpoint)
+			Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{holder: claim, allocated: true, since: time.Unix(1000, 0)}))
+		}
+
+		vfs := tracker.observe(present, nil, podResourcesSourceAPI)
+		Expect(vfs["0000:3b:02.0"]).To(Equal(allocation{since: time.Unix(1000, 0)}))
+	})
+
+	It("forgets vfs that are no longer present", func() {
+		tracker.observe(present, map[string]allocationHolder{"0000:3b:02.0": pod1}, podResourcesSourceAPI)
+		Expect(tracker.observe(map[string]bool{}, nil, podResourcesSourceAPI)).To(BeEmpty())
+	})
+})
+
+var _ = DescribeTable("test changes of the holder of a vf", // changeOfHolder
+	func(seen allocationHolder, wasAllocated bool, holder allocationHolder, allocated bool, source string, sourceChanged bool, expected holderChange) {
+		Expect(changeOfHolder(seen, wasAllocated, holder, allocated, source, sourceChanged)).To(Equal(expected))
+	},
+	Entry("allocated", allocationHolder{}, false, allocationHolder{pod: "pod-a", namespace: "ns"}, true,
+		podResourcesSourceAPI, false, holderAllocated),
+	Entry("replaced", allocationHolder{pod: "pod-a", namespace: "ns"}, true, allocationHolder{pod: "pod-b", namespace: "ns"}, true,
+		podResourcesSourceAPI, false, holderReplaced),
+	Entry("released", allocationHolder{pod: "pod-a", namespace: "ns"}, true, allocationHolder{}, false,
+		podResourcesSourceAPI, false, holderReleased),
+	Entry("same pod under another name", allocationHolder{pod: "pod-a", namespace: "ns", uid: "uid-a"}, true,
+		allocationHolder{pod: "uid-a", uid: "uid-a"}, true, podResourcesSourceCheckpoint, false, holderKept),
+	Entry("other pod read from another source", allocationHolder{pod: "pod-a", namespace: "ns"}, true,
+		allocationHolder{pod: "uid-b"}, true, podResourcesSourceCheckpoint, true, holderKept),
+	Entry("dra allocation missing from the checkpoint", allocationHolder{pod: "pod-a", namespace: "ns", dynamic: true}, true,
+		allocationHolder{}, false, podResourcesSourceCheckpoint, true, holderKept),
+	Entry("dra allocation missing from the pod resources", allocationHolder{pod: "pod-a", namespace: "ns", dynamic: true}, true,
+		allocationHolder{}, false, podResourcesSourceAPI, false, holderReleased),
+	Entry("never allocated", allocationHolder{}, false, allocationHolder{}, false, podResourcesSourceAPI, false, holderKept),
+)
+
+var _ = Describe("test vf allocation collection", func() { // vfAllocationCollector.Collect
+	var resources []*v1.PodResources
+
+	BeforeEach(func() {
+		fakeClock()
+		vfAllocations.reset()
+		DeferCleanup(vfAllocations.reset)
+
+		devfs = fstest.MapFS{
+			"0000:3b:00.0/sriov_totalvfs": {Data: []byte("64")},
+			"0000:3b:00.0/class":          {Data: []byte("0x020000")},
+			"0000:3b:00.0/net/ens1f0":     {Mode: fs.ModeDir},
+			"0000:3b:00.0/virtfn0":        {Data: []byte("../0000:3b:02.0"), Mode: fs.ModeSymlink},
+			"0000:3b:00.0/virtfn1":        {Data: []byte("../0000:3b:02.1"), Mode: fs.ModeSymlink},
+			"0000:3b:00.0/virtfn2":        {Data: []byte("../0000:3b:02.2"), Mode: fs.ModeSymlink},
+		}
+
+		vfstats.ListLinks = func() ([]netlink.Link, error) {
+			return []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0", Vfs: []netlink.VfInfo{
+				{ID: 0, Mac: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}, Vlan: 100},
+				{ID: 1, Mac: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}, Trust: 1},
+				{ID: 2, Mac: net.HardwareAddr{0, 0, 0, 0, 0, 0}},
+			}}}}, nil
+		}
+		DeferCleanup(func() { vfstats.ListLinks = netlink.LinkList })
+
+		resources = []*v1.PodResources{{Name: "pod1", Namespace: "default", Containers: []*v1.ContainerResources{{
+			Name: "app",
+			Devices: []*v1.ContainerDevices{
+				{ResourceName: "intel.com/sriov", DeviceIds: []string{"0000:3b:02.0", "0000:d8:02.0"}},
+			},
+		}}}}
+		listPodResources = func() ([]*v1.PodResources, error) { return resources, nil }
+		DeferCleanup(func() { listPodResources = PodResources })
+	})
+
+	It("publishes the allocations, leaked vfs and stale allocations", func() {
+		Expect(gatherMetrics(createVfAllocationCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"vfallocation"] 1`,
+			`sriov_stale_allocation[name:"container" value:"app" name:"namespace" value:"default" name:"pciAddr" value:"0000:d8:02.0" ` +
+				`name:"pod" value:"pod1" name:"resource" value:"intel.com/sriov"] 1`,
+			`sriov_vf_allocation_age_seconds[name:"container" value:"app" name:"namespace" value:"default" name:"pciAddr" value:"0000:3b:02.0" ` +
+				`name:"pf" value:"ens1f0" name:"pod" value:"pod1" name:"vf" value:"0"] 0`,
+			`sriov_vf_allocations_total[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 0`,
+			`sriov_vf_allocations_total[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"vf" value:"1"] 0`,
+			`sriov_vf_allocations_total[name:"pciAddr" value:"0000:3b:02.2" name:"pf" value:"ens1f0" name:"vf" value:"2"] 0`,
+			`sriov_vf_leaked[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"reason" value:"mac" name:"vf" value:"1"] 1`,
+			`sriov_vf_leaked[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"reason" value:"trust" name:"vf" value:"1"] 1`,
+			`sriov_vf_releases_total[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 0`,
+			`sriov_vf_releases_total[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"vf" value:"1"] 0`,
+			`sriov_vf_releases_total[name:"pciAddr" value:"0000:3b:02.2" name:"pf" value:"ens1f0" name:"vf" value:"2"] 0`,
+		}))
+	})
+
+	It("counts the release of a vf and flags its leftover settings", func() {
+		createVfAllocationCollector().Collect(make(chan prometheus.Metric, 100))
+		resources = nil
+
+		metrics := gatherMetrics(createVfAllocationCollector())
+		Expect(metrics).To(ContainElements(
+			`sriov_vf_releases_total[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"vf" value:"0"] 1`,
+			`sriov_vf_leaked[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"reason" value:"mac" name:"vf" value:"0"] 1`,
+			`sriov_vf_leaked[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"reason" value:"vlan" name:"vf" value:"0"] 1`,
+		))
+		Expect(metrics).ToNot(ContainElement(ContainSubstring("sriov_vf_allocation_age_seconds")))
+	})
+
+	It("does not count releases when pod resources can not be listed", func() {
+		createVfAllocationCollector().Collect(make(chan prometheus.Metric, 100))
+		listPodResources = func() ([]*v1.PodResources, error) { return nil, errors.New("kubelet restarting") }
+
+		Expect(gatherMetrics(createVfAllocationCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"vfallocation"] 0`,
+		}))
+		Expect(vfAllocations.vfs["0000:3b:02.0"].allocated).To(BeTrue())
+	})
+})
@@ -0,0 +1,169 @@
+package collectors
+
+// vfResourcePoolCollector labels every vf with the resource pool of the SR-IOV network device plugin that selects it,
+// read from the device plugin config, whether the vf is allocated or not
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/deviceplugin"
+)
+
+const (
+	vfResourcePoolName = "vfresourcepool"
+
+	vendorFile = "vendor"
+	deviceFile = "device"
+)
+
+var (
+	devicePluginConfig = flag.String("path.devicepluginconfig", "/etc/pcidp/config.json", "Path to the SR-IOV network device plugin config file on host")
+
+	vfResourcePoolDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collectorNamespace, vfStatsSubsystem, "resource_pool_info"),
+		"Resource pool of the SR-IOV network device plugin selecting the vf",
+		[]string{labelPCIAddr, labelPF, labelVF, "resource"}, nil,
+	)
+)
+
+// vfResourcePoolCollector publishes the resource pool of each vf
+type vfResourcePoolCollector struct {
+	name   string
+	config *devicePluginConfigCache
+}
+
+// devicePluginConfigCache is the device plugin config as last loaded, with the modification time and size of its file
+type devicePluginConfigCache struct {
+	mu      sync.Mutex
+	config  *deviceplugin.Config
+	modTime time.Time
+	size    int64
+}
+
+// load returns the device plugin config at path, loading it again if the file changed since the last load, and logs
+// the pools using unsupported selectors on each load
+func (c *devicePluginConfigCache) load(path, collector string) (*deviceplugin.Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read device plugin config '%s'\n%v", path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.config != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.config, nil
+	}
+
+	config, err := deviceplugin.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pool := range config.Unsupported() {
+		slog.Warn("resource pool uses selectors that are not matched, it is skipped", labelCollector, collector, "resource", pool)
+	}
+	c.config, c.modTime, c.size = config, info.ModTime(), info.Size()
+
+	return config, nil
+}
+
+// init runs the registration for this collector on package import
+func init() {
+	register(vfResourcePoolName, disabled, createVfResourcePoolCollector)
+}
+
+// Collect loads the device plugin config again when it changed, so that changes to the config map are picked up, and
+// publishes the pool of every vf matching a selector. Vfs not selected by any pool are not published, and pools using
+// selectors that are not matched such as linkTypes or isRdma are skipped.
+func (c vfResourcePoolCollector) Collect(ch chan<- prometheus.Metric) {
+	config, err := c.config.load(*devicePluginConfig, c.name)
+	if err != nil {
+		slog.Warn("device plugin config not available", labelCollector, c.name, "err", err)
+		publishCollectorSuccess(ch, c.name, err)
+		return
+	}
+
+	for _, pfAddr := range filterPFs(getSriovDevAddrs()) {
+		vfs, err := vfList(pfAddr)
+		if err != nil {
+			slog.Debug("no vfs to match with the resource pools", labelCollector, c.name, labelPF, pfAddr, "err", err)
+			continue
+		}
+
+		pfName := getPFName(pfAddr)
+		for _, id := range sortedVFIDs(vfs) {
+			vfAddr := vfs[id]
+			index, _ := strconv.Atoi(id)
+
+			pool := config.Pool(deviceplugin.Device{
+				Vendor:     readPCIID(vfAddr, vendorFile),
+				DeviceID:   readPCIID(vfAddr, deviceFile),
+				Driver:     readDriver(vfAddr),
+				PFName:     pfName,
+				VFIndex:    index,
+				RootDevice: pfAddr,
+				PCIAddress: vfAddr,
+			})
+			if pool == "" {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(vfResourcePoolDesc, prometheus.GaugeValue, 1, vfAddr, pfName, id, pool)
+		}
+	}
+
+	publishCollectorSuccess(ch, c.name, nil)
+}
+
+// readPCIID returns the vendor or device id of a PCI device without the 0x prefix, as the device plugin selectors
+// expect it, or an empty string if it can not be read
+func readPCIID(addr, file string) string {
+	id, err := fs.ReadFile(devfs, filepath.Join(addr, file))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(string(id)), "0x")
+}
+
+// resolveVfResourcePoolFilepaths resolves the directory of the device plugin config if the collector is enabled. The
+// file itself is not resolved, as a mounted config map links it to a directory that is replaced when the map changes.
+func resolveVfResourcePoolFilepaths(apply bool) error {
+	if !*collectorState[vfResourcePoolName] {
+		return nil
+	}
+
+	config, err := resolveDir("path.devicepluginconfig", *devicePluginConfig)
+	if err != nil {
+		return err
+	}
+
+	if apply {
+		*devicePluginConfig = config
+	}
+
+	return nil
+}
+
+// Describe is not defined for this collector
+func (c vfResourcePoolCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func createVfResourcePoolCollector() prometheus.Collector {
+	return vfResourcePoolCollector{
+		name:   vfResourcePoolName,
+		config: &devicePluginConfigCache{},
+	}
+}
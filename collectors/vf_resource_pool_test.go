@@ -0,0 +1,87 @@
+package collectors
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("test vf resource pool collection", func() { // vfResourcePoolCollector.Collect
+	BeforeEach(func() {
+		devfs = fstest.MapFS{
+			"0000:3b:00.0/sriov_totalvfs": {Data: []byte("64")},
+			"0000:3b:00.0/class":          {Data: []byte("0x020000")},
+			"0000:3b:00.0/net/ens1f0":     {Mode: fs.ModeDir},
+			"0000:3b:00.0/virtfn0":        {Data: []byte("../0000:3b:02.0"), Mode: fs.ModeSymlink},
+			"0000:3b:00.0/virtfn1":        {Data: []byte("../0000:3b:02.1"), Mode: fs.ModeSymlink},
+			"0000:3b:00.0/virtfn2":        {Data: []byte("../0000:3b:02.2"), Mode: fs.ModeSymlink},
+			"0000:3b:02.0/vendor":         {Data: []byte("0x8086\n")},
+			"0000:3b:02.0/device":         {Data: []byte("0x1889\n")},
+			"0000:3b:02.0/driver":         {Data: []byte("../../../../bus/pci/drivers/iavf"), Mode: fs.ModeSymlink},
+			"0000:3b:02.1/vendor":         {Data: []byte("0x8086\n")},
+			"0000:3b:02.1/device":         {Data: []byte("0x1889\n")},
+			"0000:3b:02.1/driver":         {Data: []byte("../../../../bus/pci/drivers/vfio-pci"), Mode: fs.ModeSymlink},
+			"0000:3b:02.2/vendor":         {Data: []byte("0x15b3\n")},
+			"0000:3b:02.2/device":         {Data: []byte("0x101e\n")},
+		}
+
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		DeferCleanup(func(old string) { *devicePluginConfig = old }, *devicePluginConfig)
+		*devicePluginConfig = path
+	})
+
+	It("publishes the resource pool of each selected vf", func() {
+		Expect(os.WriteFile(*devicePluginConfig, []byte(`{"resourceList": [
+			{"resourceName": "sriov_netdevice", "selectors": {"vendors": ["8086"], "drivers": ["iavf"]}},
+			{"resourceName": "sriov_dpdk", "resourcePrefix": "example.com", "selectors": [{"drivers": ["vfio-pci"], "pfNames": ["ens1f0#1"]}]}
+		]}`), 0o600)).To(Succeed())
+
+		Expect(gatherMetrics(createVfResourcePoolCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"vfresourcepool"] 1`,
+			`sriov_vf_resource_pool_info[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"resource" value:"intel.com/sriov_netdevice" name:"vf" value:"0"] 1`,
+			`sriov_vf_resource_pool_info[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"resource" value:"example.com/sriov_dpdk" name:"vf" value:"1"] 1`,
+		}))
+	})
+
+	It("skips the pools using unsupported selectors and logs them once per load", func() {
+		write := func(config string) {
+			Expect(os.WriteFile(*devicePluginConfig, []byte(config), 0o600)).To(Succeed())
+		}
+		write(`{"resourceList": [
+			{"resourceName": "sriov_rdma", "selectors": {"vendors": ["8086"], "isRdma": true}},
+			{"resourceName": "sriov_netdevice", "selectors": {"drivers": ["iavf"]}}
+		]}`)
+
+		collector := createVfResourcePoolCollector()
+		expected := []string{
+			`sriov_collector_success[name:"collector" value:"vfresourcepool"] 1`,
+			`sriov_vf_resource_pool_info[name:"pciAddr" value:"0000:3b:02.0" name:"pf" value:"ens1f0" name:"resource" value:"intel.com/sriov_netdevice" name:"vf" value:"0"] 1`,
+		}
+		Expect(gatherMetrics(collector)).To(Equal(expected))
+		Expect(gatherMetrics(collector)).To(Equal(expected))
+		assertLogs([]string{"resource pool uses selectors that are not matched, it is skipped collector=vfresourcepool resource=intel.com/sriov_rdma"})
+		Expect(&buffer).ToNot(gbytes.Say("resource=intel.com/sriov_rdma"))
+
+		By("changing the config")
+		write(`{"resourceList": [
+			{"resourceName": "sriov_ib", "selectors": {"linkTypes": ["infiniband"]}},
+			{"resourceName": "sriov_dpdk", "selectors": {"drivers": ["vfio-pci"]}}
+		]}`)
+		Expect(gatherMetrics(collector)).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"vfresourcepool"] 1`,
+			`sriov_vf_resource_pool_info[name:"pciAddr" value:"0000:3b:02.1" name:"pf" value:"ens1f0" name:"resource" value:"intel.com/sriov_dpdk" name:"vf" value:"1"] 1`,
+		}))
+		assertLogs([]string{"resource pool uses selectors that are not matched, it is skipped collector=vfresourcepool resource=intel.com/sriov_ib"})
+	})
+
+	It("reports a missing config as a collector failure", func() {
+		Expect(gatherMetrics(createVfResourcePoolCollector())).To(Equal([]string{
+			`sriov_collector_success[name:"collector" value:"vfresourcepool"] 0`,
+		}))
+	})
+})
@@ -0,0 +1,165 @@
+// Package config loads the exporter configuration file and applies it to the command line flags.
+// Each section of the file sets the flags sharing its prefix, e.g. the "listen-address" key of the
+// "web" section sets the web.listen-address flag, so every flag can also be set from the file.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// sectionPrefixes maps the sections of the configuration file to the prefix of the flags they set
+var sectionPrefixes = map[string]string{
+	"collectors":    "collector.",
+	"paths":         "path.",
+	"filters":       "filter.",
+	"web":           "web.",
+	"log":           "log.",
+	"otlp":          "otlp.",
+	"push":          "push.",
+	"kube":          "kube.",
+	"custommetrics": "custommetrics.",
+}
+
+// Load reads a YAML or JSON configuration file and returns the flag values it sets, keyed by flag name
+func Load(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file '%s'\n%v", path, err)
+	}
+
+	values, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file '%s'\n%v", path, err)
+	}
+
+	return values, nil
+}
+
+// Parse parses a YAML or JSON configuration and returns the flag values it sets, keyed by flag name.
+// Lists are joined with commas and maps are joined as comma-separated key=value pairs.
+func Parse(data []byte) (map[string]string, error) {
+	sections := make(map[string]map[string]any)
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for section, keys := range sections {
+		prefix, ok := sectionPrefixes[section]
+		if !ok {
+			return nil, fmt.Errorf("unknown section '%s'", section)
+		}
+
+		for key, raw := range keys {
+			value, err := flagValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s - %v", section, key, err)
+			}
+			values[prefix+key] = value
+		}
+	}
+
+	return values, nil
+}
+
+// flagValue converts a configuration value to the string representation accepted by the flag it sets
+func flagValue(raw any) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	case string, bool, int, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", raw)
+	}
+}
+
+// Apply sets the flags of the flag set to the given values. Flags set on the command line keep their value,
+// and flags set by a previously applied configuration that are not in values are reset to their default.
+// Unknown flags are rejected before any flag is changed, and all flags are restored if a value can not be set.
+// It returns the values that were applied.
+func Apply(fs *flag.FlagSet, values map[string]string, commandLine map[string]bool, previous map[string]string) (map[string]string, error) {
+	for name := range values {
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting '%s'", name)
+		}
+	}
+
+	snapshot := Snapshot(fs)
+	applied := make(map[string]string, len(values))
+
+	for name := range previous {
+		if _, ok := values[name]; ok || commandLine[name] {
+			continue
+		}
+		if err := fs.Set(name, fs.Lookup(name).DefValue); err != nil {
+			Restore(fs, snapshot)
+			return nil, fmt.Errorf("%s - could not reset to default\n%v", name, err)
+		}
+	}
+
+	for name, value := range values {
+		if commandLine[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			Restore(fs, snapshot)
+			return nil, fmt.Errorf("%s - invalid value '%s'\n%v", name, value, err)
+		}
+		applied[name] = value
+	}
+
+	return applied, nil
+}
+
+// CommandLine returns the names of the flags set on the command line
+func CommandLine(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Snapshot returns the current value of every flag in the flag set
+func Snapshot(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// Restore sets every flag in the flag set back to the value in a snapshot
+func Restore(fs *flag.FlagSet, snapshot map[string]string) {
+	for name, value := range snapshot {
+		_ = fs.Set(name, value)
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/utils"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "config test suite")
+}
+
+var _ = DescribeTable("test parsing configuration", // Parse
+	func(data string, expected map[string]string, expectedErr error) {
+		values, err := Parse([]byte(data))
+
+		if expectedErr != nil {
+			Expect(err).To(MatchError(expectedErr.Error()))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal(expected))
+	},
+	Entry("yaml",
+		`
+collectors:
+  vfstats: true
+  vfstatspriority: [sysfs, netlink]
+  vfstatsoverride:
+    mlx5_core: netlink
+    ice: sysfs
+paths:
+  sysbuspci: /host/sys/bus/pci/devices
+filters:
+  pfs:
+    - ens785f0
+web:
+  rate-limit: 5
+`,
+		map[string]string{
+			"collector.vfstats":         "true",
+			"collector.vfstatspriority": "sysfs,netlink",
+			"collector.vfstatsoverride": "ice=sysfs,mlx5_core=netlink",
+			"path.sysbuspci":            "/host/sys/bus/pci/devices",
+			"filter.pfs":                "ens785f0",
+			"web.rate-limit":            "5",
+		},
+		nil),
+	Entry("json",
+		`{"collectors": {"kubepoddevice": true}, "web": {"listen-address": ":9809"}}`,
+		map[string]string{
+			"collector.kubepoddevice": "true",
+			"web.listen-address":      ":9809",
+		},
+		nil),
+	Entry("otlp",
+		`otlp: {endpoint: "collector:4317", headers: {tenant: node-metrics}}`,
+		map[string]string{
+			"otlp.endpoint": "collector:4317",
+			"otlp.headers":  "tenant=node-metrics",
+		},
+		nil),
+	Entry("kube",
+		`kube: {networkstatus: true, node-name: node1}`,
+		map[string]string{
+			"kube.networkstatus": "true",
+			"kube.node-name":     "node1",
+		},
+		nil),
+	Entry("empty",
+		``,
+		map[string]string{},
+		nil),
+	Entry("unknown section",
+		`metrics: {enabled: true}`,
+		nil,
+		fmt.Errorf("unknown section 'metrics'")),
+	Entry("nested lists",
+		`collectors: {vfstatspriority: [[sysfs]]}`,
+		map[string]string{"collector.vfstatspriority": "sysfs"},
+		nil),
+)
+
+var _ = Describe("test applying configuration", func() { // Apply
+	var (
+		fs       *flag.FlagSet
+		enabled  *bool
+		address  *string
+		priority utils.StringListFlag
+	)
+
+	BeforeEach(func() {
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		enabled = fs.Bool("collector.vfstats", true, "")
+		address = fs.String("web.listen-address", ":9808", "")
+		priority = nil
+		fs.Var(&priority, "collector.vfstatspriority", "")
+	})
+
+	It("sets flags to the configured values", func() {
+		applied, err := Apply(fs, map[string]string{"collector.vfstats": "false", "collector.vfstatspriority": "netlink"}, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(applied).To(HaveLen(2))
+		Expect(*enabled).To(BeFalse())
+		Expect(priority).To(Equal(utils.StringListFlag{"netlink"}))
+	})
+
+	It("keeps flags set on the command line", func() {
+		Expect(fs.Parse([]string{"--web.listen-address=:9900"})).To(Succeed())
+
+		applied, err := Apply(fs, map[string]string{"web.listen-address": ":9809"}, CommandLine(fs), nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(applied).To(BeEmpty())
+		Expect(*address).To(Equal(":9900"))
+	})
+
+	It("resets flags removed from the configuration to their default", func() {
+		previous, err := Apply(fs, map[string]string{"collector.vfstats": "false", "collector.vfstatspriority": "netlink"}, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = Apply(fs, map[string]string{}, nil, previous)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*enabled).To(BeTrue())
+		Expect(priority).To(BeEmpty())
+	})
+
+	It("rejects unknown settings without changing flags", func() {
+		_, err := Apply(fs, map[string]string{"collector.vfstats": "false", "collector.unknown": "true"}, nil, nil)
+		Expect(err).To(MatchError("unknown setting 'collector.unknown'"))
+		Expect(*enabled).To(BeTrue())
+	})
+
+	It("restores flags when a value is invalid", func() {
+		_, err := Apply(fs, map[string]string{"web.listen-address": ":9809", "collector.vfstats": "maybe"}, nil, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(*enabled).To(BeTrue())
+		Expect(*address).To(Equal(":9808"))
+	})
+})
+
+var _ = Describe("test loading configuration file", func() { // Load
+	It("loads a file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte("web: {rate-burst: 20}"), 0o600)).To(Succeed())
+
+		values, err := Load(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal(map[string]string{"web.rate-burst": "20"}))
+	})
+
+	It("returns an error for a missing file", func() {
+		_, err := Load(filepath.Join(GinkgoT().TempDir(), "missing.yaml"))
+		Expect(err).To(HaveOccurred())
+	})
+})
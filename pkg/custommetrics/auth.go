@@ -0,0 +1,113 @@
+package custommetrics
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// authenticationConfigMap holds the CA and names of the client certificates of the Kubernetes API server aggregator
+	authenticationNamespace = "kube-system"
+	authenticationConfigMap = "extension-apiserver-authentication"
+	requestHeaderCAKey      = "requestheader-client-ca-file"
+	requestHeaderNamesKey   = "requestheader-allowed-names"
+)
+
+// Authenticator verifies the client certificates of the requests to the custom metrics API. The Kubernetes API server
+// authorizes the users of the API before proxying their requests, so only the proxy has to be authenticated.
+type Authenticator struct {
+	// FrontProxyCAs verify the client certificates of the Kubernetes API server aggregator
+	FrontProxyCAs *x509.CertPool
+	// FrontProxyNames are the allowed common names of the aggregator certificates, any name is allowed if empty
+	FrontProxyNames []string
+	// PeerCAs verify the client certificates of the exporters on the other nodes, which may only ask for the pods of
+	// the node. Peers are not accepted if nil.
+	PeerCAs *x509.CertPool
+}
+
+// LoadFrontProxyAuthentication reads the CA and the allowed names of the client certificates of the Kubernetes API
+// server aggregator from the extension-apiserver-authentication config map
+func LoadFrontProxyAuthentication(ctx context.Context, client kubernetes.Interface) (*x509.CertPool, []string, error) {
+	cm, err := client.CoreV1().ConfigMaps(authenticationNamespace).Get(ctx, authenticationConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(cm.Data[requestHeaderCAKey])) {
+		return nil, nil, fmt.Errorf("no %s in config map %s/%s", requestHeaderCAKey, authenticationNamespace, authenticationConfigMap)
+	}
+
+	var names []string
+	if data := cm.Data[requestHeaderNamesKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &names); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s in config map %s/%s\n%v", requestHeaderNamesKey, authenticationNamespace, authenticationConfigMap, err)
+		}
+	}
+
+	return pool, names, nil
+}
+
+// Handler rejects the requests without a client certificate of the aggregator, and the requests of the peers for
+// more than the pods of their node
+func (a Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			writeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "client certificate required")
+			return
+		}
+
+		certs := r.TLS.PeerCertificates
+		switch {
+		case a.frontProxy(certs):
+		case a.peer(certs) && r.URL.Query().Get(localParam) != "":
+		default:
+			writeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "client certificate not accepted")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// frontProxy returns true if the certificates are a client certificate of the aggregator
+func (a Authenticator) frontProxy(certs []*x509.Certificate) bool {
+	if !verifyClient(certs, a.FrontProxyCAs) {
+		return false
+	}
+
+	return len(a.FrontProxyNames) == 0 || slices.Contains(a.FrontProxyNames, certs[0].Subject.CommonName)
+}
+
+// peer returns true if the certificates are a client certificate of an exporter
+func (a Authenticator) peer(certs []*x509.Certificate) bool {
+	return verifyClient(certs, a.PeerCAs)
+}
+
+// verifyClient returns true if the first certificate is a client certificate issued by the CAs, with the remaining
+// certificates as intermediates
+func verifyClient(certs []*x509.Certificate, roots *x509.CertPool) bool {
+	if roots == nil {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return err == nil
+}
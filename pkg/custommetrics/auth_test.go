@@ -0,0 +1,142 @@
+package custommetrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCA issues client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(name string) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return pool
+}
+
+// issue returns a certificate with the common name and extended key usage signed by the CA
+func (ca testCA) issue(name string, usage x509.ExtKeyUsage) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return cert
+}
+
+var _ = Describe("test authenticating the clients of the custom metrics api", func() { // Authenticator.Handler
+	var (
+		frontProxy = newTestCA("front-proxy-ca")
+		exporters  = newTestCA("exporters-ca")
+		handler    http.Handler
+	)
+
+	BeforeEach(func() {
+		auth := Authenticator{FrontProxyCAs: frontProxy.pool(), FrontProxyNames: []string{"front-proxy-client"}, PeerCAs: exporters.pool()}
+		handler = auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	DescribeTable("accepts the aggregator and the peers asking for the pods of their node",
+		func(path string, cert *x509.Certificate, code int) {
+			req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+			req.TLS = &tls.ConnectionState{}
+			if cert != nil {
+				req.TLS.PeerCertificates = []*x509.Certificate{cert}
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			Expect(recorder.Code).To(Equal(code))
+		},
+		Entry("aggregator", APIPath, frontProxy.issue("front-proxy-client", x509.ExtKeyUsageClientAuth), http.StatusOK),
+		Entry("aggregator with a name not allowed", APIPath, frontProxy.issue("other", x509.ExtKeyUsageClientAuth), http.StatusUnauthorized),
+		Entry("server certificate of the front proxy ca", APIPath, frontProxy.issue("front-proxy-client", x509.ExtKeyUsageServerAuth),
+			http.StatusUnauthorized),
+		Entry("peer", APIPath+"?local=true", exporters.issue("sriov-metrics-exporter", x509.ExtKeyUsageClientAuth), http.StatusOK),
+		Entry("peer asking for the pods of every node", APIPath, exporters.issue("sriov-metrics-exporter", x509.ExtKeyUsageClientAuth),
+			http.StatusUnauthorized),
+		Entry("unknown ca", APIPath, newTestCA("other-ca").issue("front-proxy-client", x509.ExtKeyUsageClientAuth), http.StatusUnauthorized),
+		Entry("no client certificate", APIPath, nil, http.StatusUnauthorized),
+	)
+})
+
+var _ = Describe("test loading the aggregator authentication", func() { // LoadFrontProxyAuthentication
+	It("reads the ca and the allowed names from the config map", func() {
+		ca := newTestCA("front-proxy-ca")
+		client := fake.NewClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "extension-apiserver-authentication", Namespace: "kube-system"},
+			Data: map[string]string{
+				"requestheader-client-ca-file": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})),
+				"requestheader-allowed-names":  `["front-proxy-client"]`,
+			},
+		})
+
+		pool, names, err := LoadFrontProxyAuthentication(context.Background(), client)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.Equal(ca.pool())).To(BeTrue())
+		Expect(names).To(Equal([]string{"front-proxy-client"}))
+	})
+
+	It("fails without the ca of the aggregator", func() {
+		client := fake.NewClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "extension-apiserver-authentication", Namespace: "kube-system"},
+		})
+
+		_, _, err := LoadFrontProxyAuthentication(context.Background(), client)
+		Expect(err).To(MatchError("no requestheader-client-ca-file in config map kube-system/extension-apiserver-authentication"))
+	})
+})
@@ -0,0 +1,94 @@
+// Package custommetrics serves the vf traffic rates of the pods through the Kubernetes custom metrics API, so the
+// Horizontal Pod Autoscaler can scale on them without Prometheus and prometheus-adapter.
+package custommetrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics maps the names of the served metrics to the vf stat whose per second rate they are
+var Metrics = map[string]string{
+	"sriov_vf_rx_bytes":   "rx_bytes",
+	"sriov_vf_tx_bytes":   "tx_bytes",
+	"sriov_vf_rx_packets": "rx_packets",
+	"sriov_vf_tx_packets": "tx_packets",
+	"sriov_vf_rx_dropped": "rx_dropped",
+	"sriov_vf_tx_dropped": "tx_dropped",
+}
+
+// Pod identifies a pod by namespace and name
+type Pod struct {
+	Namespace string
+	Name      string
+}
+
+// Rate is the per second rate of a stat over the window ending at the timestamp
+type Rate struct {
+	Value     float64
+	Timestamp time.Time
+	Window    time.Duration
+}
+
+// Rates holds the rates of the vf stats of each pod, computed from the counters of two consecutive samples
+type Rates struct {
+	mu       sync.RWMutex
+	counters map[Pod]map[string]int64
+	sampled  time.Time
+	rates    map[Pod]map[string]float64
+	window   time.Duration
+}
+
+func NewRates() *Rates {
+	return &Rates{counters: make(map[Pod]map[string]int64), rates: make(map[Pod]map[string]float64)}
+}
+
+// Update computes the rates from the counters sampled at t and the previous sample. Pods missing from either sample
+// have no rate. A counter lower than before was reset and its rate is computed from zero.
+func (r *Rates) Update(counters map[Pod]map[string]int64, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rates := make(map[Pod]map[string]float64)
+	window := t.Sub(r.sampled)
+	if !r.sampled.IsZero() && window > 0 {
+		for pod, current := range counters {
+			previous, ok := r.counters[pod]
+			if !ok {
+				continue
+			}
+
+			rates[pod] = make(map[string]float64, len(current))
+			for stat, value := range current {
+				before, ok := previous[stat]
+				if !ok {
+					continue
+				}
+				if value < before {
+					before = 0
+				}
+				rates[pod][stat] = float64(value-before) / window.Seconds()
+			}
+		}
+	}
+
+	r.counters, r.sampled, r.rates, r.window = counters, t, rates, window
+}
+
+// Get returns the rate of the stat of a served metric for a pod, and false if the pod or metric has no rate
+func (r *Rates) Get(pod Pod, metric string) (Rate, bool) {
+	stat, ok := Metrics[metric]
+	if !ok {
+		return Rate{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	value, ok := r.rates[pod][stat]
+	if !ok {
+		return Rate{}, false
+	}
+
+	return Rate{Value: value, Timestamp: r.sampled, Window: r.window}, true
+}
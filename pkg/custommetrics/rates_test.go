@@ -0,0 +1,56 @@
+package custommetrics
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCustommetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "custommetrics test suite")
+}
+
+var _ = Describe("test computing the rates of the pods", func() { // Rates.Update
+	var (
+		rates *Rates
+		podA  = Pod{Namespace: "default", Name: "pod-a"}
+		podB  = Pod{Namespace: "default", Name: "pod-b"}
+		start = time.Unix(1000, 0)
+	)
+
+	BeforeEach(func() {
+		rates = NewRates()
+	})
+
+	It("has no rates before the second sample", func() {
+		rates.Update(map[Pod]map[string]int64{podA: {"rx_bytes": 1000}}, start)
+
+		_, ok := rates.Get(podA, "sriov_vf_rx_bytes")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("computes the per second rate between two samples", func() {
+		rates.Update(map[Pod]map[string]int64{podA: {"rx_bytes": 1000, "tx_packets": 50}}, start)
+		rates.Update(map[Pod]map[string]int64{
+			podA: {"rx_bytes": 4000, "tx_packets": 20},
+			podB: {"rx_bytes": 100},
+		}, start.Add(10*time.Second))
+
+		rate, ok := rates.Get(podA, "sriov_vf_rx_bytes")
+		Expect(ok).To(BeTrue())
+		Expect(rate).To(Equal(Rate{Value: 300, Timestamp: start.Add(10 * time.Second), Window: 10 * time.Second}))
+
+		By("computing a reset counter from zero")
+		rate, ok = rates.Get(podA, "sriov_vf_tx_packets")
+		Expect(ok).To(BeTrue())
+		Expect(rate.Value).To(Equal(2.0))
+
+		_, ok = rates.Get(podB, "sriov_vf_rx_bytes")
+		Expect(ok).To(BeFalse())
+		_, ok = rates.Get(podA, "sriov_vf_unknown")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,220 @@
+package custommetrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/metrics/pkg/apis/custom_metrics/v1beta2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	Group   = "custom.metrics.k8s.io"
+	Version = "v1beta2"
+	APIPath = "/apis/" + Group + "/" + Version
+
+	// localParam restricts a request to the pods of the node, it is set on the requests forwarded to the peers
+	localParam = "local"
+)
+
+// PodLister returns the pods of the node
+type PodLister interface {
+	Get(namespace, name string) *corev1.Pod
+	List(namespace string, selector labels.Selector) []*corev1.Pod
+}
+
+// Peers returns the base URLs of the exporters on the other nodes, e.g. https://10.0.0.2:6443
+type Peers func(ctx context.Context) ([]string, error)
+
+// server answers the custom metrics API with the rates of the pods of the node, and of the pods of the other nodes
+// read from their exporters, since the API service is backed by any one exporter of the cluster
+type server struct {
+	rates  *Rates
+	pods   PodLister
+	peers  Peers
+	client *http.Client
+}
+
+// NewHandler returns the handler of the custom metrics API for pods. Peers may be nil to serve the pods of the node only.
+func NewHandler(rates *Rates, pods PodLister, peers Peers, client *http.Client) http.Handler {
+	s := server{rates: rates, pods: pods, peers: peers, client: client}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+APIPath, s.discovery)
+	mux.HandleFunc("GET "+APIPath+"/namespaces/{namespace}/pods/{name}/{metric}", s.podMetric)
+
+	return mux
+}
+
+// discovery lists the served metrics as resources of pods
+func (s server) discovery(w http.ResponseWriter, _ *http.Request) {
+	names := make([]string, 0, len(Metrics))
+	for name := range Metrics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	list := metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: Group + "/" + Version,
+	}
+	for _, name := range names {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       "pods/" + name,
+			Namespaced: true,
+			Kind:       "MetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// podMetric returns the rate of a metric for a pod, or for the pods of the namespace matching the label selector
+// if the name is '*'
+func (s server) podMetric(w http.ResponseWriter, r *http.Request) {
+	namespace, name, metric := r.PathValue("namespace"), r.PathValue("name"), r.PathValue("metric")
+	if _, ok := Metrics[metric]; !ok {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("metric %s is not served", metric))
+		return
+	}
+
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid label selector\n%v", err))
+		return
+	}
+
+	var pods []*corev1.Pod
+	if name == v1beta2.AllObjects {
+		pods = s.pods.List(namespace, selector)
+	} else if pod := s.pods.Get(namespace, name); pod != nil {
+		pods = []*corev1.Pod{pod}
+	}
+
+	items := make([]v1beta2.MetricValue, 0, len(pods))
+	for _, pod := range pods {
+		if rate, ok := s.rates.Get(Pod{Namespace: pod.Namespace, Name: pod.Name}, metric); ok {
+			items = append(items, metricValue(pod, metric, rate))
+		}
+	}
+
+	if r.URL.Query().Get(localParam) == "" && (name == v1beta2.AllObjects || len(pods) == 0) {
+		items = append(items, s.fromPeers(r)...)
+	}
+
+	if name != v1beta2.AllObjects && len(items) == 0 {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("metric %s is not available for pod %s/%s", metric, namespace, name))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v1beta2.MetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "MetricValueList", APIVersion: Group + "/" + Version},
+		Items:    items,
+	})
+}
+
+// fromPeers forwards a request to the exporters of the other nodes and returns the metric values they answered.
+// Peers that can not be reached are skipped, so a failing node does not fail the request.
+func (s server) fromPeers(r *http.Request) []v1beta2.MetricValue {
+	if s.peers == nil {
+		return nil
+	}
+
+	peers, err := s.peers(r.Context())
+	if err != nil {
+		slog.Warn("could not list custom metrics peers", "err", err)
+		return nil
+	}
+
+	query := r.URL.Query()
+	query.Set(localParam, "true")
+
+	var (
+		mu    sync.Mutex
+		items []v1beta2.MetricValue
+		wg    sync.WaitGroup
+	)
+	for _, peer := range peers {
+		wg.Go(func() {
+			peerItems, err := s.fromPeer(r.Context(), peer+r.URL.Path+"?"+query.Encode())
+			if err != nil {
+				slog.Warn("could not read custom metrics of peer", "peer", peer, "err", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			items = append(items, peerItems...)
+		})
+	}
+	wg.Wait()
+
+	return items
+}
+
+func (s server) fromPeer(ctx context.Context, peerURL string) ([]v1beta2.MetricValue, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A peer not running the named pod answers not found
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	list := v1beta2.MetricValueList{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+func metricValue(pod *corev1.Pod, metric string, rate Rate) v1beta2.MetricValue {
+	return v1beta2.MetricValue{
+		DescribedObject: corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace, Name: pod.Name},
+		Metric:          v1beta2.MetricIdentifier{Name: metric},
+		Timestamp:       metav1.NewTime(rate.Timestamp),
+		WindowSeconds:   ptr.To(int64(rate.Window.Seconds())),
+		Value:           *resource.NewMilliQuantity(int64(math.Round(rate.Value*1000)), resource.DecimalSI),
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("could not write custom metrics response", "err", err)
+	}
+}
@@ -0,0 +1,175 @@
+package custommetrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/metrics/pkg/apis/custom_metrics/v1beta2"
+)
+
+// fakePods lists a fixed set of pods
+type fakePods []*corev1.Pod
+
+func (f fakePods) Get(namespace, name string) *corev1.Pod {
+	for _, pod := range f {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod
+		}
+	}
+
+	return nil
+}
+
+func (f fakePods) List(namespace string, selector labels.Selector) []*corev1.Pod {
+	var pods []*corev1.Pod
+	for _, pod := range f {
+		if pod.Namespace == namespace && selector.Matches(labels.Set(pod.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods
+}
+
+func newPod(name string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: podLabels}}
+}
+
+// newTestRates returns rates of 100 received bytes per second for the pods
+func newTestRates(pods ...string) *Rates {
+	rates := NewRates()
+	before, after := make(map[Pod]map[string]int64), make(map[Pod]map[string]int64)
+	for _, name := range pods {
+		before[Pod{"default", name}] = map[string]int64{"rx_bytes": 0}
+		after[Pod{"default", name}] = map[string]int64{"rx_bytes": 1000}
+	}
+	rates.Update(before, time.Unix(1000, 0))
+	rates.Update(after, time.Unix(1010, 0))
+
+	return rates
+}
+
+// get requests a path from the handler and decodes the response
+func get(handler http.Handler, path string, v any) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	Expect(json.Unmarshal(recorder.Body.Bytes(), v)).To(Succeed())
+
+	return recorder.Code
+}
+
+// describedPods returns the names of the pods of the metric values
+func describedPods(list v1beta2.MetricValueList) []string {
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.DescribedObject.Name)
+	}
+
+	return names
+}
+
+var _ = Describe("test serving the custom metrics api", func() { // NewHandler
+	var handler http.Handler
+
+	BeforeEach(func() {
+		pods := fakePods{newPod("cnf-1", map[string]string{"app": "cnf"}), newPod("cnf-2", map[string]string{"app": "cnf"}),
+			newPod("other", map[string]string{"app": "other"}), newPod("no-vf", map[string]string{"app": "cnf"})}
+		handler = NewHandler(newTestRates("cnf-1", "cnf-2", "other"), pods, nil, nil)
+	})
+
+	It("lists the served metrics", func() {
+		list := metav1.APIResourceList{}
+		Expect(get(handler, APIPath, &list)).To(Equal(http.StatusOK))
+		Expect(list.GroupVersion).To(Equal("custom.metrics.k8s.io/v1beta2"))
+		Expect(list.APIResources).To(HaveLen(len(Metrics)))
+		Expect(list.APIResources[0]).To(Equal(metav1.APIResource{
+			Name: "pods/sriov_vf_rx_bytes", Namespaced: true, Kind: "MetricValueList", Verbs: metav1.Verbs{"get"},
+		}))
+	})
+
+	It("serves the rate of a pod", func() {
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/cnf-1/sriov_vf_rx_bytes", &list)).To(Equal(http.StatusOK))
+		Expect(list.Items).To(HaveLen(1))
+		Expect(list.Items[0].DescribedObject).To(Equal(corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: "default", Name: "cnf-1"}))
+		Expect(list.Items[0].Metric.Name).To(Equal("sriov_vf_rx_bytes"))
+		Expect(list.Items[0].Value.MilliValue()).To(Equal(int64(100000)))
+		Expect(*list.Items[0].WindowSeconds).To(Equal(int64(10)))
+	})
+
+	It("serves the rates of the pods matching a label selector", func() {
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/*/sriov_vf_rx_bytes?labelSelector=app%3Dcnf", &list)).To(Equal(http.StatusOK))
+		Expect(describedPods(list)).To(ConsistOf("cnf-1", "cnf-2"))
+	})
+
+	DescribeTable("rejects requests that can not be served",
+		func(path string, code int) {
+			status := metav1.Status{}
+			Expect(get(handler, path, &status)).To(Equal(code))
+			Expect(status.Code).To(Equal(int32(code)))
+		},
+		Entry("unknown metric", APIPath+"/namespaces/default/pods/cnf-1/sriov_vf_unknown", http.StatusNotFound),
+		Entry("pod without vf", APIPath+"/namespaces/default/pods/no-vf/sriov_vf_rx_bytes", http.StatusNotFound),
+		Entry("invalid label selector", APIPath+"/namespaces/default/pods/*/sriov_vf_rx_bytes?labelSelector=app%3D%3D%3D", http.StatusBadRequest),
+	)
+})
+
+var _ = Describe("test serving the pods of the other nodes", func() { // server.fromPeers
+	var handler http.Handler
+
+	BeforeEach(func() {
+		peer := httptest.NewTLSServer(NewHandler(newTestRates("remote"), fakePods{newPod("remote", map[string]string{"app": "cnf"})}, nil, nil))
+		DeferCleanup(peer.Close)
+		failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		DeferCleanup(failing.Close)
+
+		peers := func(context.Context) ([]string, error) { return []string{peer.URL, failing.URL}, nil }
+		handler = NewHandler(newTestRates("local"), fakePods{newPod("local", map[string]string{"app": "cnf"})}, peers, peer.Client())
+	})
+
+	It("adds the pods of the peers to the selected pods", func() {
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/*/sriov_vf_rx_bytes?labelSelector=app%3Dcnf", &list)).To(Equal(http.StatusOK))
+		Expect(describedPods(list)).To(ConsistOf("local", "remote"))
+	})
+
+	It("asks the peers for a pod of another node", func() {
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/remote/sriov_vf_rx_bytes", &list)).To(Equal(http.StatusOK))
+		Expect(describedPods(list)).To(ConsistOf("remote"))
+	})
+
+	It("only serves the pods of the node to a peer", func() {
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/*/sriov_vf_rx_bytes?local=true", &list)).To(Equal(http.StatusOK))
+		Expect(describedPods(list)).To(ConsistOf("local"))
+	})
+
+	It("serves the pods of the node when the peers can not be listed", func() {
+		peers := func(context.Context) ([]string, error) { return nil, errors.New("forbidden") }
+		handler = NewHandler(newTestRates("local"), fakePods{newPod("local", nil)}, peers, http.DefaultClient)
+
+		list := v1beta2.MetricValueList{}
+		Expect(get(handler, APIPath+"/namespaces/default/pods/*/sriov_vf_rx_bytes", &list)).To(Equal(http.StatusOK))
+		Expect(describedPods(list)).To(ConsistOf("local"))
+	})
+})
+
+var _ = It("generates a self-signed certificate", func() { // SelfSignedCertificate
+	cert, err := SelfSignedCertificate()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(cert.Certificate).To(HaveLen(1))
+	Expect(cert.PrivateKey).ToNot(BeNil())
+})
@@ -0,0 +1,210 @@
+// Package deviceplugin reads the resource pools of the SR-IOV network device plugin config and matches devices to
+// them the way the device plugin selects the devices it advertises.
+package deviceplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultResourcePrefix is the prefix of resource names without a resourcePrefix, as the device plugin defaults to
+const DefaultResourcePrefix = "intel.com"
+
+// Config is the resource list of the device plugin config
+type Config struct {
+	ResourceList []ResourcePool `json:"resourceList"`
+}
+
+// ResourcePool is a resource advertised by the device plugin, with the selectors of its devices. Selectors can be
+// given as a single object or as a list of objects, of which any has to match.
+type ResourcePool struct {
+	ResourceName   string    `json:"resourceName"`
+	ResourcePrefix string    `json:"resourcePrefix"`
+	DeviceType     string    `json:"deviceType"`
+	Selectors      Selectors `json:"selectors"`
+}
+
+// Selectors are the alternative selectors of a resource pool
+type Selectors []Selector
+
+// Selector selects the devices matching all of its non-empty fields, a field matches if any of its values match.
+// NeedVhostNet adds the vhost-net device to the allocated containers and does not restrict the selected devices.
+type Selector struct {
+	Vendors      []string `json:"vendors"`
+	Devices      []string `json:"devices"`
+	Drivers      []string `json:"drivers"`
+	PFNames      []string `json:"pfNames"`
+	RootDevices  []string `json:"rootDevices"`
+	PCIAddresses []string `json:"pciAddresses"`
+	NeedVhostNet bool     `json:"needVhostNet"`
+
+	// Selectors on the link, the firmware and the capabilities of a device, which are not matched
+	LinkTypes   []string `json:"linkTypes"`
+	DDPProfiles []string `json:"ddpProfiles"`
+	IsRdma      bool     `json:"isRdma"`
+	VdpaType    string   `json:"vdpaType"`
+	AuxTypes    []string `json:"auxTypes"`
+}
+
+// Device is the information of a VF that the selectors match on
+type Device struct {
+	Vendor     string
+	DeviceID   string
+	Driver     string
+	PFName     string
+	VFIndex    int
+	RootDevice string
+	PCIAddress string
+}
+
+// UnmarshalJSON accepts a single selector object or a list of them
+func (s *Selectors) UnmarshalJSON(data []byte) error {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var selector Selector
+		if err := json.Unmarshal(data, &selector); err != nil {
+			return err
+		}
+		*s = Selectors{selector}
+		return nil
+	}
+
+	var selectors []Selector
+	if err := json.Unmarshal(data, &selectors); err != nil {
+		return err
+	}
+	*s = selectors
+
+	return nil
+}
+
+// Load reads the device plugin config file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read device plugin config '%s'\n%v", path, err)
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid device plugin config '%s'\n%v", path, err)
+	}
+
+	return config, nil
+}
+
+// Name returns the full name of the resource as advertised to the kubelet, i.e. prefix/name
+func (p ResourcePool) Name() string {
+	prefix := p.ResourcePrefix
+	if prefix == "" {
+		prefix = DefaultResourcePrefix
+	}
+
+	return prefix + "/" + p.ResourceName
+}
+
+// Pool returns the full name of the first resource pool selecting the device, or an empty string if none does.
+// Only net device pools are matched, other device types such as accelerators do not select VFs of NICs.
+// Pools using unsupported selectors are skipped, as it can not be told which devices they select.
+func (c *Config) Pool(dev Device) string {
+	for _, pool := range c.netDevicePools() {
+		if len(pool.UnsupportedSelectors()) > 0 {
+			continue
+		}
+
+		for _, selector := range pool.Selectors {
+			if selector.matches(dev) {
+				return pool.Name()
+			}
+		}
+	}
+
+	return ""
+}
+
+// Unsupported returns the full names of the net device pools using unsupported selectors
+func (c *Config) Unsupported() []string {
+	var names []string
+	for _, pool := range c.netDevicePools() {
+		if len(pool.UnsupportedSelectors()) > 0 {
+			names = append(names, pool.Name())
+		}
+	}
+
+	return names
+}
+
+func (c *Config) netDevicePools() []ResourcePool {
+	pools := make([]ResourcePool, 0, len(c.ResourceList))
+	for _, pool := range c.ResourceList {
+		if pool.DeviceType == "" || pool.DeviceType == "netDevice" {
+			pools = append(pools, pool)
+		}
+	}
+
+	return pools
+}
+
+// UnsupportedSelectors returns the names of the selector fields used by the pool that are not matched
+func (p ResourcePool) UnsupportedSelectors() []string {
+	var fields []string
+	add := func(used bool, field string) {
+		if used && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	for _, s := range p.Selectors {
+		add(len(s.LinkTypes) > 0, "linkTypes")
+		add(len(s.DDPProfiles) > 0, "ddpProfiles")
+		add(s.IsRdma, "isRdma")
+		add(s.VdpaType != "", "vdpaType")
+		add(len(s.AuxTypes) > 0, "auxTypes")
+	}
+
+	return fields
+}
+
+func (s Selector) matches(dev Device) bool {
+	return matchesAny(s.Vendors, dev.Vendor) &&
+		matchesAny(s.Devices, dev.DeviceID) &&
+		matchesAny(s.Drivers, dev.Driver) &&
+		matchesAny(s.RootDevices, dev.RootDevice) &&
+		matchesAny(s.PCIAddresses, dev.PCIAddress) &&
+		(len(s.PFNames) == 0 || slices.ContainsFunc(s.PFNames, func(pfName string) bool { return matchesPFName(pfName, dev) }))
+}
+
+// matchesAny returns true if the values are empty or contain the value
+func matchesAny(values []string, value string) bool {
+	return len(values) == 0 || slices.Contains(values, value)
+}
+
+// matchesPFName matches a pfNames entry, which is a PF interface name optionally followed by '#' and the VF indices
+// it selects, e.g. "ens1f0#0-3,6"
+func matchesPFName(selector string, dev Device) bool {
+	name, ranges, found := strings.Cut(selector, "#")
+	if name != dev.PFName {
+		return false
+	}
+	if !found {
+		return true
+	}
+
+	for _, r := range strings.Split(ranges, ",") {
+		first, last, isRange := strings.Cut(r, "-")
+		if !isRange {
+			last = first
+		}
+
+		start, errStart := strconv.Atoi(strings.TrimSpace(first))
+		end, errEnd := strconv.Atoi(strings.TrimSpace(last))
+		if errStart == nil && errEnd == nil && dev.VFIndex >= start && dev.VFIndex <= end {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDeviceplugin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deviceplugin test suite")
+}
+
+var _ = DescribeTable("test loading the device plugin config", // Load
+	func(data string, expected *Config, expectedErr string) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(data), 0o600)).To(Succeed())
+
+		config, err := Load(path)
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(config).To(Equal(expected))
+	},
+	Entry("selector object and list",
+		`{"resourceList": [
+			{"resourceName": "sriov_a", "selectors": {"vendors": ["8086"], "pfNames": ["ens1f0#0-1"]}},
+			{"resourceName": "sriov_b", "resourcePrefix": "example.com", "deviceType": "netDevice",
+			 "selectors": [{"drivers": ["iavf"]}, {"rootDevices": ["0000:5e:00.0"]}]}
+		]}`,
+		&Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_a", Selectors: Selectors{{Vendors: []string{"8086"}, PFNames: []string{"ens1f0#0-1"}}}},
+			{ResourceName: "sriov_b", ResourcePrefix: "example.com", DeviceType: "netDevice",
+				Selectors: Selectors{{Drivers: []string{"iavf"}}, {RootDevices: []string{"0000:5e:00.0"}}}},
+		}},
+		""),
+	Entry("malformed config", `{"resourceList": [`, nil, "invalid device plugin config"),
+	Entry("malformed selectors", `{"resourceList": [{"resourceName": "a", "selectors": "iavf"}]}`, nil, "invalid device plugin config"),
+)
+
+var _ = It("fails to load a missing config", func() { // Load
+	_, err := Load(filepath.Join(GinkgoT().TempDir(), "missing.json"))
+	Expect(err).To(MatchError(ContainSubstring("could not read device plugin config")))
+})
+
+var _ = It("lists the pools using unsupported selectors", func() { // Config.Unsupported
+	config := Config{ResourceList: []ResourcePool{
+		{ResourceName: "sriov_a", Selectors: Selectors{{Vendors: []string{"8086"}}}},
+		{ResourceName: "sriov_b", Selectors: Selectors{{IsRdma: true}, {LinkTypes: []string{"ether"}, VdpaType: "vhost"}}},
+		{ResourceName: "qat", DeviceType: "accelerator", Selectors: Selectors{{DDPProfiles: []string{"gtp"}}}},
+	}}
+
+	Expect(config.Unsupported()).To(Equal([]string{"intel.com/sriov_b"}))
+	Expect(config.ResourceList[1].UnsupportedSelectors()).To(Equal([]string{"isRdma", "linkTypes", "vdpaType"}))
+})
+
+var _ = DescribeTable("test matching a device to a resource pool", // Config.Pool
+	func(config Config, dev Device, expected string) {
+		Expect(config.Pool(dev)).To(Equal(expected))
+	},
+	Entry("all fields of a selector match",
+		Config{ResourceList: []ResourcePool{{ResourceName: "sriov_a", Selectors: Selectors{{
+			Vendors: []string{"8086"}, Devices: []string{"154c", "1889"}, Drivers: []string{"iavf"}, RootDevices: []string{"0000:3b:00.0"},
+		}}}}},
+		Device{Vendor: "8086", DeviceID: "1889", Driver: "iavf", PFName: "ens1f0", RootDevice: "0000:3b:00.0"},
+		"intel.com/sriov_a"),
+	Entry("one field of a selector does not match",
+		Config{ResourceList: []ResourcePool{{ResourceName: "sriov_a", Selectors: Selectors{{
+			Vendors: []string{"8086"}, Drivers: []string{"vfio-pci"},
+		}}}}},
+		Device{Vendor: "8086", DeviceID: "1889", Driver: "iavf", PFName: "ens1f0"},
+		""),
+	Entry("any selector of a list matches",
+		Config{ResourceList: []ResourcePool{{ResourceName: "sriov_a", ResourcePrefix: "example.com", Selectors: Selectors{
+			{Drivers: []string{"vfio-pci"}}, {PFNames: []string{"ens1f0"}},
+		}}}},
+		Device{Driver: "iavf", PFName: "ens1f0"},
+		"example.com/sriov_a"),
+	Entry("first matching pool wins",
+		Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_a", Selectors: Selectors{{PFNames: []string{"ens1f0#0-3,6"}}}},
+			{ResourceName: "sriov_b", Selectors: Selectors{{PFNames: []string{"ens1f0"}}}},
+		}},
+		Device{PFName: "ens1f0", VFIndex: 6},
+		"intel.com/sriov_a"),
+	Entry("vf index outside of the pf name range",
+		Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_a", Selectors: Selectors{{PFNames: []string{"ens1f0#0-3,6"}}}},
+			{ResourceName: "sriov_b", Selectors: Selectors{{PFNames: []string{"ens1f0"}}}},
+		}},
+		Device{PFName: "ens1f0", VFIndex: 4},
+		"intel.com/sriov_b"),
+	Entry("pci address of a selector matches",
+		Config{ResourceList: []ResourcePool{{ResourceName: "sriov_a", Selectors: Selectors{{
+			PCIAddresses: []string{"0000:3b:02.0"}, NeedVhostNet: true,
+		}}}}},
+		Device{PCIAddress: "0000:3b:02.0"},
+		"intel.com/sriov_a"),
+	Entry("pool with unsupported selectors",
+		Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_rdma", Selectors: Selectors{{Vendors: []string{"15b3"}, IsRdma: true}}},
+			{ResourceName: "sriov_b", Selectors: Selectors{{Vendors: []string{"15b3"}}}},
+		}},
+		Device{Vendor: "15b3"},
+		"intel.com/sriov_b"),
+	Entry("only pool with unsupported selectors",
+		Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_rdma", Selectors: Selectors{{Vendors: []string{"15b3"}, IsRdma: true}}},
+		}},
+		Device{Vendor: "15b3"},
+		""),
+	Entry("pool with unsupported selectors not matching the device",
+		Config{ResourceList: []ResourcePool{
+			{ResourceName: "sriov_ib", Selectors: Selectors{{Vendors: []string{"8086"}, LinkTypes: []string{"infiniband"}}}},
+			{ResourceName: "sriov_b", Selectors: Selectors{{Vendors: []string{"15b3"}}}},
+		}},
+		Device{Vendor: "15b3"},
+		"intel.com/sriov_b"),
+	Entry("pool of another device type",
+		Config{ResourceList: []ResourcePool{{ResourceName: "qat", DeviceType: "accelerator", Selectors: Selectors{{Vendors: []string{"8086"}}}}}},
+		Device{Vendor: "8086"},
+		""),
+)
@@ -0,0 +1,394 @@
+// Package fakehost builds synthetic SR-IOV hosts for tests and demos. A host of N PFs with M VFs each is rendered as
+// the sysfs, cgroup and NUMA filesystems read by the collectors, together with its pod resources and netlink links,
+// so collectors can be exercised at scale and the exporter can run on machines without SR-IOV NICs.
+package fakehost
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing/fstest"
+
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	netClass         = "0x020000"
+	driversPath      = "/sys/bus/pci/drivers"
+	devicesPath      = "/sys/devices"
+	checkpointFile   = "cpu_manager_state"
+	cpuSetFile       = "cpuset.cpus"
+	podNamespace     = "default"
+	podResourceName  = "example.com/sriov"
+	vfsPerPCIDevice  = 8
+	bytesPerPacket   = 64
+	packetsPerVF     = 1000
+	packetsPerPF     = 1000000
+	defaultDriver    = "ice"
+	containerName    = "app"
+	containerIDBytes = 32
+)
+
+// Options describes the host to generate
+type Options struct {
+	PFs         int    // number of PFs
+	VFsPerPF    int    // number of VFs enabled on each PF
+	Driver      string // driver bound to the PFs
+	NUMANodes   int    // number of NUMA nodes, PFs and cpus are spread across them
+	CPUsPerNode int    // number of cpus on each NUMA node
+	SysfsStats  bool   // whether the PFs expose their VF stats in sysfs
+	Switchdev   bool   // whether the PFs are in switchdev mode, with a representor for each VF
+	Pods        int    // number of pods, each assigned one VF and one exclusive cpu while available
+}
+
+// DefaultOptions is a small host on two NUMA nodes with two pods
+var DefaultOptions = Options{PFs: 2, VFsPerPF: 4, Driver: defaultDriver, NUMANodes: 2, CPUsPerNode: 4, SysfsStats: true, Pods: 2}
+
+// Host is a synthetic SR-IOV host
+type Host struct {
+	PFs  []PF
+	CPUs []CPU
+	Pods []Pod
+}
+
+// PF is a physical function and its VFs
+type PF struct {
+	Addr       string
+	Name       string
+	Driver     string
+	NUMANode   int
+	SysfsStats bool
+	Switchdev  bool
+	VFs        []VF
+}
+
+// VF is a virtual function and its stats, keyed by their sysfs name
+type VF struct {
+	ID    int
+	Addr  string
+	Stats map[string]uint64
+}
+
+// CPU is a cpu and its NUMA node
+type CPU struct {
+	ID       int
+	NUMANode int
+}
+
+// Pod is a guaranteed pod with a single container
+type Pod struct {
+	UID         string
+	Name        string
+	ContainerID string
+	CPUs        []int
+	Devices     []string
+}
+
+// New generates a host. Addresses, names and stats are derived from the position of each device,
+// so the same options always generate the same host.
+func New(opts Options) *Host {
+	if opts.Driver == "" {
+		opts.Driver = defaultDriver
+	}
+	opts.NUMANodes = max(opts.NUMANodes, 1)
+
+	h := &Host{}
+	for i := range opts.PFs {
+		pf := PF{
+			Addr:       fmt.Sprintf("0000:%02x:00.0", i+1),
+			Name:       fmt.Sprintf("ens%df0", i+1),
+			Driver:     opts.Driver,
+			NUMANode:   i % opts.NUMANodes,
+			SysfsStats: opts.SysfsStats,
+			Switchdev:  opts.Switchdev,
+		}
+		for id := range opts.VFsPerPF {
+			pf.VFs = append(pf.VFs, VF{
+				ID:    id,
+				Addr:  fmt.Sprintf("0000:%02x:%02x.%d", i+1, id/vfsPerPCIDevice+1, id%vfsPerPCIDevice),
+				Stats: vfStats(uint64((i+1)*packetsPerPF + (id+1)*packetsPerVF)), //nolint:gosec // G115: small positive values
+			})
+		}
+		h.PFs = append(h.PFs, pf)
+	}
+
+	for id := range opts.NUMANodes * opts.CPUsPerNode {
+		h.CPUs = append(h.CPUs, CPU{ID: id, NUMANode: id / max(opts.CPUsPerNode, 1)})
+	}
+
+	for i := range opts.Pods {
+		pod := Pod{
+			UID:         fmt.Sprintf("%08x-0000-4000-8000-%012x", i+1, i+1),
+			Name:        fmt.Sprintf("pod%d", i+1),
+			ContainerID: fmt.Sprintf("%0*x", containerIDBytes*2, i+1), //nolint:mnd
+		}
+		// cpu 0 is left to the shared pool
+		if cpu := i + 1; cpu < len(h.CPUs) {
+			pod.CPUs = []int{cpu}
+		}
+		if pf, vf := i%max(opts.PFs, 1), i/max(opts.PFs, 1); pf < len(h.PFs) && vf < len(h.PFs[pf].VFs) {
+			pod.Devices = []string{h.PFs[pf].VFs[vf].Addr}
+		}
+		h.Pods = append(h.Pods, pod)
+	}
+
+	return h
+}
+
+// vfStats returns the stats of a VF that received and sent the given number of packets
+func vfStats(packets uint64) map[string]uint64 {
+	return map[string]uint64{
+		"rx_packets":   packets,
+		"tx_packets":   packets * 2, //nolint:mnd
+		"rx_bytes":     packets * bytesPerPacket,
+		"tx_bytes":     packets * 2 * bytesPerPacket, //nolint:mnd
+		"rx_dropped":   packets / packetsPerVF,
+		"tx_dropped":   0,
+		"rx_broadcast": packets / 10,  //nolint:mnd
+		"rx_multicast": packets / 100, //nolint:mnd
+	}
+}
+
+// DevFS returns the PF directories of sys/bus/pci/devices. Symlinks hold their absolute target as data,
+// and can be resolved with EvalSymlinks.
+func (h *Host) DevFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, pf := range h.PFs {
+		fsys[pf.Addr+"/sriov_totalvfs"] = file(strconv.Itoa(max(len(pf.VFs), vfsPerPCIDevice)))
+		fsys[pf.Addr+"/sriov_numvfs"] = file(strconv.Itoa(len(pf.VFs)))
+		fsys[pf.Addr+"/class"] = file(netClass)
+		fsys[pf.Addr+"/numa_node"] = file(strconv.Itoa(pf.NUMANode))
+		fsys[pf.Addr+"/net/"+pf.Name] = &fstest.MapFile{Mode: fs.ModeDir}
+		fsys[pf.Addr+"/driver"] = symlink(filepath.Join(driversPath, pf.Driver))
+		for _, vf := range pf.VFs {
+			fsys[fmt.Sprintf("%s/virtfn%d", pf.Addr, vf.ID)] = symlink(filepath.Join(devicesPath, vf.Addr))
+		}
+	}
+
+	return fsys
+}
+
+// NetFS returns the PF and representor directories of sys/class/net with the sysfs VF stats
+func (h *Host) NetFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i, pf := range h.PFs {
+		fsys[pf.Name+"/device"] = &fstest.MapFile{Mode: fs.ModeDir}
+
+		if pf.SysfsStats {
+			for _, vf := range pf.VFs {
+				for stat, value := range vf.Stats {
+					fsys[fmt.Sprintf("%s/device/sriov/%d/stats/%s", pf.Name, vf.ID, stat)] = file(strconv.FormatUint(value, 10))
+				}
+			}
+		}
+
+		if pf.Switchdev {
+			switchID := fmt.Sprintf("%016x", i+1)
+			fsys[pf.Name+"/phys_switch_id"] = file(switchID)
+			fsys[pf.Name+"/phys_port_name"] = file("p0")
+			for _, vf := range pf.VFs {
+				rep := fmt.Sprintf("%s_%d", pf.Name, vf.ID)
+				fsys[rep+"/phys_switch_id"] = file(switchID)
+				fsys[rep+"/phys_port_name"] = file(fmt.Sprintf("pf0vf%d", vf.ID))
+			}
+		}
+	}
+
+	return fsys
+}
+
+// CgroupFS returns the kubernetes cpuset cgroups, with a cgroup for the container of each pod
+func (h *Host) CgroupFS() fstest.MapFS {
+	all := make([]int, 0, len(h.CPUs))
+	for _, cpu := range h.CPUs {
+		all = append(all, cpu.ID)
+	}
+
+	fsys := fstest.MapFS{cpuSetFile: file(cpuList(all))}
+	for _, pod := range h.Pods {
+		cpus := all
+		if len(pod.CPUs) > 0 {
+			cpus = pod.CPUs
+		}
+		dir := "kubepods-pod" + strings.ReplaceAll(pod.UID, "-", "_") + ".slice"
+		fsys[filepath.Join(dir, pod.ContainerID, cpuSetFile)] = file(cpuList(cpus))
+	}
+
+	return fsys
+}
+
+// NodeFS returns the cpus of each NUMA node of sys/devices/system/node
+func (h *Host) NodeFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, cpu := range h.CPUs {
+		fsys[fmt.Sprintf("node%d/cpu%d", cpu.NUMANode, cpu.ID)] = &fstest.MapFile{Mode: fs.ModeDir}
+	}
+
+	return fsys
+}
+
+// CheckpointFS returns the directory of the cpu manager checkpoint, with the cpus not assigned to pods as default set
+func (h *Host) CheckpointFS() fstest.MapFS {
+	assigned := make(map[int]bool)
+	for _, pod := range h.Pods {
+		for _, cpu := range pod.CPUs {
+			assigned[cpu] = true
+		}
+	}
+
+	shared := make([]int, 0, len(h.CPUs))
+	for _, cpu := range h.CPUs {
+		if !assigned[cpu.ID] {
+			shared = append(shared, cpu.ID)
+		}
+	}
+
+	return fstest.MapFS{
+		checkpointFile: file(fmt.Sprintf(`{"policyName":"static","defaultCpuSet":"%s","checksum":1}`, cpuList(shared))),
+	}
+}
+
+// PodResources returns the pod resources listed by the kubelet
+func (h *Host) PodResources() []*v1.PodResources {
+	resources := make([]*v1.PodResources, 0, len(h.Pods))
+	for _, pod := range h.Pods {
+		container := &v1.ContainerResources{Name: containerName}
+		if len(pod.Devices) > 0 {
+			container.Devices = []*v1.ContainerDevices{{ResourceName: podResourceName, DeviceIds: pod.Devices}}
+		}
+		for _, cpu := range pod.CPUs {
+			container.CpuIds = append(container.CpuIds, int64(cpu))
+		}
+
+		resources = append(resources, &v1.PodResources{
+			Name:       pod.Name,
+			Namespace:  podNamespace,
+			Containers: []*v1.ContainerResources{container},
+		})
+	}
+
+	return resources
+}
+
+// Links returns a netlink link for each PF with the stats of its VFs
+func (h *Host) Links() []netlink.Link {
+	links := make([]netlink.Link, 0, len(h.PFs))
+	for _, pf := range h.PFs {
+		links = append(links, &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: pf.Name, Vfs: h.vfInfos(pf)}})
+	}
+
+	return links
+}
+
+func (h *Host) vfInfos(pf PF) []netlink.VfInfo {
+	infos := make([]netlink.VfInfo, 0, len(pf.VFs))
+	for _, vf := range pf.VFs {
+		infos = append(infos, netlink.VfInfo{
+			ID:        vf.ID,
+			RxPackets: vf.Stats["rx_packets"],
+			TxPackets: vf.Stats["tx_packets"],
+			RxBytes:   vf.Stats["rx_bytes"],
+			TxBytes:   vf.Stats["tx_bytes"],
+			RxDropped: vf.Stats["rx_dropped"],
+			TxDropped: vf.Stats["tx_dropped"],
+			Broadcast: vf.Stats["rx_broadcast"],
+			Multicast: vf.Stats["rx_multicast"],
+		})
+	}
+
+	return infos
+}
+
+// EvalSymlinks resolves the symlinks of DevFS, matching the PF directory and link name of the path
+func (h *Host) EvalSymlinks(path string) (string, error) {
+	dir, name := filepath.Base(filepath.Dir(path)), filepath.Base(path)
+	for _, pf := range h.PFs {
+		if pf.Addr != dir {
+			continue
+		}
+		if name == "driver" {
+			return filepath.Join(driversPath, pf.Driver), nil
+		}
+		for _, vf := range pf.VFs {
+			if name == fmt.Sprintf("virtfn%d", vf.ID) {
+				return filepath.Join(devicesPath, vf.Addr), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%s - not a symlink or not found", path)
+}
+
+// Netlink is a fake of the netlink link functions, serving the links of a host and counting the link dumps
+type Netlink struct {
+	mu    sync.Mutex
+	links []netlink.Link
+	dumps int
+}
+
+// Netlink returns a netlink fake serving the links of the host
+func (h *Host) Netlink() *Netlink {
+	return &Netlink{links: h.Links()}
+}
+
+// LinkList returns all links, like netlink.LinkList
+func (n *Netlink) LinkList() ([]netlink.Link, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dumps++
+	return n.links, nil
+}
+
+// LinkByName returns the link with the given name, like netlink.LinkByName
+func (n *Netlink) LinkByName(name string) (netlink.Link, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, link := range n.links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+
+	return nil, fmt.Errorf("link %s not found", name)
+}
+
+// Dumps returns the number of times all links were listed
+func (n *Netlink) Dumps() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.dumps
+}
+
+func file(data string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(data)}
+}
+
+func symlink(target string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+}
+
+// cpuList formats cpus in the kernel cpuset list format, collapsing consecutive cpus into ranges
+func cpuList(cpus []int) string {
+	parts := make([]string, 0, len(cpus))
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(parts, ",")
+}
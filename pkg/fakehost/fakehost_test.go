@@ -0,0 +1,155 @@
+package fakehost
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFakehost(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "fakehost test suite")
+}
+
+var _ = Describe("test generating a host", func() { // New
+	It("generates the PFs, VFs, cpus and pods", func() {
+		h := New(Options{PFs: 2, VFsPerPF: 10, NUMANodes: 2, CPUsPerNode: 2, Pods: 3})
+
+		Expect(h.PFs).To(HaveLen(2))
+		Expect(h.PFs[1].Addr).To(Equal("0000:02:00.0"))
+		Expect(h.PFs[1].Name).To(Equal("ens2f0"))
+		Expect(h.PFs[1].Driver).To(Equal("ice"))
+		Expect(h.PFs[1].NUMANode).To(Equal(1))
+		Expect(h.PFs[1].VFs).To(HaveLen(10))
+		Expect(h.PFs[1].VFs[9].Addr).To(Equal("0000:02:02.1"))
+		Expect(h.PFs[1].VFs[9].Stats).To(HaveKeyWithValue("rx_packets", uint64(2010000)))
+
+		Expect(h.CPUs).To(Equal([]CPU{{0, 0}, {1, 0}, {2, 1}, {3, 1}}))
+
+		Expect(h.Pods).To(HaveLen(3))
+		Expect(h.Pods[0].UID).To(Equal("00000001-0000-4000-8000-000000000001"))
+		Expect(h.Pods[0].ContainerID).To(HaveLen(64))
+		Expect(h.Pods[0].CPUs).To(Equal([]int{1}))
+		Expect(h.Pods[0].Devices).To(Equal([]string{"0000:01:01.0"}))
+		Expect(h.Pods[1].Devices).To(Equal([]string{"0000:02:01.0"}))
+		Expect(h.Pods[2].Devices).To(Equal([]string{"0000:01:01.1"}))
+	})
+
+	It("generates the same host for the same options", func() {
+		Expect(New(DefaultOptions)).To(Equal(New(DefaultOptions)))
+	})
+
+	It("leaves pods without cpus and devices once all are assigned", func() {
+		h := New(Options{PFs: 1, VFsPerPF: 1, CPUsPerNode: 2, Pods: 2})
+
+		Expect(h.Pods[1].CPUs).To(BeEmpty())
+		Expect(h.Pods[1].Devices).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test rendering the host filesystems", func() { // DevFS, NetFS, CgroupFS, NodeFS, CheckpointFS
+	h := New(Options{PFs: 1, VFsPerPF: 2, Driver: "mlx5_core", NUMANodes: 2, CPUsPerNode: 2, Switchdev: true, Pods: 2})
+
+	It("renders the PF devices with symlinks", func() {
+		fsys := h.DevFS()
+
+		Expect(fsys).To(HaveKeyWithValue("0000:01:00.0/sriov_numvfs", file("2")))
+		Expect(fsys).To(HaveKeyWithValue("0000:01:00.0/numa_node", file("0")))
+		Expect(fsys).To(HaveKeyWithValue("0000:01:00.0/driver", symlink("/sys/bus/pci/drivers/mlx5_core")))
+		Expect(fsys).To(HaveKeyWithValue("0000:01:00.0/virtfn1", symlink("/sys/devices/0000:01:01.1")))
+		Expect(fsys["0000:01:00.0/net/ens1f0"].Mode.IsDir()).To(BeTrue())
+	})
+
+	It("renders representors instead of sysfs stats in switchdev mode", func() {
+		fsys := h.NetFS()
+
+		Expect(fsys).To(HaveKeyWithValue("ens1f0_1/phys_port_name", file("pf0vf1")))
+		Expect(fsys).To(HaveKeyWithValue("ens1f0_1/phys_switch_id", fsys["ens1f0/phys_switch_id"]))
+		matches, err := fs.Glob(fsys, "ens1f0/device/sriov/*/stats/*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(matches).To(BeEmpty())
+	})
+
+	It("renders the sysfs stats", func() {
+		fsys := New(Options{PFs: 1, VFsPerPF: 2, SysfsStats: true}).NetFS()
+
+		Expect(fsys).To(HaveKeyWithValue("ens1f0/device/sriov/1/stats/tx_bytes", file("128256000")))
+	})
+
+	It("renders the cgroups, NUMA nodes and checkpoint", func() {
+		Expect(h.CgroupFS()).To(Equal(fstest.MapFS{
+			"cpuset.cpus": file("0-3"),
+			"kubepods-pod00000001_0000_4000_8000_000000000001.slice/" + h.Pods[0].ContainerID + "/cpuset.cpus": file("1"),
+			"kubepods-pod00000002_0000_4000_8000_000000000002.slice/" + h.Pods[1].ContainerID + "/cpuset.cpus": file("2"),
+		}))
+		Expect(h.NodeFS()).To(HaveKey("node1/cpu3"))
+		Expect(string(h.CheckpointFS()["cpu_manager_state"].Data)).To(ContainSubstring(`"defaultCpuSet":"0,3"`))
+	})
+})
+
+var _ = Describe("test listing pod resources", func() { // PodResources
+	It("lists the devices and cpus of each pod", func() {
+		resources := New(Options{PFs: 1, VFsPerPF: 1, CPUsPerNode: 2, Pods: 2}).PodResources()
+
+		Expect(resources).To(HaveLen(2))
+		Expect(resources[0].GetName()).To(Equal("pod1"))
+		Expect(resources[0].GetContainers()[0].GetDevices()[0].GetDeviceIds()).To(Equal([]string{"0000:01:01.0"}))
+		Expect(resources[0].GetContainers()[0].GetCpuIds()).To(Equal([]int64{1}))
+		Expect(resources[1].GetContainers()[0].GetDevices()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test resolving symlinks", func() { // EvalSymlinks
+	h := New(Options{PFs: 1, VFsPerPF: 2})
+
+	DescribeTable("resolves the links of DevFS",
+		func(path, expected string, expectedErr bool) {
+			target, err := h.EvalSymlinks(path)
+			if expectedErr {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target).To(Equal(expected))
+		},
+		Entry("vf", "/sys/bus/pci/devices/0000:01:00.0/virtfn1", "/sys/devices/0000:01:01.1", false),
+		Entry("driver", "/sys/bus/pci/devices/0000:01:00.0/driver", "/sys/bus/pci/drivers/ice", false),
+		Entry("missing vf", "/sys/bus/pci/devices/0000:01:00.0/virtfn2", "", true),
+		Entry("missing pf", "/sys/bus/pci/devices/0000:02:00.0/driver", "", true),
+	)
+})
+
+var _ = Describe("test the netlink fake", func() { // Netlink
+	It("lists links and counts dumps", func() {
+		n := New(Options{PFs: 2, VFsPerPF: 3}).Netlink()
+
+		links, err := n.LinkList()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(links).To(HaveLen(2))
+		Expect(links[1].Attrs().Vfs).To(HaveLen(3))
+		Expect(links[1].Attrs().Vfs[2].RxPackets).To(Equal(uint64(2003000)))
+		Expect(n.Dumps()).To(Equal(1))
+
+		link, err := n.LinkByName("ens2f0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(link.Attrs().Name).To(Equal("ens2f0"))
+		Expect(n.Dumps()).To(Equal(1))
+
+		_, err = n.LinkByName("ens3f0")
+		Expect(err).To(MatchError("link ens3f0 not found"))
+	})
+})
+
+var _ = DescribeTable("test formatting cpu lists", // cpuList
+	func(cpus []int, expected string) {
+		Expect(cpuList(cpus)).To(Equal(expected))
+	},
+	Entry("empty", []int{}, ""),
+	Entry("single", []int{3}, "3"),
+	Entry("range", []int{0, 1, 2, 3}, "0-3"),
+	Entry("mixed", []int{0, 2, 3, 4, 7}, "0,2-4,7"),
+)
@@ -0,0 +1,107 @@
+package fakehost
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing/fstest"
+
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+)
+
+// WriteSnapshot writes the host as a snapshot archive, which can be replayed by the exporter
+func (h *Host) WriteSnapshot(w io.Writer) error {
+	sw := snapshot.NewWriter(w)
+
+	dirs := []struct {
+		dir  string
+		fsys fstest.MapFS
+	}{
+		{snapshot.PCIDir, h.DevFS()},
+		{snapshot.NetDir, h.NetFS()},
+		{snapshot.CgroupDir, h.CgroupFS()},
+		{snapshot.NodeDir, h.NodeFS()},
+		{snapshot.CheckpointDir, h.CheckpointFS()},
+	}
+	for _, d := range dirs {
+		if err := writeDir(sw, d.dir, d.fsys); err != nil {
+			return err
+		}
+	}
+
+	vfs := make(map[string][]netlink.VfInfo, len(h.PFs))
+	for _, pf := range h.PFs {
+		vfs[pf.Name] = h.vfInfos(pf)
+	}
+	data, err := json.Marshal(vfs)
+	if err != nil {
+		return err
+	}
+	if err := sw.File(snapshot.NetlinkFile, data); err != nil {
+		return err
+	}
+
+	data, err = protojson.Marshal(&v1.ListPodResourcesResponse{PodResources: h.PodResources()})
+	if err != nil {
+		return err
+	}
+	if err := sw.File(snapshot.PodResourcesFile, data); err != nil {
+		return err
+	}
+
+	return sw.Close()
+}
+
+// writeDir adds the entries of fsys under dir. Symlinks to drivers and devices are rewritten to point within the
+// archive, and their targets are added as empty directories.
+func writeDir(sw *snapshot.Writer, dir string, fsys fstest.MapFS) error {
+	if err := sw.Dir(dir); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(fsys))
+	for name := range fsys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := fsys[name]
+		path := filepath.Join(dir, name)
+
+		var err error
+		switch {
+		case f.Mode&fs.ModeDir != 0:
+			err = sw.Dir(path)
+		case f.Mode&fs.ModeSymlink != 0:
+			err = writeSymlink(sw, path, string(f.Data))
+		default:
+			err = sw.File(path, f.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSymlink(sw *snapshot.Writer, path, target string) error {
+	dir := filepath.Join(snapshot.PCIDir, filepath.Base(target))
+	if strings.HasPrefix(target, driversPath+"/") {
+		dir = filepath.Join(snapshot.DriverDir, filepath.Base(target))
+	}
+
+	if err := sw.Dir(dir); err != nil {
+		return err
+	}
+
+	return sw.Symlink(path, dir)
+}
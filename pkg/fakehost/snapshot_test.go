@@ -0,0 +1,37 @@
+package fakehost
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+)
+
+var _ = Describe("test writing the host as a snapshot", func() { // WriteSnapshot
+	It("writes an archive with links within the archive", func() {
+		archive := &bytes.Buffer{}
+		Expect(New(DefaultOptions).WriteSnapshot(archive)).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		Expect(snapshot.Extract(archive, dir)).To(Succeed())
+
+		vf, err := filepath.EvalSymlinks(filepath.Join(dir, snapshot.PCIDir, "0000:02:00.0", "virtfn3"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vf).To(Equal(filepath.Join(dir, snapshot.PCIDir, "0000:02:01.3")))
+
+		driver, err := filepath.EvalSymlinks(filepath.Join(dir, snapshot.PCIDir, "0000:02:00.0", "driver"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(driver).To(Equal(filepath.Join(dir, snapshot.DriverDir, "ice")))
+
+		Expect(os.ReadFile(filepath.Join(dir, snapshot.NetDir, "ens1f0", "device", "sriov", "0", "stats", "rx_packets"))).
+			To(Equal([]byte("1001000")))
+		Expect(filepath.Join(dir, snapshot.CheckpointDir, "cpu_manager_state")).To(BeARegularFile())
+		Expect(filepath.Join(dir, snapshot.NodeDir, "node1", "cpu7")).To(BeADirectory())
+		Expect(filepath.Join(dir, snapshot.NetlinkFile)).To(BeARegularFile())
+		Expect(os.ReadFile(filepath.Join(dir, snapshot.PodResourcesFile))).To(ContainSubstring("0000:01:01.0"))
+	})
+})
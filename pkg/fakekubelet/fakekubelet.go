@@ -0,0 +1,199 @@
+// Package fakekubelet serves the kubelet pod resources API from a scenario, for integration tests and local
+// development without a kubelet. Scenarios are YAML files using the field names of the kubelet API.
+package fakekubelet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+// Methods of the pod resources API, used as keys of Scenario.Errors and Server.Calls
+const (
+	MethodList                    = "List"
+	MethodGetAllocatableResources = "GetAllocatableResources"
+	MethodGet                     = "Get"
+)
+
+// Scenario is the state served by the fake kubelet
+type Scenario struct {
+	Pods        []*v1.PodResources
+	Allocatable *v1.AllocatableResourcesResponse
+	// Errors makes a method fail with codes.Unavailable and the given message
+	Errors map[string]string
+}
+
+// scenarioFile is the layout of a scenario file. Pods and allocatable resources are decoded with protojson.
+type scenarioFile struct {
+	Pods        []json.RawMessage `json:"pods"`
+	Allocatable json.RawMessage   `json:"allocatable"`
+	Errors      map[string]string `json:"errors"`
+}
+
+// LoadScenario reads a scenario from a YAML or JSON file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario, err := ParseScenario(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s - %v", path, err)
+	}
+
+	return scenario, nil
+}
+
+// ParseScenario parses a YAML or JSON scenario
+func ParseScenario(data []byte) (*Scenario, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid scenario\n%v", err)
+	}
+
+	// The kubelet messages can only be decoded from JSON, so the YAML document is converted first
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario\n%v", err)
+	}
+
+	file := scenarioFile{}
+	if err := json.Unmarshal(jsonData, &file); err != nil {
+		return nil, fmt.Errorf("invalid scenario\n%v", err)
+	}
+
+	scenario := &Scenario{Allocatable: &v1.AllocatableResourcesResponse{}, Errors: file.Errors}
+	for i, raw := range file.Pods {
+		pod := &v1.PodResources{}
+		if err := protojson.Unmarshal(raw, pod); err != nil {
+			return nil, fmt.Errorf("invalid pod %d\n%v", i, err)
+		}
+		scenario.Pods = append(scenario.Pods, pod)
+	}
+
+	if len(file.Allocatable) > 0 && string(file.Allocatable) != "null" {
+		if err := protojson.Unmarshal(file.Allocatable, scenario.Allocatable); err != nil {
+			return nil, fmt.Errorf("invalid allocatable resources\n%v", err)
+		}
+	}
+
+	for method := range scenario.Errors {
+		if method != MethodList && method != MethodGetAllocatableResources && method != MethodGet {
+			return nil, fmt.Errorf("unknown method '%s' in errors", method)
+		}
+	}
+
+	return scenario, nil
+}
+
+// Server implements the pod resources API, serving the current scenario and counting the calls of each method
+type Server struct {
+	v1.UnimplementedPodResourcesListerServer
+
+	mu       sync.Mutex
+	scenario *Scenario
+	calls    map[string]int
+	grpc     *grpc.Server
+}
+
+// NewServer returns a server for the scenario
+func NewServer(scenario *Scenario) *Server {
+	return &Server{scenario: scenario, calls: make(map[string]int)}
+}
+
+// Start serves the API on a unix socket at path until Stop is called
+func (s *Server) Start(path string) error {
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	s.grpc = grpc.NewServer()
+	v1.RegisterPodResourcesListerServer(s.grpc, s)
+	go func() { _ = s.grpc.Serve(lis) }()
+
+	return nil
+}
+
+// Stop stops serving and closes the socket
+func (s *Server) Stop() {
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+}
+
+// SetScenario replaces the scenario served by the following calls
+func (s *Server) SetScenario(scenario *Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scenario = scenario
+}
+
+// Calls returns the number of calls of a method
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+// call counts a call of the method and returns the scenario, or the error configured for the method
+func (s *Server) call(method string) (*Scenario, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[method]++
+	if msg, ok := s.scenario.Errors[method]; ok {
+		return nil, status.Error(codes.Unavailable, msg)
+	}
+
+	return s.scenario, nil
+}
+
+// List returns the resources of all pods
+func (s *Server) List(context.Context, *v1.ListPodResourcesRequest) (*v1.ListPodResourcesResponse, error) {
+	scenario, err := s.call(MethodList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListPodResourcesResponse{PodResources: scenario.Pods}, nil
+}
+
+// GetAllocatableResources returns the allocatable resources of the node
+func (s *Server) GetAllocatableResources(context.Context, *v1.AllocatableResourcesRequest) (*v1.AllocatableResourcesResponse, error) {
+	scenario, err := s.call(MethodGetAllocatableResources)
+	if err != nil {
+		return nil, err
+	}
+
+	return scenario.Allocatable, nil
+}
+
+// Get returns the resources of a single pod
+func (s *Server) Get(_ context.Context, req *v1.GetPodResourcesRequest) (*v1.GetPodResourcesResponse, error) {
+	scenario, err := s.call(MethodGet)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range scenario.Pods {
+		if pod.GetName() == req.GetPodName() && pod.GetNamespace() == req.GetPodNamespace() {
+			return &v1.GetPodResourcesResponse{PodResources: pod}, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "pod %s/%s not found", req.GetPodNamespace(), req.GetPodName())
+}
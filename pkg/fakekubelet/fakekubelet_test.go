@@ -0,0 +1,145 @@
+package fakekubelet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+func TestFakekubelet(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "fakekubelet test suite")
+}
+
+const testScenario = `
+pods:
+  - name: pod1
+    namespace: default
+    containers:
+      - name: app
+        devices:
+          - resourceName: intel.com/sriov
+            deviceIds: ["0000:3b:02.0", "0000:3b:02.1"]
+        cpuIds: [2, 3]
+  - name: pod2
+    namespace: kube-system
+allocatable:
+  devices:
+    - resourceName: intel.com/sriov
+      deviceIds: ["0000:3b:02.0", "0000:3b:02.1", "0000:3b:02.2"]
+  cpuIds: [0, 1, 2, 3]
+errors:
+  Get: kubelet restarting
+`
+
+var _ = DescribeTable("test parsing scenarios", // ParseScenario
+	func(data string, expectedErr string) {
+		_, err := ParseScenario([]byte(data))
+
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("yaml", testScenario, ""),
+	Entry("json", `{"pods": [{"name": "pod1", "namespace": "default"}]}`, ""),
+	Entry("empty", "", ""),
+	Entry("invalid yaml", "pods: [", "invalid scenario"),
+	Entry("unknown pod field", "pods: [{name: pod1, uid: 1234}]", "invalid pod 0"),
+	Entry("invalid allocatable resources", "allocatable: {cpuIds: [a]}", "invalid allocatable resources"),
+	Entry("unknown method", "errors: {Watch: failed}", "unknown method 'Watch' in errors"),
+)
+
+var _ = Describe("test loading scenarios", func() { // LoadScenario
+	It("reads the pods and allocatable resources", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "scenario.yaml")
+		Expect(os.WriteFile(path, []byte(testScenario), 0o600)).To(Succeed())
+
+		scenario, err := LoadScenario(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(scenario.Pods).To(HaveLen(2))
+		Expect(scenario.Pods[0].GetContainers()[0].GetDevices()[0].GetDeviceIds()).To(Equal([]string{"0000:3b:02.0", "0000:3b:02.1"}))
+		Expect(scenario.Pods[0].GetContainers()[0].GetCpuIds()).To(Equal([]int64{2, 3}))
+		Expect(scenario.Allocatable.GetCpuIds()).To(Equal([]int64{0, 1, 2, 3}))
+		Expect(scenario.Errors).To(HaveKeyWithValue(MethodGet, "kubelet restarting"))
+	})
+
+	It("fails on a missing file", func() {
+		_, err := LoadScenario(filepath.Join(GinkgoT().TempDir(), "missing.yaml"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("test serving the pod resources API", func() { // Server
+	var (
+		server *Server
+		client v1.PodResourcesListerClient
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		scenario, err := ParseScenario([]byte(testScenario))
+		Expect(err).ToNot(HaveOccurred())
+
+		socket := filepath.Join(GinkgoT().TempDir(), "kubelet.sock")
+		server = NewServer(scenario)
+		Expect(server.Start(socket)).To(Succeed())
+		DeferCleanup(server.Stop)
+
+		conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(conn.Close)
+		client = v1.NewPodResourcesListerClient(conn)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+	})
+
+	It("lists the pods", func() {
+		resp, err := client.List(ctx, &v1.ListPodResourcesRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetPodResources()).To(HaveLen(2))
+		Expect(server.Calls(MethodList)).To(Equal(1))
+	})
+
+	It("returns the allocatable resources", func() {
+		resp, err := client.GetAllocatableResources(ctx, &v1.AllocatableResourcesRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetDevices()[0].GetDeviceIds()).To(HaveLen(3))
+	})
+
+	It("returns the configured error", func() {
+		_, err := client.Get(ctx, &v1.GetPodResourcesRequest{PodName: "pod1", PodNamespace: "default"})
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		Expect(status.Convert(err).Message()).To(Equal("kubelet restarting"))
+		Expect(server.Calls(MethodGet)).To(Equal(1))
+	})
+
+	It("gets a single pod once the scenario changes", func() {
+		scenario, err := ParseScenario([]byte(testScenario))
+		Expect(err).ToNot(HaveOccurred())
+		scenario.Errors = nil
+		server.SetScenario(scenario)
+
+		resp, err := client.Get(ctx, &v1.GetPodResourcesRequest{PodName: "pod2", PodNamespace: "kube-system"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetPodResources().GetName()).To(Equal("pod2"))
+
+		_, err = client.Get(ctx, &v1.GetPodResourcesRequest{PodName: "pod2", PodNamespace: "default"})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+})
@@ -0,0 +1,104 @@
+package kube
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podTemplateHashLabel is set by the Deployment controller on its pods, and suffixes the name of their ReplicaSet
+const podTemplateHashLabel = "pod-template-hash"
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// PodMetadata selects the pod labels, annotations and owner workload added as metric labels. Only the listed keys
+// are added, which keeps the cardinality of the metrics under the control of the operator.
+type PodMetadata struct {
+	Labels      []string
+	Annotations []string
+	Owner       bool
+}
+
+// Enabled returns true if any pod metadata is selected
+func (m PodMetadata) Enabled() bool {
+	return len(m.Labels) > 0 || len(m.Annotations) > 0 || m.Owner
+}
+
+// LabelNames returns the metric label names of the selected metadata: label_<key> for pod labels,
+// annotation_<key> for annotations, with invalid characters replaced by '_', and owner_kind and owner_name.
+func (m PodMetadata) LabelNames() []string {
+	names := make([]string, 0, len(m.Labels)+len(m.Annotations)+2)
+	for _, key := range m.Labels {
+		names = append(names, metricLabelName("label_", key))
+	}
+	for _, key := range m.Annotations {
+		names = append(names, metricLabelName("annotation_", key))
+	}
+	if m.Owner {
+		names = append(names, "owner_kind", "owner_name")
+	}
+
+	return names
+}
+
+// Values returns the values of the selected metadata of the pod, in the order of LabelNames. The values are empty
+// for a nil pod, e.g. one not yet seen by the informer.
+func (m PodMetadata) Values(pod *corev1.Pod) []string {
+	var labels, annotations map[string]string
+	if pod != nil {
+		labels, annotations = pod.GetLabels(), pod.GetAnnotations()
+	}
+
+	values := make([]string, 0, len(m.Labels)+len(m.Annotations)+2)
+	for _, key := range m.Labels {
+		values = append(values, labels[key])
+	}
+	for _, key := range m.Annotations {
+		values = append(values, annotations[key])
+	}
+	if m.Owner {
+		kind, name := owner(pod)
+		values = append(values, kind, name)
+	}
+
+	return values
+}
+
+// Verify checks that no two selected keys map to the same metric label name
+func (m PodMetadata) Verify() error {
+	seen := make(map[string]bool)
+	for _, name := range m.LabelNames() {
+		if seen[name] {
+			return fmt.Errorf("more than one pod label or annotation maps to the metric label '%s'", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+func metricLabelName(prefix, key string) string {
+	return prefix + invalidLabelChars.ReplaceAllString(key, "_")
+}
+
+// owner returns the workload controlling the pod. A ReplicaSet created by a Deployment is followed to the
+// Deployment through the pod-template-hash label, without reading the ReplicaSet from the API.
+func owner(pod *corev1.Pod) (string, string) {
+	if pod == nil {
+		return "", ""
+	}
+
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", ""
+	}
+
+	if hash, ok := pod.GetLabels()[podTemplateHashLabel]; ok && ref.Kind == "ReplicaSet" && strings.HasSuffix(ref.Name, "-"+hash) {
+		return "Deployment", strings.TrimSuffix(ref.Name, "-"+hash)
+	}
+
+	return ref.Kind, ref.Name
+}
@@ -0,0 +1,76 @@
+package kube
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("test selecting pod metadata", func() { // PodMetadata
+	metadata := PodMetadata{
+		Labels:      []string{"app.kubernetes.io/name", "team"},
+		Annotations: []string{"example.com/tier"},
+		Owner:       true,
+	}
+
+	It("names the labels after the keys", func() {
+		Expect(metadata.LabelNames()).To(Equal([]string{
+			"label_app_kubernetes_io_name", "label_team", "annotation_example_com_tier", "owner_kind", "owner_name",
+		}))
+	})
+
+	It("reads the values from the pod", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app.kubernetes.io/name": "upf", "pod-template-hash": "5d9f8c7b6"},
+			Annotations: map[string]string{"example.com/tier": "data-plane"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "upf-5d9f8c7b6", Controller: ptr.To(true)},
+			},
+		}}
+
+		Expect(metadata.Values(pod)).To(Equal([]string{"upf", "", "data-plane", "Deployment", "upf"}))
+	})
+
+	It("returns empty values without a pod", func() {
+		Expect(metadata.Values(nil)).To(Equal([]string{"", "", "", "", ""}))
+	})
+
+	It("rejects keys mapping to the same label", func() {
+		Expect(metadata.Verify()).To(Succeed())
+		Expect(PodMetadata{Labels: []string{"app.name", "app_name"}}.Verify()).To(
+			MatchError("more than one pod label or annotation maps to the metric label 'label_app_name'"))
+	})
+
+	It("is disabled without keys or owner", func() {
+		Expect(PodMetadata{}.Enabled()).To(BeFalse())
+		Expect(metadata.Enabled()).To(BeTrue())
+	})
+})
+
+var _ = DescribeTable("test finding the owner of a pod", // owner
+	func(labels map[string]string, refs []metav1.OwnerReference, expectedKind, expectedName string) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: labels, OwnerReferences: refs}}
+
+		kind, name := owner(pod)
+		Expect(kind).To(Equal(expectedKind))
+		Expect(name).To(Equal(expectedName))
+	},
+	Entry("deployment",
+		map[string]string{"pod-template-hash": "5d9f8c7b6"},
+		[]metav1.OwnerReference{{Kind: "ReplicaSet", Name: "upf-5d9f8c7b6", Controller: ptr.To(true)}},
+		"Deployment", "upf"),
+	Entry("replicaset without deployment",
+		nil,
+		[]metav1.OwnerReference{{Kind: "ReplicaSet", Name: "upf", Controller: ptr.To(true)}},
+		"ReplicaSet", "upf"),
+	Entry("statefulset",
+		nil,
+		[]metav1.OwnerReference{{Kind: "StatefulSet", Name: "cu", Controller: ptr.To(true)}},
+		"StatefulSet", "cu"),
+	Entry("no controller",
+		nil,
+		[]metav1.OwnerReference{{Kind: "ConfigMap", Name: "settings"}},
+		"", ""),
+)
@@ -0,0 +1,52 @@
+package kube
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NetworkStatusAnnotation is the pod annotation in which Multus records the networks attached to the pod
+const NetworkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
+// DeviceNetwork is the pod interface of a device and the network it is attached to
+type DeviceNetwork struct {
+	Interface string
+	Network   string
+}
+
+// networkStatus is an entry of the network-status annotation, as defined by the Kubernetes Network Custom Resource
+// Definition De-facto Standard. Only the fields needed to link a PCI device to its network are decoded.
+type networkStatus struct {
+	Name       string `json:"name"`
+	Interface  string `json:"interface"`
+	DeviceInfo struct {
+		PCI struct {
+			PCIAddress string `json:"pci-address"`
+		} `json:"pci"`
+	} `json:"device-info"`
+}
+
+// DeviceNetworks returns the interface and network of each PCI device attached to the pod, keyed by PCI address.
+// Pods without the network-status annotation have no device networks.
+func DeviceNetworks(pod *corev1.Pod) (map[string]DeviceNetwork, error) {
+	annotation, ok := pod.GetAnnotations()[NetworkStatusAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var statuses []networkStatus
+	if err := json.Unmarshal([]byte(annotation), &statuses); err != nil {
+		return nil, err
+	}
+
+	networks := make(map[string]DeviceNetwork, len(statuses))
+	for _, status := range statuses {
+		if addr := status.DeviceInfo.PCI.PCIAddress; addr != "" {
+			networks[strings.ToLower(addr)] = DeviceNetwork{Interface: status.Interface, Network: status.Name}
+		}
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,35 @@
+package kube
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = DescribeTable("test reading the device networks of a pod", // DeviceNetworks
+	func(annotations map[string]string, expected map[string]DeviceNetwork, expectedErr string) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+
+		networks, err := DeviceNetworks(pod)
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(networks).To(Equal(expected))
+	},
+	Entry("sr-iov networks",
+		map[string]string{NetworkStatusAnnotation: `[
+			{"name": "default/cluster", "interface": "eth0", "ips": ["10.0.0.5"], "default": true},
+			{"name": "default/sriov-a", "interface": "net1", "device-info": {"type": "pci", "version": "1.1.0", "pci": {"pci-address": "0000:3B:02.0"}}},
+			{"name": "default/sriov-b", "interface": "net2", "device-info": {"type": "pci", "version": "1.1.0", "pci": {"pci-address": "0000:3b:02.1"}}}
+		]`},
+		map[string]DeviceNetwork{
+			"0000:3b:02.0": {Interface: "net1", Network: "default/sriov-a"},
+			"0000:3b:02.1": {Interface: "net2", Network: "default/sriov-b"},
+		},
+		""),
+	Entry("no annotation", nil, nil, ""),
+	Entry("malformed annotation", map[string]string{NetworkStatusAnnotation: `{"name":`}, nil, "unexpected end of JSON input"),
+)
@@ -0,0 +1,128 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeStateResource is the SriovNetworkNodeState custom resource of the SR-IOV Network Operator, which holds the
+// desired SR-IOV configuration of a node in its spec and the state seen by the config daemon in its status
+var NodeStateResource = schema.GroupVersionResource{
+	Group:    "sriovnetwork.openshift.io",
+	Version:  "v1",
+	Resource: "sriovnetworknodestates",
+}
+
+// NodeState is the desired SR-IOV configuration of the node and the sync status of the operator
+type NodeState struct {
+	Spec struct {
+		Interfaces []SriovInterface `json:"interfaces"`
+	} `json:"spec"`
+	Status struct {
+		SyncStatus    string `json:"syncStatus"`
+		LastSyncError string `json:"lastSyncError"`
+	} `json:"status"`
+}
+
+// SriovInterface is the desired configuration of a PF
+type SriovInterface struct {
+	PCIAddress        string    `json:"pciAddress"`
+	Name              string    `json:"name"`
+	NumVfs            int       `json:"numVfs"`
+	MTU               int       `json:"mtu"`
+	VFGroups          []VFGroup `json:"vfGroups"`
+	ExternallyManaged bool      `json:"externallyManaged"`
+}
+
+// VFGroup is a range of VFs of a PF configured by a SriovNetworkNodePolicy
+type VFGroup struct {
+	ResourceName string `json:"resourceName"`
+	DeviceType   string `json:"deviceType"`
+	VFRange      string `json:"vfRange"`
+	PolicyName   string `json:"policyName"`
+	MTU          int    `json:"mtu"`
+}
+
+// VFIDs returns the ids of the VFs in the range of the group, given as "first-last" or a single id
+func (g VFGroup) VFIDs() ([]string, error) {
+	first, last, found := strings.Cut(g.VFRange, "-")
+	if !found {
+		last = first
+	}
+
+	start, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return nil, fmt.Errorf("invalid vf range '%s'", g.VFRange)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(last))
+	if err != nil || end < start {
+		return nil, fmt.Errorf("invalid vf range '%s'", g.VFRange)
+	}
+
+	ids := make([]string, 0, end-start+1)
+	for id := start; id <= end; id++ {
+		ids = append(ids, strconv.Itoa(id))
+	}
+
+	return ids, nil
+}
+
+// NodeStates is a cache of the SriovNetworkNodeState of a node, kept up to date by an informer
+type NodeStates struct {
+	lister    cache.GenericNamespaceLister
+	namespace string
+	nodeName  string
+}
+
+// WatchNodeState starts an informer for the SriovNetworkNodeState of the node in the operator namespace and waits
+// up to the timeout for its cache to sync. The informer stops when the context is done.
+func WatchNodeState(ctx context.Context, client dynamic.Interface, namespace, nodeName string, timeout time.Duration) (*NodeStates, error) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", nodeName).String()
+	})
+	lister := factory.ForResource(NodeStateResource).Lister()
+
+	factory.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("sriov network node state of node '%s' could not be listed within %v", nodeName, timeout)
+		}
+	}
+
+	return &NodeStates{lister: lister.ByNamespace(namespace), namespace: namespace, nodeName: nodeName}, nil
+}
+
+// Get returns the node state of the node, or an error if the operator has not created it
+func (s *NodeStates) Get() (*NodeState, error) {
+	obj, err := s.lister.Get(s.nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("sriov network node state '%s/%s' not available\n%v", s.namespace, s.nodeName, err)
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T", obj)
+	}
+
+	state := &NodeState{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, state); err != nil {
+		return nil, fmt.Errorf("invalid sriov network node state '%s/%s'\n%v", s.namespace, s.nodeName, err)
+	}
+
+	return state, nil
+}
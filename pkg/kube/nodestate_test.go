@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// nodeState returns a SriovNetworkNodeState object with the given spec interfaces
+func nodeState(namespace, name string, interfaces ...any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "sriovnetwork.openshift.io/v1",
+		"kind":       "SriovNetworkNodeState",
+		"metadata":   map[string]any{"name": name, "namespace": namespace},
+		"spec":       map[string]any{"interfaces": interfaces},
+		"status":     map[string]any{"syncStatus": "Succeeded"},
+	}}
+}
+
+var _ = Describe("test watching the sriov network node state", func() { // WatchNodeState
+	It("returns the desired configuration of the node", func() {
+		client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{NodeStateResource: "SriovNetworkNodeStateList"},
+			nodeState("sriov-network-operator", "node1", map[string]any{
+				"pciAddress": "0000:3b:00.0",
+				"name":       "ens1f0",
+				"numVfs":     int64(8),
+				"mtu":        int64(9000),
+				"vfGroups": []any{map[string]any{
+					"resourceName": "intel_sriov_netdevice",
+					"deviceType":   "netdevice",
+					"vfRange":      "0-7",
+					"policyName":   "policy-1",
+				}},
+			}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		states, err := WatchNodeState(ctx, client, "sriov-network-operator", "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		state, err := states.Get()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Status.SyncStatus).To(Equal("Succeeded"))
+		Expect(state.Spec.Interfaces).To(Equal([]SriovInterface{{
+			PCIAddress: "0000:3b:00.0",
+			Name:       "ens1f0",
+			NumVfs:     8,
+			MTU:        9000,
+			VFGroups: []VFGroup{
+				{ResourceName: "intel_sriov_netdevice", DeviceType: "netdevice", VFRange: "0-7", PolicyName: "policy-1"},
+			},
+		}}))
+	})
+
+	It("fails while the operator has not created the node state", func() {
+		client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{NodeStateResource: "SriovNetworkNodeStateList"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		states, err := WatchNodeState(ctx, client, "sriov-network-operator", "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = states.Get()
+		Expect(err).To(MatchError(ContainSubstring("sriov network node state 'sriov-network-operator/node1' not available")))
+	})
+})
+
+var _ = DescribeTable("test listing the vfs of a vf group", // VFGroup.VFIDs
+	func(vfRange string, expected []string, expectedErr string) {
+		ids, err := VFGroup{VFRange: vfRange}.VFIDs()
+		if expectedErr != "" {
+			Expect(err).To(MatchError(expectedErr))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids).To(Equal(expected))
+	},
+	Entry("range", "2-4", []string{"2", "3", "4"}, ""),
+	Entry("single vf", "5", []string{"5"}, ""),
+	Entry("reversed range", "4-2", nil, "invalid vf range '4-2'"),
+	Entry("not a number", "a-b", nil, "invalid vf range 'a-b'"),
+)
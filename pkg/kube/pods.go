@@ -0,0 +1,131 @@
+// Package kube watches the pods of the node through the Kubernetes API, for the pod metadata that the kubelet
+// pod resources API does not report.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Pods is a cache of the pods scheduled to a node, or selected by labels, kept up to date by an informer
+type Pods struct {
+	lister  corelisters.PodLister
+	indexer cache.Indexer
+}
+
+// uidIndex indexes the cached pods by UID, for the collectors that only know the UID of a pod
+const uidIndex = "uid"
+
+// WatchPods starts an informer for the pods of the node and waits up to the timeout for its cache to sync.
+// The informer stops when the context is done.
+func WatchPods(ctx context.Context, client kubernetes.Interface, nodeName string, timeout time.Duration) (*Pods, error) {
+	pods, err := watchPods(ctx, client, timeout,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("pods of node '%s' %v", nodeName, err)
+	}
+
+	return pods, nil
+}
+
+// WatchSelectedPods starts an informer for the pods of the namespace matching the label selector, on every node, and
+// waits up to the timeout for its cache to sync. The informer stops when the context is done.
+func WatchSelectedPods(ctx context.Context, client kubernetes.Interface, namespace, selector string, timeout time.Duration) (*Pods, error) {
+	pods, err := watchPods(ctx, client, timeout, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("pods '%s' of namespace '%s' %v", selector, namespace, err)
+	}
+
+	return pods, nil
+}
+
+// watchPods starts an informer for the pods listed with the options and waits up to the timeout for its cache to sync
+func watchPods(ctx context.Context, client kubernetes.Interface, timeout time.Duration, options ...informers.SharedInformerOption) (*Pods, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, options...)
+
+	informer := factory.Core().V1().Pods()
+	// Managed fields are never read and make up a large part of each cached pod
+	if err := informer.Informer().SetTransform(stripManagedFields); err != nil {
+		return nil, err
+	}
+	if err := informer.Informer().AddIndexers(cache.Indexers{uidIndex: podUID}); err != nil {
+		return nil, err
+	}
+	lister := informer.Lister()
+
+	factory.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("could not be listed within %v", timeout)
+		}
+	}
+
+	return &Pods{lister: lister, indexer: informer.Informer().GetIndexer()}, nil
+}
+
+// stripManagedFields removes the managed fields of a pod before it is cached
+func stripManagedFields(obj any) (any, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+
+	return obj, nil
+}
+
+func podUID(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T", obj)
+	}
+
+	return []string{string(pod.UID)}, nil
+}
+
+// Get returns the pod with the given namespace and name, or nil if the node has no such pod
+func (p *Pods) Get(namespace, name string) *corev1.Pod {
+	pod, err := p.lister.Pods(namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+
+	return pod
+}
+
+// GetByUID returns the pod with the given UID, or nil if the node has no such pod
+func (p *Pods) GetByUID(uid string) *corev1.Pod {
+	objs, err := p.indexer.ByIndex(uidIndex, uid)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+
+	return objs[0].(*corev1.Pod)
+}
+
+// List returns the pods of the node in the namespace matching the selector
+func (p *Pods) List(namespace string, selector labels.Selector) []*corev1.Pod {
+	pods, err := p.lister.Pods(namespace).List(selector)
+	if err != nil {
+		return nil
+	}
+
+	return pods
+}
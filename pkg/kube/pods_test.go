@@ -0,0 +1,107 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKube(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "kube test suite")
+}
+
+var _ = Describe("test watching the pods of the node", func() { // WatchPods
+	It("serves the pods from the cache and follows updates", func() {
+		client := fake.NewClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:          "pod1",
+				Namespace:     "default",
+				UID:           "6b5b533a-6307-48d1-911f-07bf5d4e1c82",
+				ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}},
+			},
+			Spec: corev1.PodSpec{NodeName: "node1"},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		pods, err := WatchPods(ctx, client, "node1", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		pod := pods.Get("default", "pod1")
+		Expect(pod).ToNot(BeNil())
+		Expect(pod.ManagedFields).To(BeEmpty())
+		Expect(pods.Get("default", "pod2")).To(BeNil())
+		Expect(pods.GetByUID("6b5b533a-6307-48d1-911f-07bf5d4e1c82")).To(Equal(pod))
+		Expect(pods.GetByUID("00000000-0000-0000-0000-000000000000")).To(BeNil())
+		Expect(pods.List("default", labels.Everything())).To(ConsistOf(pod))
+		Expect(pods.List("other", labels.Everything())).To(BeEmpty())
+
+		_, err = client.CoreV1().Pods("default").Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() *corev1.Pod { return pods.Get("default", "pod2") }).ShouldNot(BeNil())
+	})
+
+	It("fails when the pods can not be listed in time", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := WatchPods(ctx, fake.NewClientset(), "node1", time.Second)
+		Expect(err).To(MatchError("pods of node 'node1' could not be listed within 1s"))
+	})
+})
+
+var _ = Describe("test watching the pods selected by labels", func() { // WatchSelectedPods
+	It("serves the pods of the namespace matching the selector on every node", func() {
+		exporter := func(name, namespace, node string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "exporter"}},
+				Spec:       corev1.PodSpec{NodeName: node},
+			}
+		}
+		client := fake.NewClientset(
+			exporter("exporter-1", "monitoring", "node1"),
+			exporter("exporter-2", "monitoring", "node2"),
+			exporter("exporter-3", "other", "node3"),
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "monitoring"}, Spec: corev1.PodSpec{NodeName: "node1"}},
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		pods, err := WatchSelectedPods(ctx, client, "monitoring", "app=exporter", 10*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		names := func() []string {
+			names := make([]string, 0)
+			for _, pod := range pods.List("monitoring", labels.Everything()) {
+				names = append(names, pod.Name)
+			}
+
+			return names
+		}
+		Expect(names()).To(ConsistOf("exporter-1", "exporter-2"))
+
+		Expect(client.CoreV1().Pods("monitoring").Delete(ctx, "exporter-2", metav1.DeleteOptions{})).To(Succeed())
+		Eventually(names).Should(ConsistOf("exporter-1"))
+	})
+
+	It("fails when the pods can not be listed in time", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := WatchSelectedPods(ctx, fake.NewClientset(), "monitoring", "app=exporter", time.Second)
+		Expect(err).To(MatchError("pods 'app=exporter' of namespace 'monitoring' could not be listed within 1s"))
+	})
+})
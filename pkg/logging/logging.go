@@ -0,0 +1,195 @@
+// Package logging builds the structured logger used by the exporter.
+// Repeated warnings and errors, e.g. a pf failing to return stats on every scrape, are deduplicated so that
+// each distinct message is logged at most once per interval, together with the number of suppressed repeats.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing to w at the given level and format, "text" or "json".
+// Warnings and errors repeated within the dedup interval are suppressed, an interval of 0 disables deduplication.
+func New(w io.Writer, level, format string, dedupInterval time.Duration) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format '%s', must be '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+
+	if dedupInterval > 0 {
+		handler = NewDedupHandler(handler, dedupInterval)
+	}
+
+	return slog.New(handler), nil
+}
+
+// dedupEntry tracks when a message was last logged and how many repeats were suppressed since.
+// The last suppressed repeat is kept with its handler to log the count if the message does not occur again.
+type dedupEntry struct {
+	last       time.Time
+	suppressed int
+	record     slog.Record
+	handler    slog.Handler
+}
+
+// dedupState is shared by a dedup handler and the handlers derived from it with WithAttrs and WithGroup
+type dedupState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*dedupEntry
+	pruned   time.Time
+	now      func() time.Time
+}
+
+// DedupHandler drops warnings and errors identical to one logged within the interval.
+// The next occurrence logged after the interval carries a "suppressed" attribute with the number of dropped repeats.
+// If there is no next occurrence, the last dropped repeat is logged with the attribute once the interval has passed.
+type DedupHandler struct {
+	next   slog.Handler
+	prefix string
+	state  *dedupState
+}
+
+// NewDedupHandler wraps a handler with deduplication of repeated warnings and errors
+func NewDedupHandler(next slog.Handler, interval time.Duration) *DedupHandler {
+	return &DedupHandler{
+		next: next,
+		state: &dedupState{
+			interval: interval,
+			entries:  make(map[string]*dedupEntry),
+			now:      time.Now,
+		},
+	}
+}
+
+// Enabled reports whether the wrapped handler handles records at the given level
+func (h *DedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle passes the record to the wrapped handler unless it repeats a recent warning or error
+func (h *DedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	suppressed, drop, expired := h.state.observe(h.key(r), r, h.next)
+	for _, entry := range expired {
+		entry.record.AddAttrs(slog.Int("suppressed", entry.suppressed))
+		if err := entry.handler.Handle(ctx, entry.record); err != nil {
+			return err
+		}
+	}
+
+	if drop {
+		return nil
+	}
+
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler sharing the deduplication state, with the attributes added to each record
+func (h *DedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := h.prefix
+	for _, attr := range attrs {
+		prefix += " " + attr.String()
+	}
+
+	return &DedupHandler{next: h.next.WithAttrs(attrs), prefix: prefix, state: h.state}
+}
+
+// WithGroup returns a handler sharing the deduplication state, with the group applied to each record
+func (h *DedupHandler) WithGroup(name string) slog.Handler {
+	return &DedupHandler{next: h.next.WithGroup(name), prefix: h.prefix + " " + name + ":", state: h.state}
+}
+
+// key identifies a record by its level, message and attributes
+func (h *DedupHandler) key(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(h.prefix)
+	b.WriteString(" " + r.Level.String() + " " + r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		b.WriteString(" " + attr.String())
+		return true
+	})
+
+	return b.String()
+}
+
+// observe records an occurrence of the key, logged by the handler as the record. It returns whether the occurrence
+// should be dropped and, if not, the number of repeats dropped since the key was last logged. The expired entries of
+// other keys with suppressed repeats are returned to be logged.
+func (s *dedupState) observe(key string, r slog.Record, handler slog.Handler) (int, bool, []*dedupEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var expired []*dedupEntry
+	if now.Sub(s.pruned) >= s.interval {
+		expired = s.prune(now, key)
+		s.pruned = now
+	}
+
+	entry, ok := s.entries[key]
+	if ok && now.Sub(entry.last) < s.interval {
+		entry.suppressed++
+		entry.record, entry.handler = r.Clone(), handler
+		return 0, true, expired
+	}
+
+	if !ok {
+		s.entries[key] = &dedupEntry{last: now}
+		return 0, false, expired
+	}
+
+	suppressed := entry.suppressed
+	entry.last, entry.suppressed = now, 0
+	entry.record, entry.handler = slog.Record{}, nil
+
+	return suppressed, false, expired
+}
+
+// prune drops the entries other than the key that have not been logged within the interval, and returns those with
+// suppressed repeats to report
+func (s *dedupState) prune(now time.Time, key string) []*dedupEntry {
+	var expired []*dedupEntry
+	for k, entry := range s.entries {
+		if k == key || now.Sub(entry.last) < s.interval {
+			continue
+		}
+
+		if entry.suppressed > 0 {
+			expired = append(expired, entry)
+		}
+		delete(s.entries, k)
+	}
+
+	return expired
+}
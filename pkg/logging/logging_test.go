@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "logging test suite")
+}
+
+var _ = DescribeTable("test creating logger", // New
+	func(level, format string, expectedErr string) {
+		_, err := New(&bytes.Buffer{}, level, format, 0)
+
+		if expectedErr != "" {
+			Expect(err).To(MatchError(expectedErr))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("text", "info", FormatText, ""),
+	Entry("json", "debug", FormatJSON, ""),
+	Entry("upper case level", "WARN", FormatText, ""),
+	Entry("invalid level", "verbose", FormatText, "invalid log level 'verbose'"),
+	Entry("invalid format", "info", "xml", "invalid log format 'xml', must be 'text' or 'json'"),
+)
+
+var _ = Describe("test logger output", func() { // New
+	It("filters records below the level", func() {
+		buf := &bytes.Buffer{}
+		logger, err := New(buf, "warn", FormatText, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Info("collecting")
+		logger.Warn("no stats", "pf", "ens785f0")
+
+		Expect(buf.String()).ToNot(ContainSubstring("collecting"))
+		Expect(buf.String()).To(ContainSubstring(`msg="no stats" pf=ens785f0`))
+	})
+
+	It("writes json records", func() {
+		buf := &bytes.Buffer{}
+		logger, err := New(buf, "info", FormatJSON, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Info("using reader", "pf", "ens785f0", "reader", "sysfs")
+
+		record := map[string]any{}
+		Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("msg", "using reader"))
+		Expect(record).To(HaveKeyWithValue("pf", "ens785f0"))
+		Expect(record).To(HaveKeyWithValue("reader", "sysfs"))
+	})
+})
+
+var _ = Describe("test deduplicating records", func() { // DedupHandler
+	var (
+		buf     *bytes.Buffer
+		now     time.Time
+		handler *DedupHandler
+		logger  *slog.Logger
+	)
+
+	lines := func() []string {
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		now = time.Unix(0, 0)
+		handler = NewDedupHandler(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}), time.Minute)
+		handler.state.now = func() time.Time { return now }
+		logger = slog.New(handler)
+	})
+
+	It("suppresses repeated warnings within the interval", func() {
+		for range 3 {
+			logger.Warn("no stats", "pf", "ens785f0")
+		}
+		Expect(lines()).To(HaveLen(1))
+
+		now = now.Add(time.Minute)
+		logger.Warn("no stats", "pf", "ens785f0")
+		Expect(lines()).To(HaveLen(2))
+		Expect(lines()[1]).To(HaveSuffix("pf=ens785f0 suppressed=2"))
+	})
+
+	It("does not suppress warnings with different attributes", func() {
+		logger.Warn("no stats", "pf", "ens785f0")
+		logger.Warn("no stats", "pf", "ens785f1")
+		logger.With("collector", "vfstats").Warn("no stats", "pf", "ens785f0")
+
+		Expect(lines()).To(HaveLen(3))
+	})
+
+	It("does not suppress records below warning", func() {
+		logger.Info("collecting")
+		logger.Info("collecting")
+
+		Expect(lines()).To(HaveLen(2))
+	})
+
+	It("prunes expired entries", func() {
+		logger.Warn("no stats", "pf", "ens785f0")
+		now = now.Add(time.Minute)
+		logger.Warn("no stats", "pf", "ens785f1")
+
+		Expect(handler.state.entries).To(HaveLen(1))
+	})
+
+	It("logs the suppressed repeats of expired entries", func() {
+		for range 3 {
+			logger.Warn("no stats", "pf", "ens785f0")
+		}
+		now = now.Add(time.Minute)
+		logger.Warn("no stats", "pf", "ens785f1")
+
+		Expect(handler.state.entries).To(HaveLen(1))
+		Expect(lines()).To(HaveLen(3))
+		Expect(lines()[1]).To(HaveSuffix("pf=ens785f0 suppressed=2"))
+		Expect(lines()[2]).To(HaveSuffix("pf=ens785f1"))
+	})
+})
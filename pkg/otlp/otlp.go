@@ -0,0 +1,115 @@
+// Package otlp periodically pushes the metrics of a prometheus gatherer to an OTLP receiver over gRPC or HTTP.
+// Metric labels, such as the pf, vf and pod labels, are sent as data point attributes.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// Protocols supported by the exporter
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
+// ServiceName is the service.name resource attribute of the exported metrics
+const ServiceName = "sriov-network-metrics-exporter"
+
+// Config configures the exporter
+type Config struct {
+	// Endpoint is the host:port of the receiver
+	Endpoint string
+	Protocol string
+	Insecure bool
+	Headers  map[string]string
+	Interval time.Duration
+	Timeout  time.Duration
+	// NodeName and HostName are sent as the k8s.node.name and host.name resource attributes, if set
+	NodeName string
+	HostName string
+}
+
+// Verify returns an error if the configuration is invalid
+func (c Config) Verify() error {
+	if c.Protocol != ProtocolGRPC && c.Protocol != ProtocolHTTP {
+		return fmt.Errorf("unknown protocol '%s', expected %s or %s", c.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %v", c.Interval)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %v", c.Timeout)
+	}
+
+	return nil
+}
+
+// Start starts pushing the metrics of the gatherer every interval until the returned provider is shut down.
+// ForceFlush on the provider pushes the metrics immediately.
+func Start(ctx context.Context, cfg Config, gatherer prometheus.Gatherer) (*sdkmetric.MeterProvider, error) {
+	if err := cfg.Verify(); err != nil {
+		return nil, err
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s exporter\n%v", cfg.Protocol, err)
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.Interval),
+		sdkmetric.WithTimeout(cfg.Timeout),
+		sdkmetric.WithProducer(prometheusbridge.NewMetricProducer(prometheusbridge.WithGatherer(gatherer))))
+
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(newResource(cfg))), nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdkmetric.Exporter, error) {
+	if cfg.Protocol == ProtocolHTTP {
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+			otlpmetrichttp.WithHeaders(cfg.Headers),
+			otlpmetrichttp.WithTimeout(cfg.Timeout),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+
+	opts := []otlpmetricgrpc.Option{
+		otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+		otlpmetricgrpc.WithHeaders(cfg.Headers),
+		otlpmetricgrpc.WithTimeout(cfg.Timeout),
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+
+	return otlpmetricgrpc.New(ctx, opts...)
+}
+
+// newResource describes the exporter and the node it runs on
+func newResource(cfg Config) *resource.Resource {
+	attrs := []attribute.KeyValue{semconv.ServiceName(ServiceName)}
+	if cfg.NodeName != "" {
+		attrs = append(attrs, semconv.K8SNodeName(cfg.NodeName))
+	}
+	if cfg.HostName != "" {
+		attrs = append(attrs, semconv.HostName(cfg.HostName))
+	}
+
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...)
+}
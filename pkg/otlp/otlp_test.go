@@ -0,0 +1,162 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOtlp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "otlp test suite")
+}
+
+// receiver is a stand-in for an OTLP receiver, keeping the received requests
+type receiver struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*colmetricpb.ExportMetricsServiceRequest
+}
+
+func (r *receiver) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) received() []*colmetricpb.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.requests
+}
+
+// serveGRPC serves the receiver over gRPC and returns its address
+func (r *receiver) serveGRPC() string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, r)
+	go func() { _ = server.Serve(lis) }()
+	DeferCleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+// serveHTTP serves the receiver over HTTP and returns its address
+func (r *receiver) serveHTTP() string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer GinkgoRecover()
+		Expect(req.URL.Path).To(Equal("/v1/metrics"))
+
+		body, err := io.ReadAll(req.Body)
+		Expect(err).ToNot(HaveOccurred())
+		exportReq := &colmetricpb.ExportMetricsServiceRequest{}
+		Expect(proto.Unmarshal(body, exportReq)).To(Succeed())
+
+		resp, err := r.Export(req.Context(), exportReq)
+		Expect(err).ToNot(HaveOccurred())
+		data, err := proto.Marshal(resp)
+		Expect(err).ToNot(HaveOccurred())
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(data)
+	}))
+	DeferCleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// testGatherer returns a registry with a vf counter
+func testGatherer() prometheus.Gatherer {
+	registry := prometheus.NewPedanticRegistry()
+	packets := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "sriov_vf_rx_packets", Help: "Received packets"},
+		[]string{"pf", "vf", "pciAddr"})
+	packets.WithLabelValues("ens1f0", "0", "0000:01:01.0").Add(1000)
+	registry.MustRegister(packets)
+
+	return registry
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+
+	return attrs
+}
+
+var _ = DescribeTable("test pushing metrics", // Start
+	func(protocol string) {
+		r := &receiver{}
+		endpoint := r.serveGRPC()
+		if protocol == ProtocolHTTP {
+			endpoint = r.serveHTTP()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cfg := Config{Endpoint: endpoint, Protocol: protocol, Insecure: true, Interval: time.Hour, Timeout: 5 * time.Second,
+			NodeName: "node1", HostName: "host1"}
+		provider, err := Start(ctx, cfg, testGatherer())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(provider.ForceFlush(ctx)).To(Succeed())
+		Expect(provider.Shutdown(ctx)).To(Succeed())
+
+		requests := r.received()
+		Expect(requests).ToNot(BeEmpty())
+		resourceMetrics := requests[0].GetResourceMetrics()
+		Expect(resourceMetrics).To(HaveLen(1))
+		Expect(attributes(resourceMetrics[0].GetResource().GetAttributes())).To(Equal(map[string]string{
+			"service.name":  ServiceName,
+			"k8s.node.name": "node1",
+			"host.name":     "host1",
+		}))
+
+		metrics := resourceMetrics[0].GetScopeMetrics()[0].GetMetrics()
+		Expect(metrics).To(HaveLen(1))
+		Expect(metrics[0].GetName()).To(Equal("sriov_vf_rx_packets"))
+		points := metrics[0].GetSum().GetDataPoints()
+		Expect(points).To(HaveLen(1))
+		Expect(points[0].GetAsDouble()).To(Equal(1000.0))
+		Expect(attributes(points[0].GetAttributes())).To(Equal(map[string]string{"pf": "ens1f0", "vf": "0", "pciAddr": "0000:01:01.0"}))
+	},
+	Entry("grpc", ProtocolGRPC),
+	Entry("http", ProtocolHTTP),
+)
+
+var _ = DescribeTable("test verifying the configuration", // Verify
+	func(cfg Config, expectedErr string) {
+		err := cfg.Verify()
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+	},
+	Entry("valid", Config{Protocol: ProtocolGRPC, Interval: time.Second, Timeout: time.Second}, ""),
+	Entry("unknown protocol", Config{Protocol: "udp", Interval: time.Second, Timeout: time.Second}, "unknown protocol 'udp'"),
+	Entry("zero interval", Config{Protocol: ProtocolHTTP, Timeout: time.Second}, "interval must be positive"),
+	Entry("zero timeout", Config{Protocol: ProtocolHTTP, Interval: time.Second}, "timeout must be positive"),
+)
@@ -0,0 +1,59 @@
+// Package push periodically gathers metrics and pushes them to a Prometheus remote-write endpoint or a Pushgateway,
+// for nodes that can not be scraped.
+package push
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Modes of pushing metrics
+const (
+	ModeRemoteWrite = "remote-write"
+	ModePushgateway = "pushgateway"
+)
+
+// Sink receives the gathered metric families, gathered at the given time
+type Sink interface {
+	Push(ctx context.Context, families []*dto.MetricFamily, ts time.Time) error
+}
+
+// Run gathers and pushes the metrics every interval until the context is done, and pushes once more on shutdown.
+// Each push, including its retries, is cancelled after the timeout.
+func Run(ctx context.Context, gatherer prometheus.Gatherer, sink Sink, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pushOnce(context.WithoutCancel(ctx), gatherer, sink, timeout)
+
+		select {
+		case <-ctx.Done():
+			pushOnce(context.WithoutCancel(ctx), gatherer, sink, timeout)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pushOnce(ctx context.Context, gatherer prometheus.Gatherer, sink Sink, timeout time.Duration) {
+	ts := time.Now()
+	families, err := gatherer.Gather()
+	if err != nil {
+		// Gather returns the metrics it could gather along with the error, so these are still pushed
+		slog.Warn("gathering metrics failed", "err", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := sink.Push(ctx, families, ts); err != nil {
+		slog.Warn("pushing metrics failed", "err", err)
+		return
+	}
+	slog.Debug("pushed metrics", "families", len(families))
+}
@@ -0,0 +1,77 @@
+package push
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPush(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "push test suite")
+}
+
+// testGatherer returns a registry with a vf counter
+func testGatherer() *prometheus.Registry {
+	registry := prometheus.NewPedanticRegistry()
+	packets := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "sriov_vf_rx_packets", Help: "Received packets"},
+		[]string{"pf", "vf"})
+	packets.WithLabelValues("ens1f0", "0").Add(1000)
+	registry.MustRegister(packets)
+
+	return registry
+}
+
+// recordingSink keeps the names of the pushed metric families
+type recordingSink struct {
+	mu     sync.Mutex
+	pushes [][]string
+}
+
+func (s *recordingSink) Push(ctx context.Context, families []*dto.MetricFamily, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	Expect(ctx.Err()).ToNot(HaveOccurred())
+	names := []string{}
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	s.pushes = append(s.pushes, names)
+
+	return nil
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pushes)
+}
+
+var _ = Describe("test running the push loop", func() { // Run
+	It("pushes on start, every interval and on shutdown", func() {
+		sink := &recordingSink{}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			Run(ctx, testGatherer(), sink, 10*time.Millisecond, time.Second)
+		}()
+
+		Eventually(sink.count).Should(BeNumerically(">=", 3))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		count := sink.count()
+		Consistently(sink.count).Should(Equal(count))
+		Expect(sink.pushes[0]).To(Equal([]string{"sriov_vf_rx_packets"}))
+	})
+})
@@ -0,0 +1,43 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Pushgateway pushes metrics to a Pushgateway, replacing the metrics of the previous push in the same group.
+// The Pushgateway keeps only the latest values, so failed pushes are not buffered.
+type Pushgateway struct {
+	url      string
+	job      string
+	grouping map[string]string
+	header   http.Header
+}
+
+// NewPushgateway returns a sink pushing to the Pushgateway url under the job, grouped by the grouping labels.
+// The headers are sent with every request.
+func NewPushgateway(url, job string, grouping, headers map[string]string) *Pushgateway {
+	header := http.Header{}
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+
+	return &Pushgateway{url: url, job: job, grouping: grouping, header: header}
+}
+
+// Push replaces the metrics of the group with the metric families. The Pushgateway sets the push time itself.
+func (p *Pushgateway) Push(ctx context.Context, families []*dto.MetricFamily, _ time.Time) error {
+	pusher := push.New(p.url, p.job).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })).
+		Header(p.header)
+	for name, value := range p.grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	return pusher.PushContext(ctx)
+}
@@ -0,0 +1,45 @@
+package push
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("test pushing to a pushgateway", func() { // Pushgateway
+	It("replaces the metrics of the node group", func() {
+		var method, path, tenant, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			method, path, tenant, body = r.Method, r.URL.Path, r.Header.Get("X-Scope-OrgID"), string(data)
+			w.WriteHeader(http.StatusOK)
+		}))
+		DeferCleanup(server.Close)
+
+		families, err := testGatherer().Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		sink := NewPushgateway(server.URL, "sriov", map[string]string{"node": "node1"}, map[string]string{"X-Scope-OrgID": "edge"})
+		Expect(sink.Push(context.Background(), families, time.Now())).To(Succeed())
+
+		Expect(method).To(Equal(http.MethodPut))
+		Expect(path).To(Equal("/metrics/job/sriov/node/node1"))
+		Expect(tenant).To(Equal("edge"))
+		Expect(body).To(ContainSubstring("sriov_vf_rx_packets"))
+	})
+
+	It("returns the error of the pushgateway", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		DeferCleanup(server.Close)
+
+		sink := NewPushgateway(server.URL, "sriov", nil, nil)
+		Expect(sink.Push(context.Background(), nil, time.Now())).To(MatchError(ContainSubstring("400")))
+	})
+})
@@ -0,0 +1,278 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	maxErrorBody      = 512
+)
+
+// RemoteWrite pushes metrics with the Prometheus remote-write 1.0 protocol. A push that fails is retried with backoff
+// until its context is done, and is then buffered in memory and sent ahead of the following pushes, so that short
+// outages do not leave gaps. Only the latest bufferSize pushes are kept.
+type RemoteWrite struct {
+	url        string
+	client     *http.Client
+	labels     map[string]string
+	headers    map[string]string
+	bufferSize int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// pending holds the encoded requests that are not sent yet, oldest first
+	pending [][]byte
+}
+
+// NewRemoteWrite returns a sink pushing to the remote-write url. The labels are added to every series, without
+// replacing the labels of the metrics, and the headers are sent with every request.
+func NewRemoteWrite(url string, labels, headers map[string]string, bufferSize int) *RemoteWrite {
+	return &RemoteWrite{
+		url:        url,
+		client:     &http.Client{},
+		labels:     labels,
+		headers:    headers,
+		bufferSize: max(bufferSize, 1),
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+}
+
+// Pending returns the number of pushes buffered for resending
+func (w *RemoteWrite) Pending() int {
+	return len(w.pending)
+}
+
+// Push sends the buffered pushes followed by the metric families
+func (w *RemoteWrite) Push(ctx context.Context, families []*dto.MetricFamily, ts time.Time) error {
+	w.pending = append(w.pending, encodeWriteRequest(timeSeries(families, ts, w.labels)))
+	if dropped := len(w.pending) - w.bufferSize; dropped > 0 {
+		slog.Warn("remote-write buffer full, dropping oldest pushes", "dropped", dropped)
+		w.pending = w.pending[dropped:]
+	}
+
+	var errs []error
+	for len(w.pending) > 0 {
+		err := w.send(ctx, w.pending[0])
+
+		var statusErr *statusError
+		if err != nil && (!errors.As(err, &statusErr) || statusErr.retryable()) {
+			return fmt.Errorf("remote-write failed, %d pushes buffered\n%v", len(w.pending), err)
+		}
+
+		// A request rejected by the receiver would be rejected again, so it is dropped
+		if err != nil {
+			errs = append(errs, err)
+		}
+		w.pending = w.pending[1:]
+	}
+
+	return errors.Join(errs...)
+}
+
+// send posts the request, retrying with backoff until it succeeds, fails permanently or the context is done
+func (w *RemoteWrite) send(ctx context.Context, req []byte) error {
+	body := snappy.Encode(nil, req)
+	backoff := w.minBackoff
+
+	for {
+		err := w.post(ctx, body)
+
+		var statusErr *statusError
+		if err == nil || (errors.As(err, &statusErr) && !statusErr.retryable()) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, w.maxBackoff)
+	}
+}
+
+func (w *RemoteWrite) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &statusError{code: resp.StatusCode, msg: string(bytes.TrimSpace(msg))}
+}
+
+// statusError is an unsuccessful response of the receiver
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d: %s", e.code, e.msg)
+}
+
+// retryable returns true for server errors and rate limiting, other errors are caused by the request
+func (e *statusError) retryable() bool {
+	return e.code >= http.StatusInternalServerError || e.code == http.StatusTooManyRequests
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	labels []label
+	value  float64
+	ts     int64
+}
+
+// timeSeries flattens the metric families into series, expanding summaries and histograms the way they are exposed
+func timeSeries(families []*dto.MetricFamily, ts time.Time, externalLabels map[string]string) []series {
+	var result []series
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			timestamp := ts.UnixMilli()
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...label) {
+				labels := append([]label{{"__name__", name}}, extra...)
+				labels = withExternalLabels(append(labels, metricLabels(m)...), externalLabels)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+				result = append(result, series{labels: labels, value: value, ts: timestamp})
+			}
+
+			name := family.GetName()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", m.GetSummary().GetSampleSum())
+				add(name+"_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				buckets := m.GetHistogram().GetBucket()
+				for _, b := range buckets {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), 1) {
+					add(name+"_bucket", float64(m.GetHistogram().GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", m.GetHistogram().GetSampleSum())
+				add(name+"_count", float64(m.GetHistogram().GetSampleCount()))
+			default:
+				add(name, m.GetUntyped().GetValue())
+			}
+		}
+	}
+
+	return result
+}
+
+func metricLabels(m *dto.Metric) []label {
+	labels := make([]label, 0, len(m.GetLabel()))
+	for _, pair := range m.GetLabel() {
+		labels = append(labels, label{pair.GetName(), pair.GetValue()})
+	}
+
+	return labels
+}
+
+// withExternalLabels adds the external labels that the series does not have
+func withExternalLabels(labels []label, externalLabels map[string]string) []label {
+	for name, value := range externalLabels {
+		if !slices.ContainsFunc(labels, func(l label) bool { return l.name == name }) {
+			labels = append(labels, label{name, value})
+		}
+	}
+
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the series as a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(all []series) []byte {
+	var req []byte
+	for _, s := range all {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.ts))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+
+	return req
+}
@@ -0,0 +1,214 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a prometheus.WriteRequest into "labels value@timestamp" strings
+func decodeWriteRequest(data []byte) []string {
+	var result []string
+	forEachField(data, func(_ protowire.Number, series []byte) {
+		var labels []string
+		var sample string
+		forEachField(series, func(num protowire.Number, field []byte) {
+			if num == 1 {
+				var pair []string
+				forEachField(field, func(_ protowire.Number, s []byte) { pair = append(pair, string(s)) })
+				labels = append(labels, pair[0]+"="+pair[1])
+				return
+			}
+
+			bits, n := protowire.ConsumeFixed64(field[1:])
+			Expect(n).To(BeNumerically(">", 0))
+			ts, n := protowire.ConsumeVarint(field[1+n+1:])
+			Expect(n).To(BeNumerically(">", 0))
+			sample = fmt.Sprintf("%v@%d", math.Float64frombits(bits), ts)
+		})
+		result = append(result, strings.Join(labels, ",")+" "+sample)
+	})
+
+	return result
+}
+
+// forEachField calls fn with the content of each length-delimited field of the message
+func forEachField(data []byte, fn func(protowire.Number, []byte)) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		Expect(n).To(BeNumerically(">", 0))
+		Expect(typ).To(Equal(protowire.BytesType))
+		data = data[n:]
+
+		field, n := protowire.ConsumeBytes(data)
+		Expect(n).To(BeNumerically(">", 0))
+		fn(num, field)
+		data = data[n:]
+	}
+}
+
+// remoteWriteReceiver is a remote-write endpoint answering with the given status codes in order, then 204. Its state
+// is only changed under its lock, which a handler holds until it answered.
+type remoteWriteReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	// failed is called after answering with a status code
+	failed   func()
+	requests [][]string
+}
+
+// fail sets the status codes to answer with and the function called after answering with each of them
+func (r *remoteWriteReceiver) fail(statuses []int, failed func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses, r.failed = statuses, failed
+}
+
+// received returns the decoded requests accepted so far
+func (r *remoteWriteReceiver) received() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func (r *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer GinkgoRecover()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	Expect(req.Header.Get("Content-Encoding")).To(Equal("snappy"))
+	Expect(req.Header.Get("X-Prometheus-Remote-Write-Version")).To(Equal("0.1.0"))
+
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		http.Error(w, http.StatusText(status), status)
+		if r.failed != nil {
+			r.failed()
+		}
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	Expect(err).ToNot(HaveOccurred())
+	data, err := snappy.Decode(nil, body)
+	Expect(err).ToNot(HaveOccurred())
+	r.requests = append(r.requests, decodeWriteRequest(data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var _ = Describe("test pushing with remote-write", func() { // RemoteWrite
+	var (
+		receiver *remoteWriteReceiver
+		sink     *RemoteWrite
+		ts       time.Time
+	)
+
+	BeforeEach(func() {
+		receiver = &remoteWriteReceiver{}
+		server := httptest.NewServer(receiver)
+		DeferCleanup(server.Close)
+
+		sink = NewRemoteWrite(server.URL, map[string]string{"node": "node1", "vf": "ignored"}, nil, 2)
+		sink.minBackoff = time.Millisecond
+		ts = time.UnixMilli(1000)
+	})
+
+	pushWithContext := func(ctx context.Context) error {
+		families, err := testGatherer().Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		err = sink.Push(ctx, families, ts)
+		ts = ts.Add(time.Second)
+
+		return err
+	}
+
+	push := func() error {
+		return pushWithContext(context.Background())
+	}
+
+	// failPush pushes while the receiver answers with a server error, which cancels the push so it is not retried
+	failPush := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		receiver.fail([]int{http.StatusInternalServerError}, cancel)
+
+		return pushWithContext(ctx)
+	}
+
+	It("sends the series with the external labels", func() {
+		Expect(push()).To(Succeed())
+
+		Expect(receiver.received()).To(Equal([][]string{
+			{"__name__=sriov_vf_rx_packets,node=node1,pf=ens1f0,vf=0 1000@1000"},
+		}))
+	})
+
+	It("retries server errors", func() {
+		receiver.fail([]int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, nil)
+
+		Expect(push()).To(Succeed())
+		Expect(receiver.received()).To(HaveLen(1))
+	})
+
+	It("buffers failed pushes and sends them first", func() {
+		Expect(failPush()).To(MatchError(ContainSubstring("1 pushes buffered")))
+		Expect(failPush()).To(MatchError(ContainSubstring("2 pushes buffered")))
+		Expect(failPush()).To(MatchError(ContainSubstring("2 pushes buffered")))
+
+		receiver.fail(nil, nil)
+		Expect(push()).To(Succeed())
+		Expect(sink.Pending()).To(Equal(0))
+		// the oldest push was dropped once the buffer was full
+		Expect(receiver.received()).To(Equal([][]string{
+			{"__name__=sriov_vf_rx_packets,node=node1,pf=ens1f0,vf=0 1000@3000"},
+			{"__name__=sriov_vf_rx_packets,node=node1,pf=ens1f0,vf=0 1000@4000"},
+		}))
+	})
+
+	It("drops pushes rejected by the receiver", func() {
+		receiver.fail([]int{http.StatusBadRequest}, nil)
+
+		Expect(push()).To(MatchError(ContainSubstring("server returned HTTP status 400")))
+		Expect(sink.Pending()).To(Equal(0))
+		Expect(receiver.received()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("test converting metric families to series", func() { // timeSeries
+	It("expands histograms and summaries", func() {
+		registry := prometheus.NewPedanticRegistry()
+		histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Help: "Latency", Buckets: []float64{1}})
+		histogram.Observe(0.5)
+		histogram.Observe(2)
+		summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "size", Help: "Size", Objectives: map[float64]float64{0.5: 0.05}})
+		summary.Observe(3)
+		registry.MustRegister(histogram, summary)
+
+		families, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(decodeWriteRequest(encodeWriteRequest(timeSeries(families, time.UnixMilli(5), nil)))).To(Equal([]string{
+			"__name__=latency_bucket,le=1 1@5",
+			"__name__=latency_bucket,le=+Inf 2@5",
+			"__name__=latency_sum 2.5@5",
+			"__name__=latency_count 2@5",
+			"__name__=size,quantile=0.5 3@5",
+			"__name__=size_sum 3@5",
+			"__name__=size_count 1@5",
+		}))
+	})
+})
@@ -0,0 +1,276 @@
+// Package snapshot writes and extracts the gzipped tar archives used to capture the host state read by the
+// collectors, so the metrics of a host can be reproduced elsewhere.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Archive layout, relative to the root of the archive
+const (
+	PCIDir               = "pci"                   // the PF directories of sys/bus/pci/devices and the ids and drivers of the VFs
+	DriverDir            = "drivers"               // the drivers linked from the PF and VF directories
+	NetDir               = "net"                   // the sysfs VF stats of sys/class/net
+	CgroupDir            = "cgroup"                // the cpusets of the kubernetes cgroups
+	NodeDir              = "node"                  // the cpus of each NUMA node
+	CheckpointDir        = "checkpoint"            // the cpu manager checkpoint
+	PodResourcesFile     = "podresources.json"     // the kubelet pod resources as a ListPodResourcesResponse
+	NetlinkFile          = "netlink.json"          // the netlink VF info keyed by PF name
+	DevicePluginFile     = "deviceplugin.json"     // the SR-IOV network device plugin config
+	DeviceCheckpointFile = "devicecheckpoint.json" // the kubelet device manager checkpoint
+)
+
+const (
+	dirMode  = 0o755
+	fileMode = 0o644
+
+	// maxFileSize limits the size of a single extracted file, sysfs and cgroup files are a few bytes long
+	maxFileSize = 64 << 20
+)
+
+// Writer adds directories, files and symlinks to a gzipped tar archive
+type Writer struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+	dirs    map[string]bool
+}
+
+// NewWriter returns a Writer writing the archive to w
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+	return &Writer{
+		gz:      gz,
+		tw:      tar.NewWriter(gz),
+		modTime: time.Now(),
+		dirs:    make(map[string]bool),
+	}
+}
+
+// Dir adds a directory and any missing parent directories
+func (w *Writer) Dir(name string) error {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if name == "." || w.dirs[name] {
+		return nil
+	}
+
+	if err := w.Dir(filepath.Dir(name)); err != nil {
+		return err
+	}
+
+	w.dirs[name] = true
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     dirMode,
+		ModTime:  w.modTime,
+	})
+}
+
+// File adds a regular file with the given content
+func (w *Writer) File(name string, data []byte) error {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if err := w.Dir(filepath.Dir(name)); err != nil {
+		return err
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     fileMode,
+		Size:     int64(len(data)),
+		ModTime:  w.modTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := w.tw.Write(data)
+	return err
+}
+
+// Symlink adds a symbolic link to target. The target is relative to the root of the archive, e.g. "drivers/ice",
+// and is linked relative to the symlink when the archive is extracted.
+func (w *Writer) Symlink(name, target string) error {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if err := checkTarget(target); err != nil {
+		return fmt.Errorf("%s - %v", name, err)
+	}
+
+	if err := w.Dir(filepath.Dir(name)); err != nil {
+		return err
+	}
+
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: filepath.ToSlash(target),
+		Mode:     fileMode,
+		ModTime:  w.modTime,
+	})
+}
+
+// Close completes the archive
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// Extract unpacks a gzipped tar archive into dir. Entries outside of dir, entries within a directory that is a
+// symlink and symlink targets that are absolute or contain ".." are rejected.
+func Extract(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("could not read archive\n%v", err)
+	}
+	defer gz.Close()
+
+	dir = filepath.Clean(dir)
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read archive\n%v", err)
+		}
+
+		path, err := within(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err == nil {
+			err = checkParents(root, name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s - could not extract\n%v", header.Name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = root.MkdirAll(name, dirMode)
+		case tar.TypeReg:
+			err = extractFile(root, tr, name, header.Size)
+		case tar.TypeSymlink:
+			err = extractSymlink(root, name, header.Linkname)
+		default:
+			err = fmt.Errorf("unsupported entry type '%c'", header.Typeflag)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s - could not extract\n%v", header.Name, err)
+		}
+	}
+}
+
+// checkParents returns an error if a parent directory of the named entry is a symlink. Entries are only created in
+// directories of the archive, so a symlink can not redirect them, even to another directory of the archive.
+func checkParents(root *os.Root, name string) error {
+	parent := filepath.Dir(name)
+	if parent == "." {
+		return nil
+	}
+
+	if err := checkParents(root, parent); err != nil {
+		return err
+	}
+
+	info, err := root.Lstat(parent)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("parent directory '%s' is a symlink", filepath.ToSlash(parent))
+	}
+
+	return nil
+}
+
+func extractFile(root *os.Root, r io.Reader, name string, size int64) error {
+	if size > maxFileSize {
+		return fmt.Errorf("file is larger than %d bytes", maxFileSize)
+	}
+
+	if err := root.MkdirAll(filepath.Dir(name), dirMode); err != nil {
+		return err
+	}
+
+	f, err := root.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// extractSymlink links the named entry to the target, which is relative to the root of the archive. As the parents
+// of the entry are not symlinks, the link is made relative to the entry by going up to the root of the archive.
+func extractSymlink(root *os.Root, name, target string) error {
+	if err := checkTarget(target); err != nil {
+		return err
+	}
+
+	link, err := filepath.Rel(filepath.Dir(name), filepath.FromSlash(target))
+	if err != nil {
+		return err
+	}
+
+	if err := root.MkdirAll(filepath.Dir(name), dirMode); err != nil {
+		return err
+	}
+
+	return root.Symlink(link, name)
+}
+
+// checkTarget returns an error if a symlink target is absolute or contains "..", so it can only point into the archive
+func checkTarget(target string) error {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return fmt.Errorf("absolute symlink target '%s'", target)
+	}
+
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		if elem == ".." {
+			return fmt.Errorf("symlink target '%s' contains '..'", target)
+		}
+	}
+
+	return nil
+}
+
+// within returns the path of name in dir, or an error if it would be outside of dir
+func within(dir, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s - path is outside of the archive", name)
+	}
+
+	return path, nil
+}
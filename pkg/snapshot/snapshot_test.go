@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSnapshot(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "snapshot test suite")
+}
+
+// rawArchive builds a gzipped tar archive from the given headers, without the checks done by Writer
+func rawArchive(headers ...*tar.Header) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, header := range headers {
+		Expect(tw.WriteHeader(header)).To(Succeed())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+
+	return buf
+}
+
+var _ = Describe("test writing and extracting archives", func() { // Writer, Extract
+	It("round trips directories, files and symlinks", func() {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		Expect(w.File("pci/0000:3b:00.0/sriov_numvfs", []byte("2"))).To(Succeed())
+		Expect(w.Dir("pci/0000:3b:02.0")).To(Succeed())
+		Expect(w.Symlink("pci/0000:3b:00.0/virtfn0", "pci/0000:3b:02.0")).To(Succeed())
+		Expect(w.Dir("pci/0000:3b:00.0/net/ens785f0")).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		Expect(Extract(buf, dir)).To(Succeed())
+
+		data, err := os.ReadFile(filepath.Join(dir, "pci/0000:3b:00.0/sriov_numvfs"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("2"))
+
+		link, err := filepath.EvalSymlinks(filepath.Join(dir, "pci/0000:3b:00.0/virtfn0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filepath.Base(link)).To(Equal("0000:3b:02.0"))
+
+		Expect(filepath.Join(dir, "pci/0000:3b:00.0/net/ens785f0")).To(BeADirectory())
+	})
+
+	DescribeTable("rejects entries outside of the archive",
+		func(header *tar.Header, expectedErr string) {
+			Expect(Extract(rawArchive(header), GinkgoT().TempDir())).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("file path", &tar.Header{Typeflag: tar.TypeReg, Name: "../escape"}, "path is outside of the archive"),
+		Entry("relative symlink target", &tar.Header{Typeflag: tar.TypeSymlink, Name: "pci/link", Linkname: "../../etc"},
+			"symlink target '../../etc' contains '..'"),
+		Entry("symlink target within the archive", &tar.Header{Typeflag: tar.TypeSymlink, Name: "pci/link", Linkname: "pci/../net"},
+			"symlink target 'pci/../net' contains '..'"),
+		Entry("absolute symlink target", &tar.Header{Typeflag: tar.TypeSymlink, Name: "pci/link", Linkname: "/etc"},
+			"absolute symlink target '/etc'"),
+		Entry("hard link", &tar.Header{Typeflag: tar.TypeLink, Name: "pci/link", Linkname: "pci/file"},
+			"unsupported entry type"),
+	)
+
+	It("rejects entries within a symlinked directory", func() {
+		dir := filepath.Join(GinkgoT().TempDir(), "extract")
+		Expect(os.Mkdir(dir, 0o700)).To(Succeed())
+
+		archive := rawArchive(
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "q/s", Linkname: ".."},
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "t", Linkname: "q/s/.."},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "t/escaped.txt", Mode: 0o644},
+		)
+		Expect(Extract(archive, dir)).To(HaveOccurred())
+		Expect(filepath.Join(filepath.Dir(dir), "escaped.txt")).ToNot(BeAnExistingFile())
+
+		archive = rawArchive(
+			&tar.Header{Typeflag: tar.TypeDir, Name: "drivers/ice/", Mode: 0o755},
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "pci/driver", Linkname: "drivers/ice"},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "pci/driver/escaped.txt", Mode: 0o644},
+		)
+		Expect(Extract(archive, GinkgoT().TempDir())).To(MatchError(ContainSubstring("parent directory 'pci/driver' is a symlink")))
+	})
+
+	It("rejects data that is not an archive", func() {
+		Expect(Extract(bytes.NewBufferString("not an archive"), GinkgoT().TempDir())).To(MatchError(ContainSubstring("could not read archive")))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -15,6 +16,11 @@ func (list *StringListFlag) String() string {
 }
 
 func (list *StringListFlag) Set(val string) error {
+	if strings.TrimSpace(val) == "" {
+		*list = StringListFlag{}
+		return nil
+	}
+
 	*list = strings.Split(val, ",")
 
 	for i := range *list {
@@ -24,6 +30,41 @@ func (list *StringListFlag) Set(val string) error {
 	return nil
 }
 
+// Comma-separated key=value string flag type
+type StringMapFlag map[string]string
+
+func (m *StringMapFlag) String() string {
+	pairs := make([]string, 0, len(*m))
+	for key, value := range *m {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *StringMapFlag) Set(val string) error {
+	parsed := make(StringMapFlag)
+	if strings.TrimSpace(val) == "" {
+		*m = parsed
+		return nil
+	}
+
+	for _, pair := range strings.Split(val, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" || value == "" {
+			return fmt.Errorf("invalid key=value pair '%s'", strings.TrimSpace(pair))
+		}
+
+		parsed[key] = value
+	}
+
+	*m = parsed
+
+	return nil
+}
+
 func ResolveFlag(flag string, path *string) error {
 	if err := ResolvePath(path); err != nil {
 		return fmt.Errorf("%s - %v", flag, err)
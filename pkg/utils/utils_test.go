@@ -87,6 +87,30 @@ var _ = DescribeTable("test StringListFlag type", // StringListFlag
 	Entry("just one value", "sysfs", StringListFlag{"sysfs"}, "sysfs"),
 	Entry("two values", "sysfs,netlink", StringListFlag{"sysfs", "netlink"}, "sysfs,netlink"),
 	Entry("odd formatting", " sysfs ,   netlink ", StringListFlag{"sysfs", "netlink"}, "sysfs,netlink"),
+	Entry("empty value", "", StringListFlag{}, ""),
+)
+
+var _ = DescribeTable("test StringMapFlag type", // StringMapFlag
+	func(input string, expectedMap StringMapFlag, expectedString string, expectedErr error) {
+		var m StringMapFlag
+		err := m.Set(input)
+
+		if expectedErr != nil {
+			Expect(err).To(Equal(expectedErr))
+			return
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).To(Equal(expectedMap))
+		Expect(m.String()).To(Equal(expectedString))
+	},
+	Entry("just one pair", "ice=sysfs", StringMapFlag{"ice": "sysfs"}, "ice=sysfs", nil),
+	Entry("two pairs", "mlx5_core=netlink,0000:3b:00.0=sysfs",
+		StringMapFlag{"mlx5_core": "netlink", "0000:3b:00.0": "sysfs"}, "0000:3b:00.0=sysfs,mlx5_core=netlink", nil),
+	Entry("odd formatting", " ice = sysfs ,  i40e=netlink ", StringMapFlag{"ice": "sysfs", "i40e": "netlink"}, "i40e=netlink,ice=sysfs", nil),
+	Entry("empty value", "", StringMapFlag{}, "", nil),
+	Entry("missing value", "ice=", nil, "", fmt.Errorf("invalid key=value pair 'ice='")),
+	Entry("missing separator", "ice", nil, "", fmt.Errorf("invalid key=value pair 'ice'")),
 )
 
 func getAbsPath(fp string) string {
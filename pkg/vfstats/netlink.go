@@ -2,7 +2,7 @@
 package vfstats
 
 import (
-	"log"
+	"log/slog"
 
 	"github.com/vishvananda/netlink"
 )
@@ -18,7 +18,7 @@ func VfStats(pf string) PerPF {
 	output := PerPF{pf, make(map[int]netlink.VfInfo)}
 	lnk, err := GetLink(pf)
 	if err != nil {
-		log.Printf("netlink: error retrieving link for pf '%s'\n%v", pf, err)
+		slog.Warn("netlink: could not retrieve link", "pf", pf, "err", err)
 		return output
 	}
 
@@ -29,6 +29,28 @@ func VfStats(pf string) PerPF {
 	return output
 }
 
+// Dump returns the stats for the SRIOV Virtual Functions of every link on the host, keyed by link name.
+// A single netlink link dump is used regardless of the number of Physical Functions.
+func Dump() map[string]PerPF {
+	output := make(map[string]PerPF)
+	links, err := ListLinks()
+	if err != nil {
+		slog.Warn("netlink: could not list links", "err", err)
+		return output
+	}
+
+	for _, lnk := range links {
+		attrs := lnk.Attrs()
+		perPF := PerPF{attrs.Name, make(map[int]netlink.VfInfo, len(attrs.Vfs))}
+		for _, vf := range attrs.Vfs {
+			perPF.Vfs[vf.ID] = vf
+		}
+		output[attrs.Name] = perPF
+	}
+
+	return output
+}
+
 // DoesPfSupportNetlink returns true if the Physical Function supports the netlink APIs
 func DoesPfSupportNetlink(pf string) bool {
 	_, err := GetLink(pf)
@@ -36,3 +58,5 @@ func DoesPfSupportNetlink(pf string) bool {
 }
 
 var GetLink = netlink.LinkByName
+
+var ListLinks = netlink.LinkList
@@ -57,3 +57,38 @@ var _ = DescribeTable("test vf stats collection", // VfStats
 		PerPF{"ens801f0", map[int]netlink.VfInfo{}},
 	),
 )
+
+var _ = DescribeTable("test vf stats dump", // Dump
+	func(links []netlink.Link, err error, expected map[string]PerPF) {
+		ListLinks = func() ([]netlink.Link, error) {
+			return links, err
+		}
+		DeferCleanup(func() {
+			ListLinks = netlink.LinkList
+		})
+
+		Expect(Dump()).To(Equal(expected))
+	},
+	Entry("Without error",
+		[]netlink.Link{
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "ens801f0", Vfs: []netlink.VfInfo{
+				{ID: 0, RxPackets: 11, TxPackets: 12},
+				{ID: 1, RxPackets: 21, TxPackets: 22},
+			}}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo"}},
+		},
+		nil,
+		map[string]PerPF{
+			"ens801f0": {"ens801f0", map[int]netlink.VfInfo{
+				0: {ID: 0, RxPackets: 11, TxPackets: 12},
+				1: {ID: 1, RxPackets: 21, TxPackets: 22},
+			}},
+			"lo": {"lo", map[int]netlink.VfInfo{}},
+		},
+	),
+	Entry("With error",
+		nil,
+		fmt.Errorf("dump interrupted"),
+		map[string]PerPF{},
+	),
+)
@@ -0,0 +1,306 @@
+//go:build e2e
+
+// Package e2e runs the exporter binary against a generated sysfs tree and a fake kubelet, and asserts on the
+// scraped metrics. Run with: go test -tags e2e ./test/e2e
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakehost"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakekubelet"
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/snapshot"
+)
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "e2e test suite")
+}
+
+var exporterPath string
+
+var _ = BeforeSuite(func() {
+	var err error
+	exporterPath, err = gexec.Build("github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/cmd")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(gexec.CleanupBuildArtifacts)
+})
+
+// exporter is a running exporter binary
+type exporter struct {
+	session *gexec.Session
+	addr    string
+}
+
+// startExporter starts the exporter with the flags and waits for it to serve metrics
+func startExporter(args ...string) *exporter {
+	return startExporterWithEnv(nil, args...)
+}
+
+// startExporterWithEnv starts the exporter with additional environment variables
+func startExporterWithEnv(env []string, args ...string) *exporter {
+	addr := freeAddr()
+	cmd := exec.Command(exporterPath, append([]string{"--web.listen-address=" + addr, "--web.rate-limit=100"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+	Expect(err).ToNot(HaveOccurred())
+
+	e := &exporter{session: session, addr: addr}
+	DeferCleanup(e.stop)
+	Eventually(e.scrape).WithTimeout(10 * time.Second).ShouldNot(BeEmpty())
+
+	return e
+}
+
+// scrape returns the metrics served by the exporter, or an empty string if they can not be scraped
+func (e *exporter) scrape() string {
+	resp, err := http.Get("http://" + e.addr + "/metrics") //nolint:noctx
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	return string(body)
+}
+
+// stop terminates the exporter and expects a clean exit
+func (e *exporter) stop() {
+	e.session.Signal(syscall.SIGTERM)
+	Eventually(e.session).WithTimeout(10 * time.Second).Should(gexec.Exit(0))
+}
+
+// freeAddr returns a local address with a free port
+func freeAddr() string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	defer lis.Close()
+
+	return lis.Addr().String()
+}
+
+// writeHost generates the default host as a sysfs tree in a temporary directory
+func writeHost() string {
+	archive := &bytes.Buffer{}
+	Expect(fakehost.New(fakehost.DefaultOptions).WriteSnapshot(archive)).To(Succeed())
+
+	dir := GinkgoT().TempDir()
+	Expect(snapshot.Extract(archive, dir)).To(Succeed())
+
+	return dir
+}
+
+// pathFlags returns the path flags of the exporter for a host written by writeHost and a kubelet socket, with the
+// device manager checkpoint next to the socket
+func pathFlags(host, socket string) []string {
+	return []string{
+		"--path.sysbuspci=" + filepath.Join(host, snapshot.PCIDir),
+		"--path.sysclassnet=" + filepath.Join(host, snapshot.NetDir),
+		"--path.kubecgroup=" + filepath.Join(host, snapshot.CgroupDir),
+		"--path.nodecpuinfo=" + filepath.Join(host, snapshot.NodeDir),
+		"--path.cpucheckpoint=" + filepath.Join(host, snapshot.CheckpointDir, "cpu_manager_state"),
+		"--path.kubeletsocket=" + socket,
+		"--path.devicecheckpoint=" + filepath.Join(filepath.Dir(socket), "kubelet_internal_checkpoint"),
+	}
+}
+
+// startKubelet serves the scenario on a unix socket and returns its path
+func startKubelet(scenarioPath string) string {
+	scenario, err := fakekubelet.LoadScenario(scenarioPath)
+	Expect(err).ToNot(HaveOccurred())
+
+	// unix socket paths are limited in length, so the socket is not created in the deeper test directory
+	dir, err := os.MkdirTemp("", "kubelet")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(os.RemoveAll, dir)
+
+	socket := filepath.Join(dir, "kubelet.sock")
+	server := fakekubelet.NewServer(scenario)
+	Expect(server.Start(socket)).To(Succeed())
+	DeferCleanup(server.Stop)
+
+	return socket
+}
+
+// receiver is a stand-in for an OTLP receiver, keeping the received requests
+type receiver struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*colmetricpb.ExportMetricsServiceRequest
+}
+
+func (r *receiver) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) received() []*colmetricpb.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.requests
+}
+
+// startReceiver serves an OTLP receiver over gRPC and returns its address
+func startReceiver(r *receiver) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, r)
+	go func() { _ = server.Serve(lis) }()
+	DeferCleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+var _ = Describe("test scraping the exporter", func() {
+	It("serves the vf stats, pod devices and pod cpus of the host", func() {
+		host := writeHost()
+		socket := startKubelet(filepath.Join("testdata", "scenario.yaml"))
+
+		e := startExporter(append(pathFlags(host, socket),
+			"--collector.vfstatspriority=sysfs",
+			"--collector.kubepoddevice",
+			"--collector.kubepodcpu",
+		)...)
+
+		metrics := e.scrape()
+		Expect(metrics).To(ContainSubstring(`sriov_vf_rx_packets{numa_node="0",pciAddr="0000:01:01.0",pf="ens1f0",vf="0"} 1.001e+06`))
+		Expect(metrics).To(ContainSubstring(`sriov_vf_tx_bytes{numa_node="1",pciAddr="0000:02:01.3",pf="ens2f0",vf="3"} 2.56512e+08`))
+		Expect(metrics).To(ContainSubstring(`sriov_pf_stats_reader_info{pf="ens2f0",reader="sysfs"} 1`))
+		Expect(metrics).To(ContainSubstring(
+			`sriov_kubepoddevice{container="app",dev_type="example.com/sriov",namespace="default",pciAddr="0000:02:01.0",pod="pod2"} 1`))
+		Expect(metrics).To(ContainSubstring(`sriov_kubepodcpu{container_id="%s",cpu_id="1",numa_node="0",uid="00000001_0000_4000_8000_000000000001"} 1`,
+			fmt.Sprintf("%064x", 1)))
+		Expect(metrics).To(ContainSubstring(`sriov_collector_success{collector="kubepoddevice"} 1`))
+	})
+
+	It("serves the pod cpus from the kubelet pod resources", func() {
+		host := writeHost()
+		socket := startKubelet(filepath.Join("testdata", "scenario.yaml"))
+
+		e := startExporter(
+			"--path.sysbuspci="+filepath.Join(host, snapshot.PCIDir),
+			"--path.sysclassnet="+filepath.Join(host, snapshot.NetDir),
+			"--path.nodecpuinfo="+filepath.Join(host, snapshot.NodeDir),
+			"--path.kubeletsocket="+socket,
+			"--path.kubecgroup=/nonexistent",
+			"--collector.kubepodcpu",
+			"--collector.kubepodcpusource=podresources",
+		)
+
+		metrics := e.scrape()
+		Expect(metrics).To(ContainSubstring(`sriov_kubepodcpu{container="app",cpu_id="1",namespace="default",numa_node="0",pod="pod1"} 1`))
+		Expect(metrics).To(ContainSubstring(`sriov_collector_success{collector="kubepodcpu"} 1`))
+	})
+
+	It("reports a failure when the kubelet is unavailable", func() {
+		host := writeHost()
+		socket := startKubelet(filepath.Join("testdata", "unavailable.yaml"))
+
+		e := startExporter(append(pathFlags(host, socket), "--collector.kubepoddevice")...)
+
+		metrics := e.scrape()
+		Expect(metrics).To(ContainSubstring(`sriov_collector_success{collector="kubepoddevice"} 0`))
+		Expect(metrics).To(ContainSubstring(`sriov_vf_rx_packets{numa_node="0",pciAddr="0000:01:01.0",pf="ens1f0",vf="0"} 1.001e+06`))
+	})
+})
+
+var _ = Describe("test pushing metrics over otlp", func() {
+	It("pushes the vf stats with the node as resource while serving them", func() {
+		host := writeHost()
+		socket := startKubelet(filepath.Join("testdata", "scenario.yaml"))
+		r := &receiver{}
+		endpoint := startReceiver(r)
+
+		e := startExporterWithEnv([]string{"NODE_NAME=node1"}, append(pathFlags(host, socket),
+			"--collector.vfstatspriority=sysfs",
+			"--otlp.endpoint="+endpoint,
+			"--otlp.insecure",
+			"--otlp.interval=100ms",
+		)...)
+		Expect(e.scrape()).To(ContainSubstring(`sriov_vf_rx_packets{numa_node="0",pciAddr="0000:01:01.0",pf="ens1f0",vf="0"} 1.001e+06`))
+		Eventually(r.received).WithTimeout(10 * time.Second).ShouldNot(BeEmpty())
+
+		resourceMetrics := r.received()[0].GetResourceMetrics()[0]
+		Expect(resourceMetrics.GetResource().GetAttributes()).To(ContainElement(
+			HaveField("Value.GetStringValue()", "node1")))
+
+		var points []string
+		for _, metric := range resourceMetrics.GetScopeMetrics()[0].GetMetrics() {
+			if metric.GetName() != "sriov_vf_rx_packets" {
+				continue
+			}
+			for _, point := range metric.GetSum().GetDataPoints() {
+				attrs := map[string]string{}
+				for _, kv := range point.GetAttributes() {
+					attrs[kv.GetKey()] = kv.GetValue().GetStringValue()
+				}
+				points = append(points, fmt.Sprintf("%s/%s %v", attrs["pf"], attrs["vf"], point.GetAsDouble()))
+			}
+		}
+		Expect(points).To(ContainElement("ens1f0/0 1.001e+06"))
+	})
+})
+
+var _ = Describe("test pushing metrics to a pushgateway", func() {
+	It("pushes the vf stats of the node instead of serving them", func() {
+		host := writeHost()
+		socket := startKubelet(filepath.Join("testdata", "scenario.yaml"))
+
+		pushes := make(chan string, 10)
+		gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			select {
+			case pushes <- r.Method + " " + r.URL.Path + " " + string(body):
+			default:
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		DeferCleanup(gateway.Close)
+
+		cmd := exec.Command(exporterPath, append(pathFlags(host, socket),
+			"--collector.vfstatspriority=sysfs",
+			"--push.mode=pushgateway",
+			"--push.url="+gateway.URL,
+			"--push.node-name=node1",
+		)...)
+		session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		e := &exporter{session: session}
+		DeferCleanup(e.stop)
+
+		var push string
+		Eventually(pushes).WithTimeout(10 * time.Second).Should(Receive(&push))
+		Expect(push).To(HavePrefix("PUT /metrics/job/sriov-network-metrics-exporter/node/node1 "))
+		Expect(push).To(ContainSubstring("sriov_vf_rx_packets"))
+	})
+})
@@ -0,0 +1,63 @@
+// fake-kubelet serves the kubelet pod resources API from a scenario file on a unix socket, so the exporter can be
+// run against it during local development. The scenario is reloaded on SIGHUP.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/k8snetworkplumbingwg/sriov-network-metrics-exporter/pkg/fakekubelet"
+)
+
+var (
+	socket       = flag.String("socket", "/tmp/kubelet.sock", "Path of the unix socket to serve the pod resources API on.")
+	scenarioFile = flag.String("scenario", "", "Path to the YAML or JSON scenario file.")
+)
+
+func main() {
+	flag.Parse()
+
+	scenario, err := fakekubelet.LoadScenario(*scenarioFile)
+	if err != nil {
+		slog.Error("failed to load scenario", "err", err)
+		os.Exit(1)
+	}
+
+	if err := os.Remove(*socket); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to remove socket", "path", *socket, "err", err)
+		os.Exit(1)
+	}
+
+	server := fakekubelet.NewServer(scenario)
+	if err := server.Start(*socket); err != nil {
+		slog.Error("failed to serve", "path", *socket, "err", err)
+		os.Exit(1)
+	}
+	defer server.Stop()
+	slog.Info("serving pod resources", "path", *socket, "pods", len(scenario.Pods))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			scenario, err := fakekubelet.LoadScenario(*scenarioFile)
+			if err != nil {
+				slog.Warn("failed to reload scenario, keeping the previous one", "err", err)
+				continue
+			}
+			server.SetScenario(scenario)
+			slog.Info("scenario reloaded", "pods", len(scenario.Pods))
+		}
+	}
+}